@@ -2,11 +2,16 @@ package configs
 
 import (
 	"context"
+	"fmt"
 	"log"
+	"net/http"
 	"os"
+	"slices"
 	"strconv"
+	"strings"
 	"time"
 
+	"github.com/adityaw24/go-aws-garasi/utils"
 	"github.com/aws/aws-sdk-go-v2/config"
 	"github.com/aws/aws-sdk-go-v2/credentials"
 	"github.com/aws/aws-sdk-go-v2/service/s3"
@@ -14,17 +19,87 @@ import (
 )
 
 type Config struct {
-	ACCESS_KEY_ID               string `mapstructure:"ACCESS_KEY_ID"`
-	SECRET_ACCESS_KEY           string `mapstructure:"SECRET_ACCESS_KEY"`
-	BUCKET_NAME                 string `mapstructure:"S3_BUCKET_NAME"`
-	REGION                      string `mapstructure:"REGION"`
-	S3_BUCKET_ACCESS_KEY        string `mapstructure:"S3_BUCKET_ACCESS_KEY"`
-	S3_BUCKET_SECRET_ACCESS_KEY string `mapstructure:"S3_BUCKET_SECRET_ACCESS_KEY"`
-	TIMEOUT                     int    `mapstructure:"TIMEOUT"`
-	API_GROUP                   string `mapstructure:"API_GROUP"`
-	PORT                        int    `mapstructure:"PORT"`
+	ACCESS_KEY_ID               string                     `mapstructure:"ACCESS_KEY_ID"`
+	SECRET_ACCESS_KEY           string                     `mapstructure:"SECRET_ACCESS_KEY"`
+	BUCKET_NAME                 string                     `mapstructure:"S3_BUCKET_NAME"`
+	REGION                      string                     `mapstructure:"REGION"`
+	S3_BUCKET_ACCESS_KEY        string                     `mapstructure:"S3_BUCKET_ACCESS_KEY"`
+	S3_BUCKET_SECRET_ACCESS_KEY string                     `mapstructure:"S3_BUCKET_SECRET_ACCESS_KEY"`
+	TIMEOUT                     int                        `mapstructure:"TIMEOUT"`
+	API_GROUP                   string                     `mapstructure:"API_GROUP"`
+	PORT                        int                        `mapstructure:"PORT"`
+	DUPLICATE_POLICY            string                     `mapstructure:"DUPLICATE_POLICY"`
+	DUPLICATE_DISTANCE          int                        `mapstructure:"DUPLICATE_DISTANCE"`
+	WATERMARK_PATH              string                     `mapstructure:"WATERMARK_PATH"`
+	WATERMARK_POSITION          string                     `mapstructure:"WATERMARK_POSITION"`
+	WATERMARK_OPACITY           float64                    `mapstructure:"WATERMARK_OPACITY"`
+	WATERMARK_SCALE             float64                    `mapstructure:"WATERMARK_SCALE"`
+	WATERMARK_URL_SECRET        string                     `mapstructure:"WATERMARK_URL_SECRET"`
+	WATERMARK_MAX_PIXELS        int                        `mapstructure:"WATERMARK_MAX_PIXELS"`
+	WATERMARK_CACHE_SIZE        int                        `mapstructure:"WATERMARK_CACHE_SIZE"`
+	OPTIMIZE_ENABLED            bool                       `mapstructure:"OPTIMIZE_ENABLED"`
+	OPTIMIZE_JPEG_QUALITY       int                        `mapstructure:"OPTIMIZE_JPEG_QUALITY"`
+	OPTIMIZE_CONVERT            map[string]string          `mapstructure:"OPTIMIZE_CONVERT"`
+	OPTIMIZE_KEEP_ORIGINAL      bool                       `mapstructure:"OPTIMIZE_KEEP_ORIGINAL"`
+	DEDUP_ENABLED               bool                       `mapstructure:"DEDUP_ENABLED"`
+	IDEMPOTENCY_TTL             int                        `mapstructure:"IDEMPOTENCY_TTL"`
+	IDEMPOTENCY_MAX_BODY        int                        `mapstructure:"IDEMPOTENCY_MAX_BODY"`
+	KEY_STRATEGY                string                     `mapstructure:"KEY_STRATEGY"`
+	KEY_TEMPLATE                utils.KeyTemplate          `mapstructure:"KEY_TEMPLATE"`
+	METADATA_SCHEMA             utils.MetadataSchema       `mapstructure:"METADATA_SCHEMA"`
+	GC_ENABLED                  bool                       `mapstructure:"GC_ENABLED"`
+	GC_GRACE_PERIOD             int                        `mapstructure:"GC_GRACE_PERIOD"`
+	GC_INTERVAL                 int                        `mapstructure:"GC_INTERVAL"`
+	AUTH_ENABLED                bool                       `mapstructure:"AUTH_ENABLED"`
+	JWT_SECRET                  string                     `mapstructure:"JWT_SECRET"`
+	JWT_PUBLIC_KEY_FILE         string                     `mapstructure:"JWT_PUBLIC_KEY_FILE"`
+	JWT_JWKS_FILE               string                     `mapstructure:"JWT_JWKS_FILE"`
+	JWT_ISSUER                  string                     `mapstructure:"JWT_ISSUER"`
+	JWT_AUDIENCE                string                     `mapstructure:"JWT_AUDIENCE"`
+	JWT_ADMIN_ROLES             []string                   `mapstructure:"JWT_ADMIN_ROLES"`
+	TENANT_CLAIM                string                     `mapstructure:"TENANT_CLAIM"`
+	TENANT_REQUIRED             bool                       `mapstructure:"TENANT_REQUIRED"`
+	SHARE_BASE_URL              string                     `mapstructure:"SHARE_BASE_URL"`
+	SHARE_DEFAULT_TTL           int                        `mapstructure:"SHARE_DEFAULT_TTL"`
+	SHARE_MAX_TTL               int                        `mapstructure:"SHARE_MAX_TTL"`
+	RATE_LIMIT_IP               utils.RateLimit            `mapstructure:"RATE_LIMIT_IP"`
+	RATE_LIMIT_API_KEY          utils.RateLimit            `mapstructure:"RATE_LIMIT_API_KEY"`
+	RATE_LIMIT_ROUTES           map[string]utils.RateLimit `mapstructure:"RATE_LIMIT_ROUTES"`
+	MAX_CONCURRENT_UPLOADS      int                        `mapstructure:"MAX_CONCURRENT_UPLOADS"`
+	TRUSTED_PROXIES             []string                   `mapstructure:"TRUSTED_PROXIES"`
+	UPLOAD_BANDWIDTH_LIMIT      int                        `mapstructure:"UPLOAD_BANDWIDTH_LIMIT"`
+	CORS_ALLOWED_ORIGINS        []utils.OriginPattern      `mapstructure:"CORS_ALLOWED_ORIGINS"`
+	CORS_ALLOW_CREDENTIALS      bool                       `mapstructure:"CORS_ALLOW_CREDENTIALS"`
+	CORS_METHODS                []string                   `mapstructure:"CORS_METHODS"`
+	CORS_ROUTE_METHODS          map[string][]string        `mapstructure:"CORS_ROUTE_METHODS"`
+	CORS_EXPOSED_HEADERS        []string                   `mapstructure:"CORS_EXPOSED_HEADERS"`
+	CORS_MAX_AGE                int                        `mapstructure:"CORS_MAX_AGE"`
+	SSE                         utils.ServerSideEncryption `mapstructure:"SSE_MODE"`
+	ENVELOPE_MASTER_KEYS        *utils.MasterKeys          `mapstructure:"ENVELOPE_MASTER_KEYS"`
+	ENVELOPE_ENCRYPT_ALL        bool                       `mapstructure:"ENVELOPE_ENCRYPT_ALL"`
 }
 
+// String prints the configuration with its secrets redacted, like
+// utils.ServerSideEncryption and utils.MasterKeys do for theirs.
+func (c Config) String() string {
+	type plainConfig Config
+	plain := plainConfig(c)
+	for _, secret := range []*string{&plain.SECRET_ACCESS_KEY, &plain.S3_BUCKET_SECRET_ACCESS_KEY, &plain.JWT_SECRET, &plain.WATERMARK_URL_SECRET} {
+		if *secret != "" {
+			*secret = "[redacted]"
+		}
+	}
+	return fmt.Sprintf("%+v", plain)
+}
+
+// defaultCORSExposedHeaders are the response headers browsers may read.
+const defaultCORSExposedHeaders = "ETag, Content-Range, Content-Length, Content-Disposition, Retry-After, X-Checksum-Sha256, X-Checksum-Crc32c, Idempotent-Replayed"
+
+const (
+	DuplicatePolicyAllow  = "allow"
+	DuplicatePolicyReject = "reject"
+)
+
 func LoadConfig(path string) (config Config, err error) {
 	// viper.AddConfigPath(filepath.Join(path))
 	// viper.SetConfigName(".env")
@@ -52,11 +127,195 @@ func LoadConfig(path string) (config Config, err error) {
 		TIMEOUT:                     timeout,
 		API_GROUP:                   os.Getenv("API_GROUP"),
 		PORT:                        port,
+		DUPLICATE_POLICY:            getEnvString("DUPLICATE_POLICY", DuplicatePolicyAllow),
+		DUPLICATE_DISTANCE:          getEnvInt("DUPLICATE_DISTANCE", 5),
+		WATERMARK_PATH:              os.Getenv("WATERMARK_PATH"),
+		WATERMARK_POSITION:          getEnvString("WATERMARK_POSITION", "bottom-right"),
+		WATERMARK_OPACITY:           getEnvFloat("WATERMARK_OPACITY", 0.5),
+		WATERMARK_SCALE:             getEnvFloat("WATERMARK_SCALE", 0.2),
+		WATERMARK_URL_SECRET:        os.Getenv("WATERMARK_URL_SECRET"),
+		WATERMARK_MAX_PIXELS:        getEnvInt("WATERMARK_MAX_PIXELS", 40000000),
+		WATERMARK_CACHE_SIZE:        getEnvInt("WATERMARK_CACHE_SIZE", 67108864),
+		OPTIMIZE_ENABLED:            getEnvBool("OPTIMIZE_ENABLED", false),
+		OPTIMIZE_JPEG_QUALITY:       getEnvInt("OPTIMIZE_JPEG_QUALITY", 85),
+		OPTIMIZE_KEEP_ORIGINAL:      getEnvBool("OPTIMIZE_KEEP_ORIGINAL", false),
+		DEDUP_ENABLED:               getEnvBool("DEDUP_ENABLED", false),
+		IDEMPOTENCY_TTL:             getEnvInt("IDEMPOTENCY_TTL", 86400),
+		IDEMPOTENCY_MAX_BODY:        getEnvInt("IDEMPOTENCY_MAX_BODY", 1073741824),
+		KEY_STRATEGY:                getEnvString("KEY_STRATEGY", utils.KeyStrategyLegacy),
+		GC_ENABLED:                  getEnvBool("GC_ENABLED", false),
+		GC_GRACE_PERIOD:             getEnvInt("GC_GRACE_PERIOD", 604800),
+		GC_INTERVAL:                 getEnvInt("GC_INTERVAL", 3600),
+		AUTH_ENABLED:                getEnvBool("AUTH_ENABLED", false),
+		JWT_SECRET:                  os.Getenv("JWT_SECRET"),
+		JWT_PUBLIC_KEY_FILE:         os.Getenv("JWT_PUBLIC_KEY_FILE"),
+		JWT_JWKS_FILE:               os.Getenv("JWT_JWKS_FILE"),
+		JWT_ISSUER:                  os.Getenv("JWT_ISSUER"),
+		JWT_AUDIENCE:                os.Getenv("JWT_AUDIENCE"),
+		JWT_ADMIN_ROLES:             splitList(os.Getenv("JWT_ADMIN_ROLES")),
+		TENANT_CLAIM:                getEnvString("TENANT_CLAIM", "tenant"),
+		TENANT_REQUIRED:             getEnvBool("TENANT_REQUIRED", false),
+		SHARE_BASE_URL:              strings.TrimSuffix(os.Getenv("SHARE_BASE_URL"), "/"),
+		SHARE_DEFAULT_TTL:           getEnvInt("SHARE_DEFAULT_TTL", 86400),
+		SHARE_MAX_TTL:               getEnvInt("SHARE_MAX_TTL", 2592000),
+		MAX_CONCURRENT_UPLOADS:      getEnvInt("MAX_CONCURRENT_UPLOADS", 0),
+		TRUSTED_PROXIES:             splitList(os.Getenv("TRUSTED_PROXIES")),
+		UPLOAD_BANDWIDTH_LIMIT:      getEnvInt("UPLOAD_BANDWIDTH_LIMIT", 0),
+		CORS_ALLOW_CREDENTIALS:      getEnvBool("CORS_ALLOW_CREDENTIALS", false),
+		CORS_EXPOSED_HEADERS:        splitList(getEnvString("CORS_EXPOSED_HEADERS", defaultCORSExposedHeaders)),
+		CORS_MAX_AGE:                getEnvInt("CORS_MAX_AGE", 600),
+		ENVELOPE_ENCRYPT_ALL:        getEnvBool("ENVELOPE_ENCRYPT_ALL", false),
+	}
+
+	config.KEY_TEMPLATE, err = utils.ParseKeyTemplate(config.KEY_STRATEGY, os.Getenv("KEY_TEMPLATE"))
+	if err != nil {
+		return config, err
+	}
+
+	config.METADATA_SCHEMA, err = utils.ParseMetadataSchema(os.Getenv("METADATA_SCHEMA"), getEnvBool("METADATA_STRICT", false))
+	if err != nil {
+		return config, err
+	}
+
+	config.RATE_LIMIT_IP, err = utils.ParseRateLimit(getEnvString("RATE_LIMIT_IP", "0"))
+	if err != nil {
+		return config, fmt.Errorf("invalid RATE_LIMIT_IP: %v", err)
+	}
+
+	config.RATE_LIMIT_API_KEY, err = utils.ParseRateLimit(getEnvString("RATE_LIMIT_API_KEY", "0"))
+	if err != nil {
+		return config, fmt.Errorf("invalid RATE_LIMIT_API_KEY: %v", err)
+	}
+
+	config.RATE_LIMIT_ROUTES, err = utils.ParseRouteRateLimits(os.Getenv("RATE_LIMIT_ROUTES"))
+	if err != nil {
+		return config, err
+	}
+
+	config.CORS_ALLOWED_ORIGINS, err = utils.ParseOriginPatterns(getEnvString("CORS_ALLOWED_ORIGINS", "*"))
+	if err != nil {
+		return config, fmt.Errorf("invalid CORS_ALLOWED_ORIGINS: %v", err)
+	}
+
+	config.CORS_METHODS, err = parseMethods(getEnvString("CORS_METHODS", "GET, POST, PUT, PATCH, DELETE"))
+	if err != nil {
+		return config, fmt.Errorf("invalid CORS_METHODS: %v", err)
+	}
+
+	config.CORS_ROUTE_METHODS, err = parseRouteMethods(os.Getenv("CORS_ROUTE_METHODS"))
+	if err != nil {
+		return config, err
+	}
+
+	config.SSE, err = utils.ParseServerSideEncryption(os.Getenv("SSE_MODE"), os.Getenv("SSE_KMS_KEY_ID"),
+		getEnvBool("SSE_KMS_BUCKET_KEY", false), os.Getenv("SSE_CUSTOMER_KEY"))
+	if err != nil {
+		return config, fmt.Errorf("invalid SSE_MODE: %v", err)
+	}
+
+	// A single ENVELOPE_MASTER_KEY is version 1 of the master key.
+	masterKeys := os.Getenv("ENVELOPE_MASTER_KEYS")
+	if masterKeys == "" && os.Getenv("ENVELOPE_MASTER_KEY") != "" {
+		masterKeys = "1:" + os.Getenv("ENVELOPE_MASTER_KEY")
+	}
+	config.ENVELOPE_MASTER_KEYS, err = utils.ParseMasterKeys(masterKeys, os.Getenv("ENVELOPE_KEY_VERSION"))
+	if err != nil {
+		return config, fmt.Errorf("invalid ENVELOPE_MASTER_KEYS: %v", err)
+	}
+	if config.ENVELOPE_ENCRYPT_ALL && config.ENVELOPE_MASTER_KEYS == nil {
+		return config, fmt.Errorf("ENVELOPE_ENCRYPT_ALL requires ENVELOPE_MASTER_KEYS")
+	}
+
+	if config.IDEMPOTENCY_TTL < 1 || config.IDEMPOTENCY_MAX_BODY < 0 {
+		return config, fmt.Errorf("invalid IDEMPOTENCY_TTL %d or IDEMPOTENCY_MAX_BODY %d", config.IDEMPOTENCY_TTL, config.IDEMPOTENCY_MAX_BODY)
+	}
+
+	config.OPTIMIZE_CONVERT, err = parseConvertRules(os.Getenv("OPTIMIZE_CONVERT"))
+	if err != nil {
+		return config, err
+	}
+	if config.OPTIMIZE_JPEG_QUALITY < 1 || config.OPTIMIZE_JPEG_QUALITY > 100 {
+		return config, fmt.Errorf("invalid OPTIMIZE_JPEG_QUALITY %d", config.OPTIMIZE_JPEG_QUALITY)
+	}
+
+	if config.WATERMARK_PATH != "" && len(config.WATERMARK_URL_SECRET) < 32 {
+		return config, fmt.Errorf("WATERMARK_PATH requires a WATERMARK_URL_SECRET of at least 32 bytes")
+	}
+
+	if config.WATERMARK_MAX_PIXELS < 1 || config.WATERMARK_CACHE_SIZE < 0 {
+		return config, fmt.Errorf("invalid WATERMARK_MAX_PIXELS %d or WATERMARK_CACHE_SIZE %d", config.WATERMARK_MAX_PIXELS, config.WATERMARK_CACHE_SIZE)
+	}
+
+	if config.AUTH_ENABLED && config.JWT_SECRET == "" && config.JWT_PUBLIC_KEY_FILE == "" && config.JWT_JWKS_FILE == "" {
+		return config, fmt.Errorf("AUTH_ENABLED requires JWT_SECRET, JWT_PUBLIC_KEY_FILE or JWT_JWKS_FILE")
+	}
+
+	if config.TENANT_REQUIRED && !config.AUTH_ENABLED {
+		return config, fmt.Errorf("TENANT_REQUIRED requires AUTH_ENABLED")
+	}
+
+	if config.CORS_ALLOW_CREDENTIALS && slices.ContainsFunc(config.CORS_ALLOWED_ORIGINS, utils.OriginPattern.Any) {
+		return config, fmt.Errorf("CORS_ALLOW_CREDENTIALS requires explicit CORS_ALLOWED_ORIGINS instead of *")
+	}
+
+	if config.CORS_MAX_AGE < 0 {
+		return config, fmt.Errorf("invalid CORS_MAX_AGE %d", config.CORS_MAX_AGE)
+	}
+
+	if config.MAX_CONCURRENT_UPLOADS < 0 || config.UPLOAD_BANDWIDTH_LIMIT < 0 {
+		return config, fmt.Errorf("invalid MAX_CONCURRENT_UPLOADS %d or UPLOAD_BANDWIDTH_LIMIT %d", config.MAX_CONCURRENT_UPLOADS, config.UPLOAD_BANDWIDTH_LIMIT)
+	}
+
+	if config.SHARE_DEFAULT_TTL < 1 || config.SHARE_DEFAULT_TTL > config.SHARE_MAX_TTL {
+		return config, fmt.Errorf("invalid SHARE_DEFAULT_TTL %d: must be between 1 and SHARE_MAX_TTL %d", config.SHARE_DEFAULT_TTL, config.SHARE_MAX_TTL)
+	}
+
+	if config.GC_GRACE_PERIOD < 0 || config.GC_INTERVAL < 1 {
+		return config, fmt.Errorf("invalid GC_GRACE_PERIOD %d or GC_INTERVAL %d", config.GC_GRACE_PERIOD, config.GC_INTERVAL)
+	}
+
+	if config.DUPLICATE_POLICY != DuplicatePolicyAllow && config.DUPLICATE_POLICY != DuplicatePolicyReject {
+		return config, fmt.Errorf("invalid DUPLICATE_POLICY %q", config.DUPLICATE_POLICY)
+	}
+
+	if config.DUPLICATE_DISTANCE < 0 || config.DUPLICATE_DISTANCE > 64 {
+		return config, fmt.Errorf("invalid DUPLICATE_DISTANCE %d: must be between 0 and 64", config.DUPLICATE_DISTANCE)
 	}
 
 	return config, nil
 }
 
+func getEnvString(key string, fallback string) string {
+	if value := os.Getenv(key); value != "" {
+		return value
+	}
+	return fallback
+}
+
+func getEnvInt(key string, fallback int) int {
+	value, err := strconv.Atoi(os.Getenv(key))
+	if err != nil {
+		return fallback
+	}
+	return value
+}
+
+func getEnvBool(key string, fallback bool) bool {
+	value, err := strconv.ParseBool(os.Getenv(key))
+	if err != nil {
+		return fallback
+	}
+	return value
+}
+
+func getEnvFloat(key string, fallback float64) float64 {
+	value, err := strconv.ParseFloat(os.Getenv(key), 64)
+	if err != nil {
+		return fallback
+	}
+	return value
+}
+
 func ConnectAWS(cfg Config) (*s3.Client, *s3.PresignClient, error) {
 	timeout := time.Duration(cfg.TIMEOUT) * time.Second
 	ctx, cancel := context.WithTimeout(context.Background(), timeout)
@@ -98,3 +357,72 @@ func ConnectAWS(cfg Config) (*s3.Client, *s3.PresignClient, error) {
 
 	return client, presignClient, nil
 }
+
+// parseConvertRules parses a comma separated list of "from:to" image format
+// conversions, e.g. "png:jpeg".
+func parseConvertRules(value string) (map[string]string, error) {
+	rules := map[string]string{}
+	for _, rule := range strings.Split(value, ",") {
+		rule = strings.TrimSpace(rule)
+		if rule == "" {
+			continue
+		}
+
+		from, to, ok := strings.Cut(rule, ":")
+		if !ok || !utils.IsImageFormat(from) || !utils.IsImageFormat(to) {
+			return nil, fmt.Errorf("invalid OPTIMIZE_CONVERT rule %q", rule)
+		}
+		rules[from] = to
+	}
+	return rules, nil
+}
+
+// splitList splits a comma separated list, dropping empty entries.
+func splitList(value string) []string {
+	var items []string
+	for _, item := range strings.Split(value, ",") {
+		if item = strings.TrimSpace(item); item != "" {
+			items = append(items, item)
+		}
+	}
+	return items
+}
+
+var httpMethods = []string{
+	http.MethodGet, http.MethodHead, http.MethodPost, http.MethodPut,
+	http.MethodPatch, http.MethodDelete, http.MethodOptions,
+}
+
+// parseMethods parses a list of HTTP methods separated by commas or spaces.
+func parseMethods(value string) ([]string, error) {
+	methods := strings.Fields(strings.ReplaceAll(value, ",", " "))
+	for i, method := range methods {
+		methods[i] = strings.ToUpper(method)
+		if !slices.Contains(httpMethods, methods[i]) {
+			return nil, fmt.Errorf("unknown method %q", method)
+		}
+	}
+	if len(methods) == 0 {
+		return nil, fmt.Errorf("at least one method is required")
+	}
+	return methods, nil
+}
+
+// parseRouteMethods parses a comma separated list of "/path-prefix=METHODS"
+// rules with space separated methods, e.g. "/s/=GET POST,/list=GET".
+func parseRouteMethods(value string) (map[string][]string, error) {
+	routes := map[string][]string{}
+	for _, rule := range splitList(value) {
+		prefix, methodsValue, ok := strings.Cut(rule, "=")
+		if !ok || !strings.HasPrefix(prefix, "/") {
+			return nil, fmt.Errorf("invalid CORS_ROUTE_METHODS rule %q", rule)
+		}
+
+		methods, err := parseMethods(methodsValue)
+		if err != nil {
+			return nil, fmt.Errorf("invalid CORS_ROUTE_METHODS rule %q: %v", rule, err)
+		}
+		routes[strings.TrimSpace(prefix)] = methods
+	}
+	return routes, nil
+}
@@ -0,0 +1,75 @@
+package handler
+
+import (
+	"errors"
+	"net/http"
+
+	"github.com/adityaw24/go-aws-garasi/internal/model"
+	"github.com/adityaw24/go-aws-garasi/internal/usecase"
+	"github.com/adityaw24/go-aws-garasi/utils"
+	"github.com/gin-gonic/gin"
+)
+
+type HandlerAPIKey interface {
+	CreateAPIKey(ctx *gin.Context)
+	ListAPIKeys(ctx *gin.Context)
+	RevokeAPIKey(ctx *gin.Context)
+}
+
+type handlerAPIKey struct {
+	usecases usecase.UsecaseAPIKey
+}
+
+func NewHandlerAPIKey(usecases usecase.UsecaseAPIKey) HandlerAPIKey {
+	return &handlerAPIKey{
+		usecases: usecases,
+	}
+}
+
+func (h *handlerAPIKey) CreateAPIKey(ctx *gin.Context) {
+	var apiKeyRequest model.APIKeyRequest
+	if err := ctx.ShouldBindJSON(&apiKeyRequest); err != nil {
+		utils.ErrorLog("handler", "CreateAPIKey", err)
+		utils.ErrorResp(ctx, http.StatusBadRequest, "invalid JSON body: "+err.Error())
+		return
+	}
+
+	apiKey, err := h.usecases.CreateAPIKey(ctx.Request.Context(), &apiKeyRequest)
+	if err != nil {
+		utils.ErrorLog("handler", "CreateAPIKey", err)
+		if errors.Is(err, utils.ErrInvalidAPIKeyRequest) {
+			utils.ErrorResp(ctx, http.StatusBadRequest, err.Error())
+			return
+		}
+		utils.ErrorResp(ctx, http.StatusInternalServerError, err.Error())
+		return
+	}
+
+	utils.SuccessResp(ctx, http.StatusCreated, "success create api key", apiKey)
+}
+
+func (h *handlerAPIKey) ListAPIKeys(ctx *gin.Context) {
+	apiKeys, err := h.usecases.ListAPIKeys(ctx.Request.Context())
+	if err != nil {
+		utils.ErrorLog("handler", "ListAPIKeys", err)
+		utils.ErrorResp(ctx, http.StatusInternalServerError, err.Error())
+		return
+	}
+
+	utils.SuccessResp(ctx, http.StatusOK, "success get list api keys", apiKeys)
+}
+
+func (h *handlerAPIKey) RevokeAPIKey(ctx *gin.Context) {
+	apiKey, err := h.usecases.RevokeAPIKey(ctx.Request.Context(), ctx.Param("id"))
+	if err != nil {
+		utils.ErrorLog("handler", "RevokeAPIKey", err)
+		if errors.Is(err, utils.ErrNotFound) {
+			utils.ErrorResp(ctx, http.StatusNotFound, err.Error())
+			return
+		}
+		utils.ErrorResp(ctx, http.StatusInternalServerError, err.Error())
+		return
+	}
+
+	utils.SuccessResp(ctx, http.StatusOK, "success revoke api key", apiKey)
+}
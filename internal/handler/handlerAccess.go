@@ -0,0 +1,108 @@
+package handler
+
+import (
+	"errors"
+	"net/http"
+
+	"github.com/adityaw24/go-aws-garasi/internal/model"
+	"github.com/adityaw24/go-aws-garasi/utils"
+	"github.com/gin-gonic/gin"
+)
+
+func (h *handlerUpload) GetAccess(ctx *gin.Context) {
+	objectKey, ok := accessKeyParam(ctx, "GetAccess")
+	if !ok {
+		return
+	}
+
+	access, err := h.usecases.GetAccess(ctx.Request.Context(), objectKey)
+	if err != nil {
+		utils.ErrorLog("handler", "GetAccess", err)
+		accessError(ctx, err)
+		return
+	}
+
+	utils.SuccessResp(ctx, http.StatusOK, "success get access", access)
+}
+
+func (h *handlerUpload) GrantAccess(ctx *gin.Context) {
+	objectKey, accessRequest, ok := bindAccess(ctx, "GrantAccess")
+	if !ok {
+		return
+	}
+
+	access, err := h.usecases.GrantAccess(ctx.Request.Context(), objectKey, accessRequest)
+	if err != nil {
+		utils.ErrorLog("handler", "GrantAccess", err)
+		accessError(ctx, err)
+		return
+	}
+
+	utils.SuccessResp(ctx, http.StatusOK, "success grant access", access)
+}
+
+func (h *handlerUpload) RevokeAccess(ctx *gin.Context) {
+	objectKey, accessRequest, ok := bindAccess(ctx, "RevokeAccess")
+	if !ok {
+		return
+	}
+
+	access, err := h.usecases.RevokeAccess(ctx.Request.Context(), objectKey, accessRequest)
+	if err != nil {
+		utils.ErrorLog("handler", "RevokeAccess", err)
+		accessError(ctx, err)
+		return
+	}
+
+	utils.SuccessResp(ctx, http.StatusOK, "success revoke access", access)
+}
+
+// accessKeyParam reads and validates the :key parameter. It writes the error
+// response and returns false when the key is invalid.
+func accessKeyParam(ctx *gin.Context, funcName string) (string, bool) {
+	objectKey := keyParam(ctx)
+	if objectKey == "" {
+		utils.ErrorLog("handler", funcName, errors.New("key parameter is required"))
+		utils.ErrorResp(ctx, http.StatusBadRequest, "key parameter is required")
+		return "", false
+	}
+
+	if err := utils.ValidateKey(objectKey); err != nil {
+		utils.ErrorLog("handler", funcName, err)
+		utils.ErrorResp(ctx, http.StatusBadRequest, err.Error())
+		return "", false
+	}
+
+	return objectKey, true
+}
+
+func bindAccess(ctx *gin.Context, funcName string) (string, *model.AccessRequest, bool) {
+	objectKey, ok := accessKeyParam(ctx, funcName)
+	if !ok {
+		return "", nil, false
+	}
+
+	var accessRequest model.AccessRequest
+	if err := ctx.ShouldBindJSON(&accessRequest); err != nil {
+		utils.ErrorLog("handler", funcName, err)
+		utils.ErrorResp(ctx, http.StatusBadRequest, "invalid JSON body: "+err.Error())
+		return "", nil, false
+	}
+
+	return objectKey, &accessRequest, true
+}
+
+func accessError(ctx *gin.Context, err error) {
+	switch {
+	case errors.Is(err, utils.ErrNotFound):
+		utils.ErrorResp(ctx, http.StatusNotFound, err.Error())
+	case errors.Is(err, utils.ErrForbidden):
+		utils.ErrorResp(ctx, http.StatusForbidden, err.Error())
+	case errors.Is(err, utils.ErrInvalidAccess):
+		utils.ErrorResp(ctx, http.StatusBadRequest, err.Error())
+	case errors.Is(err, utils.ErrPreconditionFailed):
+		utils.ErrorResp(ctx, http.StatusConflict, err.Error())
+	default:
+		utils.ErrorResp(ctx, http.StatusInternalServerError, err.Error())
+	}
+}
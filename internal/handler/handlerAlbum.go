@@ -0,0 +1,204 @@
+package handler
+
+import (
+	"errors"
+	"net/http"
+
+	"github.com/adityaw24/go-aws-garasi/internal/model"
+	"github.com/adityaw24/go-aws-garasi/internal/usecase"
+	"github.com/adityaw24/go-aws-garasi/utils"
+	"github.com/gin-gonic/gin"
+)
+
+type HandlerAlbum interface {
+	ListAlbums(ctx *gin.Context)
+	CreateAlbum(ctx *gin.Context)
+	RenameAlbum(ctx *gin.Context)
+	DeleteAlbum(ctx *gin.Context)
+	AddToAlbum(ctx *gin.Context)
+	RemoveFromAlbum(ctx *gin.Context)
+	ReorderAlbum(ctx *gin.Context)
+	SetAlbumCover(ctx *gin.Context)
+	AlbumContents(ctx *gin.Context)
+}
+
+type handlerAlbum struct {
+	usecases usecase.UsecaseAlbum
+}
+
+func NewHandlerAlbum(usecases usecase.UsecaseAlbum) HandlerAlbum {
+	return &handlerAlbum{
+		usecases: usecases,
+	}
+}
+
+func (h *handlerAlbum) ListAlbums(ctx *gin.Context) {
+	albums, err := h.usecases.ListAlbums(ctx.Request.Context())
+	if err != nil {
+		utils.ErrorLog("handler", "ListAlbums", err)
+		utils.ErrorResp(ctx, http.StatusInternalServerError, err.Error())
+		return
+	}
+
+	utils.SuccessResp(ctx, http.StatusOK, "success get list albums", albums)
+}
+
+func (h *handlerAlbum) CreateAlbum(ctx *gin.Context) {
+	var albumRequest model.AlbumRequest
+	if err := ctx.ShouldBindJSON(&albumRequest); err != nil {
+		utils.ErrorLog("handler", "CreateAlbum", err)
+		utils.ErrorResp(ctx, http.StatusBadRequest, "invalid JSON body: "+err.Error())
+		return
+	}
+
+	album, err := h.usecases.CreateAlbum(ctx.Request.Context(), albumRequest.Name)
+	if err != nil {
+		utils.ErrorLog("handler", "CreateAlbum", err)
+		albumError(ctx, err)
+		return
+	}
+
+	utils.SuccessResp(ctx, http.StatusCreated, "success create album", album)
+}
+
+func (h *handlerAlbum) RenameAlbum(ctx *gin.Context) {
+	var albumRequest model.AlbumRequest
+	if err := ctx.ShouldBindJSON(&albumRequest); err != nil {
+		utils.ErrorLog("handler", "RenameAlbum", err)
+		utils.ErrorResp(ctx, http.StatusBadRequest, "invalid JSON body: "+err.Error())
+		return
+	}
+
+	album, err := h.usecases.RenameAlbum(ctx.Request.Context(), ctx.Param("id"), albumRequest.Name)
+	if err != nil {
+		utils.ErrorLog("handler", "RenameAlbum", err)
+		albumError(ctx, err)
+		return
+	}
+
+	utils.SuccessResp(ctx, http.StatusOK, "success rename album", album)
+}
+
+func (h *handlerAlbum) DeleteAlbum(ctx *gin.Context) {
+	err := h.usecases.DeleteAlbum(ctx.Request.Context(), ctx.Param("id"))
+	if err != nil {
+		utils.ErrorLog("handler", "DeleteAlbum", err)
+		albumError(ctx, err)
+		return
+	}
+
+	utils.SuccessResp(ctx, http.StatusOK, "success delete album", nil)
+}
+
+func (h *handlerAlbum) AddToAlbum(ctx *gin.Context) {
+	keys, ok := bindAlbumKeys(ctx, "AddToAlbum")
+	if !ok {
+		return
+	}
+
+	album, err := h.usecases.AddToAlbum(ctx.Request.Context(), ctx.Param("id"), keys)
+	if err != nil {
+		utils.ErrorLog("handler", "AddToAlbum", err)
+		albumError(ctx, err)
+		return
+	}
+
+	utils.SuccessResp(ctx, http.StatusOK, "success add to album", album)
+}
+
+func (h *handlerAlbum) RemoveFromAlbum(ctx *gin.Context) {
+	keys, ok := bindAlbumKeys(ctx, "RemoveFromAlbum")
+	if !ok {
+		return
+	}
+
+	album, err := h.usecases.RemoveFromAlbum(ctx.Request.Context(), ctx.Param("id"), keys)
+	if err != nil {
+		utils.ErrorLog("handler", "RemoveFromAlbum", err)
+		albumError(ctx, err)
+		return
+	}
+
+	utils.SuccessResp(ctx, http.StatusOK, "success remove from album", album)
+}
+
+func (h *handlerAlbum) ReorderAlbum(ctx *gin.Context) {
+	keys, ok := bindAlbumKeys(ctx, "ReorderAlbum")
+	if !ok {
+		return
+	}
+
+	album, err := h.usecases.ReorderAlbum(ctx.Request.Context(), ctx.Param("id"), keys)
+	if err != nil {
+		utils.ErrorLog("handler", "ReorderAlbum", err)
+		albumError(ctx, err)
+		return
+	}
+
+	utils.SuccessResp(ctx, http.StatusOK, "success reorder album", album)
+}
+
+func (h *handlerAlbum) SetAlbumCover(ctx *gin.Context) {
+	var coverRequest model.AlbumCoverRequest
+	if err := ctx.ShouldBindJSON(&coverRequest); err != nil {
+		utils.ErrorLog("handler", "SetAlbumCover", err)
+		utils.ErrorResp(ctx, http.StatusBadRequest, "invalid JSON body: "+err.Error())
+		return
+	}
+
+	album, err := h.usecases.SetAlbumCover(ctx.Request.Context(), ctx.Param("id"), coverRequest.Key)
+	if err != nil {
+		utils.ErrorLog("handler", "SetAlbumCover", err)
+		albumError(ctx, err)
+		return
+	}
+
+	utils.SuccessResp(ctx, http.StatusOK, "success set album cover", album)
+}
+
+func (h *handlerAlbum) AlbumContents(ctx *gin.Context) {
+	contents, err := h.usecases.AlbumContents(ctx.Request.Context(), ctx.Param("id"))
+	if err != nil {
+		utils.ErrorLog("handler", "AlbumContents", err)
+		albumError(ctx, err)
+		return
+	}
+
+	utils.SuccessResp(ctx, http.StatusOK, "success get album contents", contents)
+}
+
+// bindAlbumKeys reads and validates the keys of an AlbumKeysRequest. It
+// writes the error response and returns false when the request is invalid.
+func bindAlbumKeys(ctx *gin.Context, funcName string) ([]string, bool) {
+	var keysRequest model.AlbumKeysRequest
+	if err := ctx.ShouldBindJSON(&keysRequest); err != nil {
+		utils.ErrorLog("handler", funcName, err)
+		utils.ErrorResp(ctx, http.StatusBadRequest, "invalid JSON body: "+err.Error())
+		return nil, false
+	}
+
+	for _, key := range keysRequest.Keys {
+		if err := utils.ValidateKey(key); err != nil {
+			utils.ErrorLog("handler", funcName, err)
+			utils.ErrorResp(ctx, http.StatusBadRequest, err.Error())
+			return nil, false
+		}
+	}
+
+	return keysRequest.Keys, true
+}
+
+func albumError(ctx *gin.Context, err error) {
+	switch {
+	case errors.Is(err, utils.ErrNotFound):
+		utils.ErrorResp(ctx, http.StatusNotFound, err.Error())
+	case errors.Is(err, utils.ErrForbidden):
+		utils.ErrorResp(ctx, http.StatusForbidden, err.Error())
+	case errors.Is(err, utils.ErrInvalidAlbum):
+		utils.ErrorResp(ctx, http.StatusBadRequest, err.Error())
+	case errors.Is(err, utils.ErrPreconditionFailed):
+		utils.ErrorResp(ctx, http.StatusConflict, err.Error())
+	default:
+		utils.ErrorResp(ctx, http.StatusInternalServerError, err.Error())
+	}
+}
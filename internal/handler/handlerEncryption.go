@@ -0,0 +1,63 @@
+package handler
+
+import (
+	"errors"
+	"net/http"
+
+	"github.com/adityaw24/go-aws-garasi/internal/usecase"
+	"github.com/adityaw24/go-aws-garasi/utils"
+	"github.com/gin-gonic/gin"
+)
+
+type HandlerEncryption interface {
+	StartRewrap(ctx *gin.Context)
+	GetRewrap(ctx *gin.Context)
+}
+
+type handlerEncryption struct {
+	usecases usecase.UsecaseEncryption
+}
+
+func NewHandlerEncryption(usecases usecase.UsecaseEncryption) HandlerEncryption {
+	return &handlerEncryption{
+		usecases: usecases,
+	}
+}
+
+// StartRewrap starts or resumes re-wrapping data keys with the current
+// master key version. The job runs in the background; poll GetRewrap for
+// its progress.
+func (h *handlerEncryption) StartRewrap(ctx *gin.Context) {
+	job, err := h.usecases.StartRewrap(ctx.Request.Context())
+	if err != nil {
+		utils.ErrorLog("handler", "StartRewrap", err)
+		encryptionError(ctx, err)
+		return
+	}
+
+	utils.SuccessResp(ctx, http.StatusAccepted, "success start re-wrap job", job)
+}
+
+func (h *handlerEncryption) GetRewrap(ctx *gin.Context) {
+	job, err := h.usecases.GetRewrap(ctx.Request.Context())
+	if err != nil {
+		utils.ErrorLog("handler", "GetRewrap", err)
+		encryptionError(ctx, err)
+		return
+	}
+
+	utils.SuccessResp(ctx, http.StatusOK, "success get re-wrap job", job)
+}
+
+func encryptionError(ctx *gin.Context, err error) {
+	switch {
+	case errors.Is(err, utils.ErrNotFound):
+		utils.ErrorResp(ctx, http.StatusNotFound, err.Error())
+	case errors.Is(err, utils.ErrEncryptionDisabled):
+		utils.ErrorResp(ctx, http.StatusBadRequest, err.Error())
+	case errors.Is(err, utils.ErrJobRunning):
+		utils.ErrorResp(ctx, http.StatusConflict, err.Error())
+	default:
+		utils.ErrorResp(ctx, http.StatusInternalServerError, err.Error())
+	}
+}
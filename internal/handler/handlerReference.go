@@ -0,0 +1,131 @@
+package handler
+
+import (
+	"errors"
+	"net/http"
+
+	"github.com/adityaw24/go-aws-garasi/internal/model"
+	"github.com/adityaw24/go-aws-garasi/internal/usecase"
+	"github.com/adityaw24/go-aws-garasi/utils"
+	"github.com/gin-gonic/gin"
+)
+
+type HandlerReference interface {
+	RegisterReference(ctx *gin.Context)
+	ReleaseReference(ctx *gin.Context)
+	GetReferences(ctx *gin.Context)
+	CollectGarbage(ctx *gin.Context)
+}
+
+type handlerReference struct {
+	usecases usecase.UsecaseReference
+}
+
+func NewHandlerReference(usecases usecase.UsecaseReference) HandlerReference {
+	return &handlerReference{
+		usecases: usecases,
+	}
+}
+
+func (h *handlerReference) RegisterReference(ctx *gin.Context) {
+	referenceRequest, ok := bindReference(ctx, "RegisterReference")
+	if !ok {
+		return
+	}
+
+	references, err := h.usecases.RegisterReference(ctx.Request.Context(), referenceRequest)
+	if err != nil {
+		utils.ErrorLog("handler", "RegisterReference", err)
+		referenceError(ctx, err)
+		return
+	}
+
+	utils.SuccessResp(ctx, http.StatusOK, "success register reference", references)
+}
+
+func (h *handlerReference) ReleaseReference(ctx *gin.Context) {
+	referenceRequest, ok := bindReference(ctx, "ReleaseReference")
+	if !ok {
+		return
+	}
+
+	references, err := h.usecases.ReleaseReference(ctx.Request.Context(), referenceRequest)
+	if err != nil {
+		utils.ErrorLog("handler", "ReleaseReference", err)
+		referenceError(ctx, err)
+		return
+	}
+
+	utils.SuccessResp(ctx, http.StatusOK, "success release reference", references)
+}
+
+func (h *handlerReference) GetReferences(ctx *gin.Context) {
+	objectKey := keyParam(ctx)
+	if objectKey == "" {
+		utils.ErrorLog("handler", "GetReferences", errors.New("key parameter is required"))
+		utils.ErrorResp(ctx, http.StatusBadRequest, "key parameter is required")
+		return
+	}
+
+	if err := utils.ValidateKey(objectKey); err != nil {
+		utils.ErrorLog("handler", "GetReferences", err)
+		utils.ErrorResp(ctx, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	references, err := h.usecases.GetReferences(ctx.Request.Context(), objectKey)
+	if err != nil {
+		utils.ErrorLog("handler", "GetReferences", err)
+		referenceError(ctx, err)
+		return
+	}
+
+	utils.SuccessResp(ctx, http.StatusOK, "success get references", references)
+}
+
+// CollectGarbage runs a garbage collection immediately, in addition to the
+// periodic job.
+func (h *handlerReference) CollectGarbage(ctx *gin.Context) {
+	result, err := h.usecases.CollectGarbage(ctx.Request.Context())
+	if err != nil {
+		utils.ErrorLog("handler", "CollectGarbage", err)
+		utils.ErrorResp(ctx, http.StatusInternalServerError, err.Error())
+		return
+	}
+
+	utils.SuccessResp(ctx, http.StatusOK, "success collect garbage", result)
+}
+
+// bindReference reads and validates a ReferenceRequest. It writes the error
+// response and returns false when the request is invalid.
+func bindReference(ctx *gin.Context, funcName string) (*model.ReferenceRequest, bool) {
+	var referenceRequest model.ReferenceRequest
+	if err := ctx.ShouldBindJSON(&referenceRequest); err != nil {
+		utils.ErrorLog("handler", funcName, err)
+		utils.ErrorResp(ctx, http.StatusBadRequest, "invalid JSON body: "+err.Error())
+		return nil, false
+	}
+
+	if err := utils.ValidateKey(referenceRequest.Key); err != nil {
+		utils.ErrorLog("handler", funcName, err)
+		utils.ErrorResp(ctx, http.StatusBadRequest, err.Error())
+		return nil, false
+	}
+
+	return &referenceRequest, true
+}
+
+func referenceError(ctx *gin.Context, err error) {
+	switch {
+	case errors.Is(err, utils.ErrNotFound):
+		utils.ErrorResp(ctx, http.StatusNotFound, err.Error())
+	case errors.Is(err, utils.ErrForbidden):
+		utils.ErrorResp(ctx, http.StatusForbidden, err.Error())
+	case errors.Is(err, utils.ErrInvalidEntity):
+		utils.ErrorResp(ctx, http.StatusBadRequest, err.Error())
+	case errors.Is(err, utils.ErrPreconditionFailed):
+		utils.ErrorResp(ctx, http.StatusConflict, err.Error())
+	default:
+		utils.ErrorResp(ctx, http.StatusInternalServerError, err.Error())
+	}
+}
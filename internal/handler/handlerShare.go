@@ -0,0 +1,140 @@
+package handler
+
+import (
+	"errors"
+	"net/http"
+	"time"
+
+	"github.com/adityaw24/go-aws-garasi/internal/model"
+	"github.com/adityaw24/go-aws-garasi/internal/usecase"
+	"github.com/adityaw24/go-aws-garasi/utils"
+	"github.com/gin-gonic/gin"
+)
+
+// SharePasswordHeader carries the password of a protected share link. Forms
+// can send it as the password field instead.
+const SharePasswordHeader = "X-Share-Password"
+
+type HandlerShare interface {
+	CreateShare(ctx *gin.Context)
+	ListShares(ctx *gin.Context)
+	GetShare(ctx *gin.Context)
+	RevokeShare(ctx *gin.Context)
+	OpenShare(ctx *gin.Context)
+}
+
+type handlerShare struct {
+	usecases usecase.UsecaseShare
+}
+
+func NewHandlerShare(usecases usecase.UsecaseShare) HandlerShare {
+	return &handlerShare{
+		usecases: usecases,
+	}
+}
+
+func (h *handlerShare) CreateShare(ctx *gin.Context) {
+	var shareRequest model.ShareRequest
+	if err := ctx.ShouldBindJSON(&shareRequest); err != nil {
+		utils.ErrorLog("handler", "CreateShare", err)
+		utils.ErrorResp(ctx, http.StatusBadRequest, "invalid JSON body: "+err.Error())
+		return
+	}
+
+	share, err := h.usecases.CreateShare(ctx.Request.Context(), &shareRequest)
+	if err != nil {
+		utils.ErrorLog("handler", "CreateShare", err)
+		shareError(ctx, err)
+		return
+	}
+
+	utils.SuccessResp(ctx, http.StatusCreated, "success create share link", share)
+}
+
+func (h *handlerShare) ListShares(ctx *gin.Context) {
+	objectKey := ctx.Query("key")
+	if objectKey == "" {
+		utils.ErrorLog("handler", "ListShares", errors.New("key parameter is required"))
+		utils.ErrorResp(ctx, http.StatusBadRequest, "key parameter is required")
+		return
+	}
+
+	if err := utils.ValidateKey(objectKey); err != nil {
+		utils.ErrorLog("handler", "ListShares", err)
+		utils.ErrorResp(ctx, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	shares, err := h.usecases.ListShares(ctx.Request.Context(), objectKey)
+	if err != nil {
+		utils.ErrorLog("handler", "ListShares", err)
+		shareError(ctx, err)
+		return
+	}
+
+	utils.SuccessResp(ctx, http.StatusOK, "success get list share links", shares)
+}
+
+func (h *handlerShare) GetShare(ctx *gin.Context) {
+	share, err := h.usecases.GetShare(ctx.Request.Context(), ctx.Param("token"))
+	if err != nil {
+		utils.ErrorLog("handler", "GetShare", err)
+		shareError(ctx, err)
+		return
+	}
+
+	utils.SuccessResp(ctx, http.StatusOK, "success get share link", share)
+}
+
+func (h *handlerShare) RevokeShare(ctx *gin.Context) {
+	share, err := h.usecases.RevokeShare(ctx.Request.Context(), ctx.Param("token"))
+	if err != nil {
+		utils.ErrorLog("handler", "RevokeShare", err)
+		shareError(ctx, err)
+		return
+	}
+
+	utils.SuccessResp(ctx, http.StatusOK, "success revoke share link", share)
+}
+
+// OpenShare serves the object of a share link. It is mounted outside the
+// authenticated routes; the token is the credential.
+func (h *handlerShare) OpenShare(ctx *gin.Context) {
+	password := ctx.GetHeader(SharePasswordHeader)
+	if password == "" {
+		password = ctx.PostForm("password")
+	}
+
+	object, err := h.usecases.OpenShare(ctx.Request.Context(), ctx.Param("token"), password, model.ShareAccess{
+		At:        time.Now().UTC(),
+		IP:        ctx.ClientIP(),
+		UserAgent: ctx.Request.UserAgent(),
+	})
+	if err != nil {
+		utils.ErrorLog("handler", "OpenShare", err)
+		shareError(ctx, err)
+		return
+	}
+
+	ctx.Header("Cache-Control", "no-store")
+	writeObject(ctx, "OpenShare", object)
+}
+
+func shareError(ctx *gin.Context, err error) {
+	switch {
+	case errors.Is(err, utils.ErrNotFound):
+		utils.ErrorResp(ctx, http.StatusNotFound, err.Error())
+	case errors.Is(err, utils.ErrForbidden):
+		utils.ErrorResp(ctx, http.StatusForbidden, err.Error())
+	case errors.Is(err, utils.ErrInvalidShare), errors.Is(err, utils.ErrInvalidKey):
+		utils.ErrorResp(ctx, http.StatusBadRequest, err.Error())
+	case errors.Is(err, utils.ErrInvalidPassword):
+		utils.ErrorResp(ctx, http.StatusUnauthorized, err.Error())
+	case errors.Is(err, utils.ErrShareUnavailable):
+		utils.ErrorResp(ctx, http.StatusGone, err.Error())
+	case errors.Is(err, utils.ErrPreconditionFailed):
+		utils.ErrorResp(ctx, http.StatusConflict, err.Error())
+	default:
+		utils.ErrorResp(ctx, http.StatusInternalServerError, err.Error())
+	}
+}
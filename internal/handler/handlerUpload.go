@@ -2,7 +2,10 @@ package handler
 
 import (
 	"errors"
+	"fmt"
+	"io"
 	"net/http"
+	"strconv"
 	"strings"
 
 	"github.com/adityaw24/go-aws-garasi/internal/model"
@@ -18,6 +21,17 @@ type HandlerUpload interface {
 	UpdateFile(ctx *gin.Context)
 	DeleteFile(ctx *gin.Context)
 	UpdateObject(ctx *gin.Context)
+	FindDuplicates(ctx *gin.Context)
+	PublicFile(ctx *gin.Context)
+	DetailFile(ctx *gin.Context)
+	DownloadFile(ctx *gin.Context)
+	UpdateMetadata(ctx *gin.Context)
+	GetTags(ctx *gin.Context)
+	SetTags(ctx *gin.Context)
+	DeleteTags(ctx *gin.Context)
+	GetAccess(ctx *gin.Context)
+	GrantAccess(ctx *gin.Context)
+	RevokeAccess(ctx *gin.Context)
 }
 
 type handlerUpload struct {
@@ -58,6 +72,16 @@ func (h *handlerUpload) UploadFile(ctx *gin.Context) {
 	fileMRequest := model.FileRequest{
 		Title: title,
 		File:  fileHeader,
+		Tags:  formTags(ctx),
+	}
+	fileMRequest.Metadata = formMetadata(ctx)
+	setFormAccessibleText(ctx, &fileMRequest)
+
+	fileMRequest.Encrypt, err = formEncrypt(ctx)
+	if err != nil {
+		utils.ErrorLog("handler", "UploadFile", err)
+		utils.ErrorResp(ctx, http.StatusBadRequest, err.Error())
+		return
 	}
 
 	if fileMRequest.Title == "" {
@@ -66,33 +90,62 @@ func (h *handlerUpload) UploadFile(ctx *gin.Context) {
 		return
 	}
 
+	fileMRequest.Title, err = utils.NormalizeTitle(fileMRequest.Title)
+	if err != nil {
+		utils.ErrorLog("handler", "UploadFile", err)
+		utils.ErrorResp(ctx, http.StatusBadRequest, err.Error())
+		return
+	}
+
 	if fileMRequest.File == nil {
 		utils.ErrorLog("handler", "UploadFile", errors.New("file is required"))
 		utils.ErrorResp(ctx, http.StatusBadRequest, "file is required")
 		return
 	}
 
-	listObjects, err := h.usecases.UploadFile(ctx, &fileMRequest, utils.ValidImageTypes)
+	fileModel, err := h.usecases.UploadFile(ctx.Request.Context(), &fileMRequest, utils.ValidImageTypes)
 	if err != nil {
 		utils.ErrorLog("handler", "UploadFile", err)
+		if errors.Is(err, utils.ErrNearDuplicate) || errors.Is(err, utils.ErrDuplicateContent) {
+			utils.ErrorResp(ctx, http.StatusConflict, err.Error())
+			return
+		}
+		if errors.Is(err, utils.ErrInvalidKey) || errors.Is(err, utils.ErrInvalidMetadata) || errors.Is(err, utils.ErrInvalidTags) || errors.Is(err, utils.ErrObjectTooLarge) || errors.Is(err, utils.ErrEncryptionDisabled) {
+			utils.ErrorResp(ctx, http.StatusBadRequest, err.Error())
+			return
+		}
 		utils.ErrorResp(ctx, http.StatusInternalServerError, err.Error())
 		return
 	}
 
-	utils.SuccessResp(ctx, http.StatusOK, "success upload file", listObjects)
+	utils.SuccessResp(ctx, http.StatusOK, "success upload file", fileModel)
 }
 
 func (h *handlerUpload) PreviewFile(ctx *gin.Context) {
-	objectKey := ctx.Param("key")
+	objectKey := keyParam(ctx)
 	if objectKey == "" {
 		utils.ErrorLog("handler", "PreviewFile", errors.New("key parameter is required"))
 		utils.ErrorResp(ctx, http.StatusBadRequest, "key parameter is required")
 		return
 	}
 
-	presignedURL, err := h.usecases.PreviewFile(ctx, objectKey)
+	if err := utils.ValidateKey(objectKey); err != nil {
+		utils.ErrorLog("handler", "PreviewFile", err)
+		utils.ErrorResp(ctx, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	presignedURL, err := h.usecases.PreviewFile(ctx.Request.Context(), objectKey)
 	if err != nil {
 		utils.ErrorLog("handler", "PreviewFile", err)
+		if errors.Is(err, utils.ErrForbidden) {
+			utils.ErrorResp(ctx, http.StatusForbidden, err.Error())
+			return
+		}
+		if errors.Is(err, utils.ErrEncryptedObject) {
+			utils.ErrorResp(ctx, http.StatusConflict, err.Error())
+			return
+		}
 		utils.ErrorResp(ctx, http.StatusInternalServerError, err.Error())
 		return
 	}
@@ -103,7 +156,14 @@ func (h *handlerUpload) PreviewFile(ctx *gin.Context) {
 }
 
 func (h *handlerUpload) ListObjects(ctx *gin.Context) {
-	objects, err := h.usecases.ListObjects(ctx)
+	filter, err := listFilter(ctx)
+	if err != nil {
+		utils.ErrorLog("handler", "ListObjects", err)
+		utils.ErrorResp(ctx, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	objects, err := h.usecases.ListObjects(ctx.Request.Context(), filter)
 	if err != nil {
 		utils.ErrorLog("handler", "ListObjects", err)
 		utils.ErrorResp(ctx, http.StatusInternalServerError, err.Error())
@@ -141,8 +201,18 @@ func (h *handlerUpload) UpdateFile(ctx *gin.Context) {
 		FileRequest: model.FileRequest{
 			Title: title,
 			File:  fileHeader,
+			Tags:  formTags(ctx),
 		},
 	}
+	fileMRequest.Metadata = formMetadata(ctx)
+	setFormAccessibleText(ctx, &fileMRequest.FileRequest)
+
+	fileMRequest.Encrypt, err = formEncrypt(ctx)
+	if err != nil {
+		utils.ErrorLog("handler", "UpdateFile", err)
+		utils.ErrorResp(ctx, http.StatusBadRequest, err.Error())
+		return
+	}
 
 	if fileMRequest.Key == "" {
 		utils.ErrorLog("handler", "UpdateFile", errors.New("key is required"))
@@ -156,18 +226,48 @@ func (h *handlerUpload) UpdateFile(ctx *gin.Context) {
 		return
 	}
 
-	err = h.usecases.UpdateFile(ctx, &fileMRequest, utils.ValidImageTypes)
+	err = utils.ValidateKey(fileMRequest.Key)
+	if err != nil {
+		utils.ErrorLog("handler", "UpdateFile", err)
+		utils.ErrorResp(ctx, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	fileMRequest.Title, err = utils.NormalizeTitle(fileMRequest.Title)
+	if err != nil {
+		utils.ErrorLog("handler", "UpdateFile", err)
+		utils.ErrorResp(ctx, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	fileModel, err := h.usecases.UpdateFile(ctx.Request.Context(), &fileMRequest, utils.ValidImageTypes)
 	if err != nil {
 		utils.ErrorLog("handler", "UpdateFile", err)
+		if errors.Is(err, utils.ErrNearDuplicate) || errors.Is(err, utils.ErrDuplicateContent) {
+			utils.ErrorResp(ctx, http.StatusConflict, err.Error())
+			return
+		}
+		if errors.Is(err, utils.ErrInvalidKey) || errors.Is(err, utils.ErrInvalidMetadata) || errors.Is(err, utils.ErrInvalidTags) || errors.Is(err, utils.ErrObjectTooLarge) || errors.Is(err, utils.ErrEncryptionDisabled) {
+			utils.ErrorResp(ctx, http.StatusBadRequest, err.Error())
+			return
+		}
+		if errors.Is(err, utils.ErrNotFound) {
+			utils.ErrorResp(ctx, http.StatusNotFound, err.Error())
+			return
+		}
+		if errors.Is(err, utils.ErrForbidden) {
+			utils.ErrorResp(ctx, http.StatusForbidden, err.Error())
+			return
+		}
 		utils.ErrorResp(ctx, http.StatusInternalServerError, err.Error())
 		return
 	}
 
-	utils.SuccessResp(ctx, http.StatusOK, "success update file", nil)
+	utils.SuccessResp(ctx, http.StatusOK, "success update file", fileModel)
 }
 
 func (h *handlerUpload) DeleteFile(ctx *gin.Context) {
-	key := ctx.Param("key")
+	key := keyParam(ctx)
 
 	if key == "" {
 		utils.ErrorLog("handler", "DeleteFile", errors.New("key parameter is required"))
@@ -175,17 +275,31 @@ func (h *handlerUpload) DeleteFile(ctx *gin.Context) {
 		return
 	}
 
+	if err := utils.ValidateKey(key); err != nil {
+		utils.ErrorLog("handler", "DeleteFile", err)
+		utils.ErrorResp(ctx, http.StatusBadRequest, err.Error())
+		return
+	}
+
 	fileRequest := model.DeleteFileRequest{
 		Key: key,
 	}
 
-	err := h.usecases.DeleteFile(ctx, &fileRequest)
+	err := h.usecases.DeleteFile(ctx.Request.Context(), &fileRequest)
 	if err != nil {
-		if strings.Contains(err.Error(), "not found") {
+		utils.ErrorLog("handler", "DeleteFile", err)
+		if errors.Is(err, utils.ErrNotFound) {
 			utils.ErrorResp(ctx, http.StatusNotFound, err.Error())
 			return
 		}
-		utils.ErrorLog("handler", "DeleteFile", err)
+		if errors.Is(err, utils.ErrForbidden) {
+			utils.ErrorResp(ctx, http.StatusForbidden, err.Error())
+			return
+		}
+		if errors.Is(err, utils.ErrReferenced) {
+			utils.ErrorResp(ctx, http.StatusConflict, err.Error())
+			return
+		}
 		utils.ErrorResp(ctx, http.StatusInternalServerError, err.Error())
 		return
 	}
@@ -209,15 +323,502 @@ func (h *handlerUpload) UpdateObject(ctx *gin.Context) {
 		return
 	}
 
-	err := h.usecases.UpdateObject(ctx, &model.CopyObjectRequest{
+	err := utils.ValidateKey(oldKey)
+	if err != nil {
+		utils.ErrorLog("handler", "UpdateObject", err)
+		utils.ErrorResp(ctx, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	newKey, err = utils.NormalizeTitle(newKey)
+	if err != nil {
+		utils.ErrorLog("handler", "UpdateObject", err)
+		utils.ErrorResp(ctx, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	fileModel, err := h.usecases.UpdateObject(ctx.Request.Context(), &model.CopyObjectRequest{
 		OldKey: oldKey,
 		NewKey: newKey,
 	})
 	if err != nil {
 		utils.ErrorLog("handler", "UpdateObject", err)
+		if errors.Is(err, utils.ErrDuplicateContent) {
+			utils.ErrorResp(ctx, http.StatusConflict, err.Error())
+			return
+		}
+		if errors.Is(err, utils.ErrInvalidKey) {
+			utils.ErrorResp(ctx, http.StatusBadRequest, err.Error())
+			return
+		}
+		if errors.Is(err, utils.ErrNotFound) {
+			utils.ErrorResp(ctx, http.StatusNotFound, err.Error())
+			return
+		}
+		if errors.Is(err, utils.ErrForbidden) {
+			utils.ErrorResp(ctx, http.StatusForbidden, err.Error())
+			return
+		}
+		utils.ErrorResp(ctx, http.StatusInternalServerError, err.Error())
+		return
+	}
+
+	utils.SuccessResp(ctx, http.StatusOK, "success update object", fileModel)
+}
+
+func (h *handlerUpload) FindDuplicates(ctx *gin.Context) {
+	objectKey := keyParam(ctx)
+	if objectKey == "" {
+		utils.ErrorLog("handler", "FindDuplicates", errors.New("key parameter is required"))
+		utils.ErrorResp(ctx, http.StatusBadRequest, "key parameter is required")
+		return
+	}
+
+	if err := utils.ValidateKey(objectKey); err != nil {
+		utils.ErrorLog("handler", "FindDuplicates", err)
+		utils.ErrorResp(ctx, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	distance := -1
+	if value := ctx.Query("distance"); value != "" {
+		parsed, err := strconv.Atoi(value)
+		if err != nil || parsed < 0 || parsed > 64 {
+			utils.ErrorLog("handler", "FindDuplicates", errors.New("distance must be between 0 and 64"))
+			utils.ErrorResp(ctx, http.StatusBadRequest, "distance must be between 0 and 64")
+			return
+		}
+		distance = parsed
+	}
+
+	duplicates, err := h.usecases.FindDuplicates(ctx.Request.Context(), objectKey, distance)
+	if err != nil {
+		utils.ErrorLog("handler", "FindDuplicates", err)
+		if errors.Is(err, utils.ErrNotFound) {
+			utils.ErrorResp(ctx, http.StatusNotFound, err.Error())
+			return
+		}
+		if errors.Is(err, utils.ErrNoPerceptualHash) {
+			utils.ErrorResp(ctx, http.StatusUnprocessableEntity, err.Error())
+			return
+		}
+		if errors.Is(err, utils.ErrForbidden) {
+			utils.ErrorResp(ctx, http.StatusForbidden, err.Error())
+			return
+		}
+		utils.ErrorResp(ctx, http.StatusInternalServerError, err.Error())
+		return
+	}
+
+	utils.SuccessResp(ctx, http.StatusOK, "success get duplicate files", duplicates)
+}
+
+func (h *handlerUpload) PublicFile(ctx *gin.Context) {
+	objectKey := keyParam(ctx)
+	if objectKey == "" {
+		utils.ErrorLog("handler", "PublicFile", errors.New("key parameter is required"))
+		utils.ErrorResp(ctx, http.StatusBadRequest, "key parameter is required")
+		return
+	}
+
+	if err := utils.ValidateKey(objectKey); err != nil {
+		utils.ErrorLog("handler", "PublicFile", err)
+		utils.ErrorResp(ctx, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	data, contentType, err := h.usecases.PublicFile(ctx.Request.Context(), objectKey, ctx.Query("tenant"), ctx.Query("sig"))
+	if err != nil {
+		utils.ErrorLog("handler", "PublicFile", err)
+		if errors.Is(err, utils.ErrWatermarkDisabled) || errors.Is(err, utils.ErrNotFound) {
+			utils.ErrorResp(ctx, http.StatusNotFound, err.Error())
+			return
+		}
+		if errors.Is(err, utils.ErrEncryptedObject) {
+			utils.ErrorResp(ctx, http.StatusConflict, err.Error())
+			return
+		}
+		if errors.Is(err, utils.ErrUnsupportedImage) {
+			utils.ErrorResp(ctx, http.StatusUnsupportedMediaType, err.Error())
+			return
+		}
+		if errors.Is(err, utils.ErrForbidden) {
+			utils.ErrorResp(ctx, http.StatusForbidden, err.Error())
+			return
+		}
+		utils.ErrorResp(ctx, http.StatusInternalServerError, err.Error())
+		return
+	}
+
+	// The URL does not change when the object is replaced under the same
+	// key, so shared caches only keep the image for a while.
+	ctx.Header("Cache-Control", "public, max-age=3600")
+	ctx.Data(http.StatusOK, contentType, data)
+}
+
+func (h *handlerUpload) DetailFile(ctx *gin.Context) {
+	objectKey := keyParam(ctx)
+	if objectKey == "" {
+		utils.ErrorLog("handler", "DetailFile", errors.New("key parameter is required"))
+		utils.ErrorResp(ctx, http.StatusBadRequest, "key parameter is required")
+		return
+	}
+
+	if err := utils.ValidateKey(objectKey); err != nil {
+		utils.ErrorLog("handler", "DetailFile", err)
+		utils.ErrorResp(ctx, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	details, err := h.usecases.DetailFile(ctx.Request.Context(), objectKey)
+	if err != nil {
+		utils.ErrorLog("handler", "DetailFile", err)
+		if errors.Is(err, utils.ErrNotFound) {
+			utils.ErrorResp(ctx, http.StatusNotFound, err.Error())
+			return
+		}
+		if errors.Is(err, utils.ErrForbidden) {
+			utils.ErrorResp(ctx, http.StatusForbidden, err.Error())
+			return
+		}
+		utils.ErrorResp(ctx, http.StatusInternalServerError, err.Error())
+		return
+	}
+
+	utils.SuccessResp(ctx, http.StatusOK, "success get file details", details)
+}
+
+// DownloadFile streams the object to the client. The checksums can only be
+// verified once the whole body has been read, so the last bytes are held back
+// until then: on a mismatch the response ends short of its Content-Length and
+// the connection is closed, which clients report as an incomplete download
+// rather than accepting a corrupted file. The expected digests are also sent
+// upfront in X-Checksum-Sha256 and X-Checksum-Crc32c so clients can verify
+// themselves.
+func (h *handlerUpload) DownloadFile(ctx *gin.Context) {
+	objectKey := keyParam(ctx)
+	if objectKey == "" {
+		utils.ErrorLog("handler", "DownloadFile", errors.New("key parameter is required"))
+		utils.ErrorResp(ctx, http.StatusBadRequest, "key parameter is required")
+		return
+	}
+
+	if err := utils.ValidateKey(objectKey); err != nil {
+		utils.ErrorLog("handler", "DownloadFile", err)
+		utils.ErrorResp(ctx, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	object, err := h.usecases.DownloadFile(ctx.Request.Context(), objectKey)
+	if err != nil {
+		utils.ErrorLog("handler", "DownloadFile", err)
+		if errors.Is(err, utils.ErrNotFound) {
+			utils.ErrorResp(ctx, http.StatusNotFound, err.Error())
+			return
+		}
+		if errors.Is(err, utils.ErrForbidden) {
+			utils.ErrorResp(ctx, http.StatusForbidden, err.Error())
+			return
+		}
+		utils.ErrorResp(ctx, http.StatusInternalServerError, err.Error())
+		return
+	}
+
+	writeObject(ctx, "DownloadFile", object)
+}
+
+// writeObject streams a verified object to the client and closes it; see
+// DownloadFile for the checksum headers.
+func writeObject(ctx *gin.Context, funcName string, object *model.ObjectStream) {
+	defer object.Body.Close()
+
+	ctx.Header("Content-Type", object.ContentType)
+	if object.ContentLength > 0 {
+		ctx.Header("Content-Length", strconv.FormatInt(object.ContentLength, 10))
+	}
+	if checksum := object.Metadata[utils.MetaChecksumSHA256]; checksum != "" {
+		ctx.Header("X-Checksum-Sha256", checksum)
+	}
+	if checksum := object.Metadata[utils.MetaChecksumCRC32C]; checksum != "" {
+		ctx.Header("X-Checksum-Crc32c", checksum)
+	}
+	ctx.Status(http.StatusOK)
+
+	err := copyVerified(ctx.Writer, object.Body)
+	if err != nil {
+		utils.ErrorLog("handler", funcName, err)
+	}
+}
+
+// copyVerified copies a verified body to w one chunk behind the reads, so
+// that the last chunk is only written once the read that follows it has
+// confirmed the checksums. On an error nothing more is written.
+func copyVerified(w io.Writer, body io.Reader) error {
+	buffers := [2][]byte{make([]byte, 32*1024), make([]byte, 32*1024)}
+	var pending []byte
+	for i := 0; ; i = 1 - i {
+		n, err := body.Read(buffers[i])
+		if err != nil && err != io.EOF {
+			return err
+		}
+
+		if len(pending) > 0 {
+			if _, werr := w.Write(pending); werr != nil {
+				return werr
+			}
+		}
+		pending = buffers[i][:n]
+
+		if err == io.EOF {
+			_, err = w.Write(pending)
+			return err
+		}
+	}
+}
+
+func (h *handlerUpload) UpdateMetadata(ctx *gin.Context) {
+	objectKey := keyParam(ctx)
+	if objectKey == "" {
+		utils.ErrorLog("handler", "UpdateMetadata", errors.New("key parameter is required"))
+		utils.ErrorResp(ctx, http.StatusBadRequest, "key parameter is required")
+		return
+	}
+
+	if err := utils.ValidateKey(objectKey); err != nil {
+		utils.ErrorLog("handler", "UpdateMetadata", err)
+		utils.ErrorResp(ctx, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	var metadataRequest model.UpdateMetadataRequest
+	if err := ctx.ShouldBindJSON(&metadataRequest); err != nil {
+		utils.ErrorLog("handler", "UpdateMetadata", err)
+		utils.ErrorResp(ctx, http.StatusBadRequest, "invalid JSON body: "+err.Error())
+		return
+	}
+
+	if metadataRequest.Title != nil {
+		title, err := utils.NormalizeTitle(*metadataRequest.Title)
+		if err != nil {
+			utils.ErrorLog("handler", "UpdateMetadata", err)
+			utils.ErrorResp(ctx, http.StatusBadRequest, err.Error())
+			return
+		}
+		metadataRequest.Title = &title
+	}
+
+	details, err := h.usecases.UpdateMetadata(ctx.Request.Context(), objectKey, &metadataRequest)
+	if err != nil {
+		utils.ErrorLog("handler", "UpdateMetadata", err)
+		if errors.Is(err, utils.ErrNotFound) {
+			utils.ErrorResp(ctx, http.StatusNotFound, err.Error())
+			return
+		}
+		if errors.Is(err, utils.ErrInvalidMetadata) || errors.Is(err, utils.ErrInvalidTags) {
+			utils.ErrorResp(ctx, http.StatusBadRequest, err.Error())
+			return
+		}
+		if errors.Is(err, utils.ErrForbidden) {
+			utils.ErrorResp(ctx, http.StatusForbidden, err.Error())
+			return
+		}
 		utils.ErrorResp(ctx, http.StatusInternalServerError, err.Error())
 		return
 	}
 
-	utils.SuccessResp(ctx, http.StatusOK, "success update object", nil)
+	utils.SuccessResp(ctx, http.StatusOK, "success update metadata", details)
+}
+
+func (h *handlerUpload) GetTags(ctx *gin.Context) {
+	objectKey := keyParam(ctx)
+	if objectKey == "" {
+		utils.ErrorLog("handler", "GetTags", errors.New("key parameter is required"))
+		utils.ErrorResp(ctx, http.StatusBadRequest, "key parameter is required")
+		return
+	}
+
+	if err := utils.ValidateKey(objectKey); err != nil {
+		utils.ErrorLog("handler", "GetTags", err)
+		utils.ErrorResp(ctx, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	tags, err := h.usecases.GetTags(ctx.Request.Context(), objectKey)
+	if err != nil {
+		utils.ErrorLog("handler", "GetTags", err)
+		if errors.Is(err, utils.ErrNotFound) {
+			utils.ErrorResp(ctx, http.StatusNotFound, err.Error())
+			return
+		}
+		if errors.Is(err, utils.ErrForbidden) {
+			utils.ErrorResp(ctx, http.StatusForbidden, err.Error())
+			return
+		}
+		utils.ErrorResp(ctx, http.StatusInternalServerError, err.Error())
+		return
+	}
+
+	utils.SuccessResp(ctx, http.StatusOK, "success get tags", tags)
+}
+
+func (h *handlerUpload) SetTags(ctx *gin.Context) {
+	objectKey := keyParam(ctx)
+	if objectKey == "" {
+		utils.ErrorLog("handler", "SetTags", errors.New("key parameter is required"))
+		utils.ErrorResp(ctx, http.StatusBadRequest, "key parameter is required")
+		return
+	}
+
+	if err := utils.ValidateKey(objectKey); err != nil {
+		utils.ErrorLog("handler", "SetTags", err)
+		utils.ErrorResp(ctx, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	var tagsRequest model.TagsRequest
+	if err := ctx.ShouldBindJSON(&tagsRequest); err != nil {
+		utils.ErrorLog("handler", "SetTags", err)
+		utils.ErrorResp(ctx, http.StatusBadRequest, "invalid JSON body: "+err.Error())
+		return
+	}
+
+	err := h.usecases.SetTags(ctx.Request.Context(), objectKey, tagsRequest.Tags)
+	if err != nil {
+		utils.ErrorLog("handler", "SetTags", err)
+		if errors.Is(err, utils.ErrNotFound) {
+			utils.ErrorResp(ctx, http.StatusNotFound, err.Error())
+			return
+		}
+		if errors.Is(err, utils.ErrInvalidTags) {
+			utils.ErrorResp(ctx, http.StatusBadRequest, err.Error())
+			return
+		}
+		if errors.Is(err, utils.ErrForbidden) {
+			utils.ErrorResp(ctx, http.StatusForbidden, err.Error())
+			return
+		}
+		utils.ErrorResp(ctx, http.StatusInternalServerError, err.Error())
+		return
+	}
+
+	utils.SuccessResp(ctx, http.StatusOK, "success set tags", tagsRequest.Tags)
+}
+
+func (h *handlerUpload) DeleteTags(ctx *gin.Context) {
+	objectKey := keyParam(ctx)
+	if objectKey == "" {
+		utils.ErrorLog("handler", "DeleteTags", errors.New("key parameter is required"))
+		utils.ErrorResp(ctx, http.StatusBadRequest, "key parameter is required")
+		return
+	}
+
+	if err := utils.ValidateKey(objectKey); err != nil {
+		utils.ErrorLog("handler", "DeleteTags", err)
+		utils.ErrorResp(ctx, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	err := h.usecases.DeleteTags(ctx.Request.Context(), objectKey)
+	if err != nil {
+		utils.ErrorLog("handler", "DeleteTags", err)
+		if errors.Is(err, utils.ErrNotFound) {
+			utils.ErrorResp(ctx, http.StatusNotFound, err.Error())
+			return
+		}
+		if errors.Is(err, utils.ErrForbidden) {
+			utils.ErrorResp(ctx, http.StatusForbidden, err.Error())
+			return
+		}
+		utils.ErrorResp(ctx, http.StatusInternalServerError, err.Error())
+		return
+	}
+
+	utils.SuccessResp(ctx, http.StatusOK, "success delete tags", nil)
+}
+
+// formTags reads upload tags sent as tags[name]=value form fields. It returns
+// nil when none are sent.
+func formTags(ctx *gin.Context) map[string]string {
+	tags := ctx.PostFormMap("tags")
+	if len(tags) == 0 {
+		return nil
+	}
+	return tags
+}
+
+// formEncrypt reads the encrypt form field, which requests envelope
+// encryption of the upload.
+func formEncrypt(ctx *gin.Context) (bool, error) {
+	value := ctx.PostForm("encrypt")
+	if value == "" {
+		return false, nil
+	}
+
+	encrypt, err := strconv.ParseBool(value)
+	if err != nil {
+		return false, errors.New("encrypt must be true or false")
+	}
+	return encrypt, nil
+}
+
+// formMetadata reads custom metadata sent as metadata[name]=value form
+// fields. It returns nil when none are sent.
+func formMetadata(ctx *gin.Context) map[string]string {
+	metadata := ctx.PostFormMap("metadata")
+	if len(metadata) == 0 {
+		return nil
+	}
+	return metadata
+}
+
+// setFormAccessibleText reads the altText and caption form fields and their
+// variants by language, sent as altText[lang]=value and caption[lang]=value.
+func setFormAccessibleText(ctx *gin.Context, fileRequest *model.FileRequest) {
+	fileRequest.AltText = ctx.PostForm("altText")
+	fileRequest.Caption = ctx.PostForm("caption")
+	fileRequest.AltTexts = ctx.PostFormMap("altText")
+	fileRequest.Captions = ctx.PostFormMap("caption")
+}
+
+// listFilter reads the query parameters of the list endpoint:
+// ?tag=name:value, repeated tags must all match, and ?missingAltText=true,
+// optionally with ?lang= to look for a missing translation.
+func listFilter(ctx *gin.Context) (*model.ListFilter, error) {
+	filter := &model.ListFilter{}
+
+	values := ctx.QueryArray("tag")
+	if len(values) > 0 {
+		filter.Tags = make(map[string]string, len(values))
+	}
+	for _, value := range values {
+		name, tagValue, ok := strings.Cut(value, ":")
+		if !ok || name == "" {
+			return nil, fmt.Errorf("tag filter %q must have the form name:value", value)
+		}
+		filter.Tags[name] = tagValue
+	}
+
+	if value := ctx.Query("missingAltText"); value != "" {
+		missing, err := strconv.ParseBool(value)
+		if err != nil {
+			return nil, errors.New("missingAltText must be true or false")
+		}
+		filter.MissingAltText = missing
+	}
+
+	if lang := ctx.Query("lang"); lang != "" {
+		var err error
+		filter.Language, err = utils.NormalizeLanguage(lang)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	return filter, nil
+}
+
+// keyParam returns the :key path parameter. Routes use a catch-all so that
+// keys containing slashes can be addressed; the leading slash is stripped.
+func keyParam(ctx *gin.Context) string {
+	return strings.TrimPrefix(ctx.Param("key"), "/")
 }
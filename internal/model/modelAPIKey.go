@@ -0,0 +1,29 @@
+package model
+
+import "time"
+
+// APIKey is the stored form of an API key. Only the SHA-256 hash of the
+// secret is kept.
+type APIKey struct {
+	ID         string     `json:"id"`
+	Name       string     `json:"name"`
+	Hash       string     `json:"hash,omitempty"`
+	Scopes     []string   `json:"scopes"`
+	Tenant     string     `json:"tenant,omitempty"`
+	CreatedBy  string     `json:"createdBy,omitempty"`
+	CreatedAt  time.Time  `json:"createdAt"`
+	LastUsedAt *time.Time `json:"lastUsedAt,omitempty"`
+	RevokedAt  *time.Time `json:"revokedAt,omitempty"`
+}
+
+type APIKeyRequest struct {
+	Name   string   `json:"name" binding:"required"`
+	Scopes []string `json:"scopes" binding:"required"`
+}
+
+// CreatedAPIKey is returned once, when the key is created; the key cannot be
+// retrieved later.
+type CreatedAPIKey struct {
+	APIKey
+	Key string `json:"key"`
+}
@@ -0,0 +1,37 @@
+package model
+
+import "time"
+
+// Permissions that can be granted on an object. PermissionWrite includes
+// PermissionRead. Deleting an object and managing its access are reserved
+// for the owner.
+const (
+	PermissionRead  = "read"
+	PermissionWrite = "write"
+	PermissionOwner = "owner"
+)
+
+// GrantablePermissions are the permissions AccessRequest accepts.
+var GrantablePermissions = []string{PermissionRead, PermissionWrite}
+
+// ObjectAccess lists who may access an object besides its owner. Users are
+// identity subjects and Groups come from the caller identity; both map to a
+// permission. Public objects can be read by every caller of the tenant.
+type ObjectAccess struct {
+	Key       string            `json:"key"`
+	Owner     string            `json:"owner,omitempty"`
+	Public    bool              `json:"public"`
+	Users     map[string]string `json:"users"`
+	Groups    map[string]string `json:"groups"`
+	UpdatedAt *time.Time        `json:"updatedAt,omitempty"`
+}
+
+// AccessRequest grants or revokes access. On grant Permission defaults to
+// read and Public makes the object public when true; on revoke Permission is
+// ignored and Public true makes the object private again.
+type AccessRequest struct {
+	Users      []string `json:"users"`
+	Groups     []string `json:"groups"`
+	Permission string   `json:"permission"`
+	Public     bool     `json:"public"`
+}
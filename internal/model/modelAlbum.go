@@ -0,0 +1,39 @@
+package model
+
+import "time"
+
+// Album groups objects in a custom order. Albums are stored as JSON documents
+// under utils.AlbumsPrefix.
+type Album struct {
+	ID        string    `json:"id"`
+	Name      string    `json:"name"`
+	Keys      []string  `json:"keys"`
+	CoverKey  string    `json:"coverKey,omitempty"`
+	CreatedAt time.Time `json:"createdAt"`
+	UpdatedAt time.Time `json:"updatedAt"`
+}
+
+type AlbumRequest struct {
+	Name string `json:"name" binding:"required"`
+}
+
+type AlbumKeysRequest struct {
+	Keys []string `json:"keys" binding:"required"`
+}
+
+// AlbumCoverRequest sets the cover image. An empty key falls back to the
+// first object of the album.
+type AlbumCoverRequest struct {
+	Key string `json:"key"`
+}
+
+type AlbumModel struct {
+	Album
+	Count    int    `json:"count"`
+	CoverUrl string `json:"coverUrl,omitempty"`
+}
+
+type AlbumContents struct {
+	AlbumModel
+	Items []FileModel `json:"items"`
+}
@@ -0,0 +1,37 @@
+package model
+
+import "time"
+
+const (
+	JobRunning   = "running"
+	JobCompleted = "completed"
+	JobFailed    = "failed"
+)
+
+// RewrapJob tracks the re-wrapping of data keys with the current master key
+// version. Tenants are processed in order, the bucket root first, and keys
+// in lexicographic order, so Tenant and After mark where an interrupted job
+// resumes. Objects that fail, or that changed while being re-wrapped
+// (RetryLater), are counted and left for the next run.
+//
+// A running job holds a lease on the document: LeaseID identifies the run,
+// and another run may only take the job over once LeaseExpiresAt passed.
+type RewrapJob struct {
+	KeyVersion  string     `json:"keyVersion"`
+	Status      string     `json:"status"`
+	Tenant      string     `json:"tenant"`
+	After       string     `json:"after,omitempty"`
+	Tenants     int        `json:"tenants"`
+	TenantsDone int        `json:"tenantsDone"`
+	Scanned     int        `json:"scanned"`
+	Rewrapped   int        `json:"rewrapped"`
+	Failed      int        `json:"failed"`
+	RetryLater  int        `json:"retryLater"`
+	LastError   string     `json:"lastError,omitempty"`
+	StartedAt   time.Time  `json:"startedAt"`
+	UpdatedAt   time.Time  `json:"updatedAt"`
+	CompletedAt *time.Time `json:"completedAt,omitempty"`
+
+	LeaseID        string     `json:"leaseId,omitempty"`
+	LeaseExpiresAt *time.Time `json:"leaseExpiresAt,omitempty"`
+}
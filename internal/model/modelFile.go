@@ -1,10 +1,37 @@
 package model
 
-import "mime/multipart"
+import (
+	"io"
+	"mime/multipart"
+	"time"
+)
 
 type FileRequest struct {
 	Title string                `json:"title" binding:"required"`
 	File  *multipart.FileHeader `json:"file" binding:"required"`
+	Tags  map[string]string     `json:"tags"`
+	// Metadata holds custom fields, validated against the METADATA_SCHEMA.
+	Metadata map[string]string `json:"metadata"`
+	AltText  string            `json:"altText"`
+	Caption  string            `json:"caption"`
+	// AltTexts and Captions hold variants by language.
+	AltTexts map[string]string `json:"altTexts"`
+	Captions map[string]string `json:"captions"`
+	// Encrypt requests envelope encryption of the file, which then has no
+	// presigned URL and is only readable through the download endpoint.
+	Encrypt bool `json:"encrypt"`
+}
+
+type TagsRequest struct {
+	Tags map[string]string `json:"tags" binding:"required"`
+}
+
+// ListFilter narrows ListObjects. Tags must all match. MissingAltText keeps
+// only images without alt text, in Language when it is set.
+type ListFilter struct {
+	Tags           map[string]string
+	MissingAltText bool
+	Language       string
 }
 
 type UpdateFileRequest struct {
@@ -19,10 +46,107 @@ type DeleteFileRequest struct {
 type CopyObjectRequest struct {
 	OldKey string `json:"oldKey" binding:"required"`
 	NewKey string `json:"newKey" binding:"required"`
+	// When Metadata is set the copy replaces the object metadata and
+	// headers below instead of copying them from OldKey.
+	Metadata           map[string]string `json:"-"`
+	ContentType        string            `json:"-"`
+	CacheControl       string            `json:"-"`
+	ContentDisposition string            `json:"-"`
+	// When Tags is set the copy replaces the object tags.
+	Tags map[string]string `json:"-"`
+	// When IfMatch is set the copy only happens if OldKey still has this
+	// ETag, and fails with utils.ErrPreconditionFailed otherwise.
+	IfMatch string `json:"-"`
+}
+
+// UpdateMetadataRequest changes object metadata in place. Nil fields are
+// left unchanged; Metadata and Tags replace the whole set when given.
+// AltTexts and Captions are merged by language, and an empty string removes
+// alt text or a caption.
+type UpdateMetadataRequest struct {
+	Title              *string           `json:"title"`
+	ContentDisposition *string           `json:"contentDisposition"`
+	CacheControl       *string           `json:"cacheControl"`
+	Metadata           map[string]string `json:"metadata"`
+	Tags               map[string]string `json:"tags"`
+	AltText            *string           `json:"altText"`
+	Caption            *string           `json:"caption"`
+	AltTexts           map[string]string `json:"altTexts"`
+	Captions           map[string]string `json:"captions"`
 }
 
 type FileModel struct {
-	Key   string `json:"key"`
-	Title string `json:"title"`
-	Url   string `json:"url"`
+	Key           string            `json:"key"`
+	Title         string            `json:"title"`
+	Url           string            `json:"url"`
+	BlurHash      string            `json:"blurHash,omitempty"`
+	DominantColor string            `json:"dominantColor,omitempty"`
+	AverageColor  string            `json:"averageColor,omitempty"`
+	PHash         string            `json:"phash,omitempty"`
+	Size          int64             `json:"size"`
+	OriginalSize  int64             `json:"originalSize,omitempty"`
+	Encrypted     bool              `json:"encrypted,omitempty"`
+	KeyVersion    string            `json:"keyVersion,omitempty"`
+	Metadata      map[string]string `json:"metadata,omitempty"`
+	UploadedBy    string            `json:"uploadedBy,omitempty"`
+	Owner         string            `json:"owner,omitempty"`
+	AltText       string            `json:"altText,omitempty"`
+	Caption       string            `json:"caption,omitempty"`
+	AltTexts      map[string]string `json:"altTexts,omitempty"`
+	Captions      map[string]string `json:"captions,omitempty"`
+}
+
+// PerceptualHashIndex maps the keys of the images of a tenant to their
+// perceptual hash, so that near duplicates are found without listing the
+// objects.
+type PerceptualHashIndex struct {
+	Hashes map[string]string `json:"hashes"`
+}
+
+type DuplicateModel struct {
+	FileModel
+	Distance int `json:"distance"`
+}
+
+type FileDetails struct {
+	FileModel
+	ContentType        string            `json:"contentType"`
+	ETag               string            `json:"etag"`
+	LastModified       time.Time         `json:"lastModified"`
+	ChecksumSHA256     string            `json:"checksumSha256,omitempty"`
+	ChecksumCRC32C     string            `json:"checksumCrc32c,omitempty"`
+	CacheControl       string            `json:"cacheControl,omitempty"`
+	ContentDisposition string            `json:"contentDisposition,omitempty"`
+	Tags               map[string]string `json:"tags,omitempty"`
+	Encryption         EncryptionStatus  `json:"encryption"`
+	RawMetadata        map[string]string `json:"-"`
+}
+
+// EncryptionStatus is the server-side encryption of a stored object. Mode is
+// one of the utils.Encryption* modes.
+type EncryptionStatus struct {
+	Mode           string `json:"mode"`
+	Algorithm      string `json:"algorithm,omitempty"`
+	KMSKeyID       string `json:"kmsKeyId,omitempty"`
+	BucketKey      bool   `json:"bucketKey,omitempty"`
+	CustomerKeyMD5 string `json:"customerKeyMd5,omitempty"`
+}
+
+type ObjectStream struct {
+	Body          io.ReadCloser
+	ContentType   string
+	ContentLength int64
+	Metadata      map[string]string
+	ETag          string
+}
+
+// AccessibleText holds the alt texts and captions of an object. It is kept
+// in its own document rather than in the object metadata, which S3 limits to
+// 2 KB in total.
+type AccessibleText struct {
+	Key      string            `json:"key"`
+	AltText  string            `json:"altText,omitempty"`
+	Caption  string            `json:"caption,omitempty"`
+	AltTexts map[string]string `json:"altTexts,omitempty"`
+	Captions map[string]string `json:"captions,omitempty"`
 }
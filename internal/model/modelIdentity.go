@@ -0,0 +1,95 @@
+package model
+
+import (
+	"context"
+	"slices"
+)
+
+// contextKey is the type of the context keys of this package, so that they
+// cannot collide with values set by other packages.
+type contextKey int
+
+const (
+	// identityKey is the context key of the authenticated caller; see
+	// WithIdentity.
+	identityKey contextKey = iota
+	// tenantKey is the context key of the tenant whose objects a request may
+	// access. It is set next to the identity and can be overridden with
+	// WithTenant.
+	tenantKey
+)
+
+const (
+	AuthMethodJWT    = "jwt"
+	AuthMethodAPIKey = "api-key"
+)
+
+// Scopes guard groups of routes. ScopeAdmin grants every scope, and is
+// never implied.
+const (
+	ScopeUpload  = "upload"
+	ScopePreview = "preview"
+	ScopeList    = "list"
+	ScopeUpdate  = "update"
+	ScopeDelete  = "delete"
+	ScopeRename  = "rename"
+	ScopeAdmin   = "admin"
+)
+
+// APIKeyScopes are the scopes an API key can be granted.
+var APIKeyScopes = []string{ScopeUpload, ScopePreview, ScopeList, ScopeUpdate, ScopeDelete, ScopeRename}
+
+type Identity struct {
+	Subject string   `json:"subject"`
+	Issuer  string   `json:"issuer,omitempty"`
+	Method  string   `json:"method"`
+	Groups  []string `json:"groups,omitempty"`
+	// Tenant scopes the objects the caller can see. Empty means the bucket
+	// root, shared by callers without a tenant.
+	Tenant string `json:"tenant,omitempty"`
+	// Scopes limits what the caller may do. Nil means every scope but
+	// ScopeAdmin.
+	Scopes []string       `json:"scopes,omitempty"`
+	Claims map[string]any `json:"-"`
+}
+
+func (i *Identity) HasScope(scope string) bool {
+	if i.IsAdmin() {
+		return true
+	}
+	if scope == ScopeAdmin {
+		return false
+	}
+	return i.Scopes == nil || slices.Contains(i.Scopes, scope)
+}
+
+// IsAdmin reports whether the caller was explicitly granted ScopeAdmin.
+func (i *Identity) IsAdmin() bool {
+	return slices.Contains(i.Scopes, ScopeAdmin)
+}
+
+// IdentityFromContext returns the authenticated caller, or nil when the
+// request was not authenticated or ctx does not belong to a request.
+func IdentityFromContext(ctx context.Context) *Identity {
+	identity, _ := ctx.Value(identityKey).(*Identity)
+	return identity
+}
+
+// WithIdentity returns a context acting as identity. A nil identity acts as
+// the service itself, which is not bound by object ownership.
+func WithIdentity(ctx context.Context, identity *Identity) context.Context {
+	return context.WithValue(ctx, identityKey, identity)
+}
+
+// TenantFromContext returns the tenant of the request, or "" for the bucket
+// root.
+func TenantFromContext(ctx context.Context) string {
+	tenant, _ := ctx.Value(tenantKey).(string)
+	return tenant
+}
+
+// WithTenant returns a context scoped to tenant, for work outside a request
+// or on service-wide documents.
+func WithTenant(ctx context.Context, tenant string) context.Context {
+	return context.WithValue(ctx, tenantKey, tenant)
+}
@@ -0,0 +1,29 @@
+package model
+
+import "time"
+
+// ReferenceRequest registers or releases a reference from an external
+// entity, e.g. "inspection:1234", to an object.
+type ReferenceRequest struct {
+	Key    string `json:"key" binding:"required"`
+	Entity string `json:"entity" binding:"required"`
+}
+
+type Reference struct {
+	Entity    string    `json:"entity"`
+	CreatedAt time.Time `json:"createdAt"`
+}
+
+// ObjectReferences lists the external references to an object. OrphanedAt
+// is set when the last reference is released, and DeletingAt while the
+// object is being deleted, during which no reference can be registered.
+type ObjectReferences struct {
+	Key        string      `json:"key"`
+	References []Reference `json:"references"`
+	OrphanedAt *time.Time  `json:"orphanedAt,omitempty"`
+	DeletingAt *time.Time  `json:"deletingAt,omitempty"`
+}
+
+type GarbageCollection struct {
+	Deleted []string `json:"deleted"`
+}
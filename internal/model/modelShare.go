@@ -0,0 +1,55 @@
+package model
+
+import "time"
+
+// Outcomes recorded in the access log of a share link.
+const (
+	ShareResultOK              = "ok"
+	ShareResultInvalidPassword = "invalid-password"
+	ShareResultUnavailable     = "unavailable"
+	ShareResultError           = "error"
+)
+
+// ShareLink is a service-managed link to an object. Only the bcrypt hash of
+// the password is kept, and it is never returned. ObjectID is the
+// utils.MetaObjectID of the object when the link was created; the link stops
+// working once its key holds another object.
+type ShareLink struct {
+	Token        string        `json:"token"`
+	Url          string        `json:"url,omitempty"`
+	Key          string        `json:"key"`
+	ObjectID     string        `json:"objectId,omitempty"`
+	Tenant       string        `json:"tenant,omitempty"`
+	PasswordHash string        `json:"passwordHash,omitempty"`
+	HasPassword  bool          `json:"hasPassword"`
+	MaxDownloads int           `json:"maxDownloads,omitempty"`
+	Downloads    int           `json:"downloads"`
+	CreatedBy    string        `json:"createdBy,omitempty"`
+	CreatedAt    time.Time     `json:"createdAt"`
+	ExpiresAt    time.Time     `json:"expiresAt"`
+	RevokedAt    *time.Time    `json:"revokedAt,omitempty"`
+	Accesses     []ShareAccess `json:"accesses,omitempty"`
+}
+
+// ShareIndex lists the tokens of the share links to an object.
+type ShareIndex struct {
+	Key    string   `json:"key"`
+	Tokens []string `json:"tokens"`
+}
+
+// ShareAccess is an entry of the access log of a share link.
+type ShareAccess struct {
+	At        time.Time `json:"at"`
+	IP        string    `json:"ip"`
+	UserAgent string    `json:"userAgent,omitempty"`
+	Result    string    `json:"result"`
+}
+
+// ShareRequest creates a share link. ExpiresIn is in seconds and defaults to
+// SHARE_DEFAULT_TTL; MaxDownloads 0 means unlimited.
+type ShareRequest struct {
+	Key          string `json:"key" binding:"required"`
+	ExpiresIn    int    `json:"expiresIn"`
+	Password     string `json:"password"`
+	MaxDownloads int    `json:"maxDownloads"`
+}
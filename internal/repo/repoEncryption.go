@@ -0,0 +1,198 @@
+package repo
+
+import (
+	"context"
+	"errors"
+	"slices"
+	"strings"
+
+	"github.com/adityaw24/go-aws-garasi/internal/model"
+	"github.com/adityaw24/go-aws-garasi/utils"
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+	"github.com/aws/aws-sdk-go-v2/service/s3/types"
+	"github.com/aws/smithy-go"
+)
+
+// customerAlgorithm is the only algorithm S3 supports for SSE-C.
+const customerAlgorithm = "AES256"
+
+// encryptPut applies the configured encryption to a new object. The
+// multipart uploader copies these fields to every part.
+func (repo *repoUpload) encryptPut(input *s3.PutObjectInput) {
+	switch repo.sse.Mode {
+	case utils.EncryptionS3:
+		input.ServerSideEncryption = types.ServerSideEncryptionAes256
+	case utils.EncryptionKMS:
+		input.ServerSideEncryption = types.ServerSideEncryptionAwsKms
+		input.SSEKMSKeyId = repo.kmsKeyID()
+		input.BucketKeyEnabled = repo.bucketKey()
+	case utils.EncryptionCustomer:
+		input.SSECustomerAlgorithm, input.SSECustomerKey, input.SSECustomerKeyMD5 = repo.customerKey()
+	}
+}
+
+// encryptCopy applies the configured encryption to the copy, and the
+// customer key needed to read the source.
+func (repo *repoUpload) encryptCopy(input *s3.CopyObjectInput) {
+	switch repo.sse.Mode {
+	case utils.EncryptionS3:
+		input.ServerSideEncryption = types.ServerSideEncryptionAes256
+	case utils.EncryptionKMS:
+		input.ServerSideEncryption = types.ServerSideEncryptionAwsKms
+		input.SSEKMSKeyId = repo.kmsKeyID()
+		input.BucketKeyEnabled = repo.bucketKey()
+	case utils.EncryptionCustomer:
+		input.SSECustomerAlgorithm, input.SSECustomerKey, input.SSECustomerKeyMD5 = repo.customerKey()
+		input.CopySourceSSECustomerAlgorithm, input.CopySourceSSECustomerKey, input.CopySourceSSECustomerKeyMD5 = repo.customerKey()
+	}
+}
+
+// headInput builds a HeadObjectInput carrying the customer key, without
+// which S3 rejects requests for SSE-C objects.
+func (repo *repoUpload) headInput(key string) *s3.HeadObjectInput {
+	input := &s3.HeadObjectInput{
+		Bucket: aws.String(repo.bucketName),
+		Key:    aws.String(key),
+	}
+	input.SSECustomerAlgorithm, input.SSECustomerKey, input.SSECustomerKeyMD5 = repo.customerKey()
+	return input
+}
+
+// getInput builds a GetObjectInput carrying the customer key.
+func (repo *repoUpload) getInput(key string) *s3.GetObjectInput {
+	input := &s3.GetObjectInput{
+		Bucket: aws.String(repo.bucketName),
+		Key:    aws.String(key),
+	}
+	input.SSECustomerAlgorithm, input.SSECustomerKey, input.SSECustomerKeyMD5 = repo.customerKey()
+	return input
+}
+
+// Objects stored before SSE_MODE was switched to sse-c are not encrypted with
+// the customer key, and S3 rejects requests that send it for them. The
+// helpers below retry those requests without the key, so such objects stay
+// readable; copying one (a rename or a metadata update) stores it with the
+// key. Only that specific refusal is retried: other errors, including a
+// wrong customer key, are returned as they are. The other way round, objects
+// stored under sse-c are unreadable once the key is no longer configured.
+
+// customerKeyNotApplicable lists the messages, in lower case, with which S3
+// refuses a customer key for an object that is not encrypted with one.
+var customerKeyNotApplicable = []string{
+	"the encryption parameters are not applicable to this object",
+	"the object was not encrypted with customer key",
+}
+
+// headObject runs HeadObject, without the customer key if S3 rejects it.
+// HEAD errors have no body to tell why S3 rejected the request, so the
+// retry is only used when it shows an object stored without SSE-C;
+// otherwise the first error is returned.
+func (repo *repoUpload) headObject(ctx context.Context, input *s3.HeadObjectInput) (*s3.HeadObjectOutput, error) {
+	output, err := repo.s3Client.HeadObject(ctx, input)
+	if err == nil || input.SSECustomerKey == nil || !apiErrorCode(err, "BadRequest") {
+		return output, err
+	}
+
+	plain := *input
+	plain.SSECustomerAlgorithm, plain.SSECustomerKey, plain.SSECustomerKeyMD5 = nil, nil, nil
+	plainOutput, plainErr := repo.s3Client.HeadObject(ctx, &plain)
+	if plainErr != nil || plainOutput.SSECustomerAlgorithm != nil {
+		return output, err
+	}
+	return plainOutput, nil
+}
+
+// getObject runs GetObject, without the customer key if S3 rejects it.
+func (repo *repoUpload) getObject(ctx context.Context, input *s3.GetObjectInput) (*s3.GetObjectOutput, error) {
+	output, err := repo.s3Client.GetObject(ctx, input)
+	if err != nil && input.SSECustomerKey != nil && customerKeyRejected(err) {
+		input.SSECustomerAlgorithm, input.SSECustomerKey, input.SSECustomerKeyMD5 = nil, nil, nil
+		output, err = repo.s3Client.GetObject(ctx, input)
+	}
+	return output, err
+}
+
+// copyObject runs CopyObject, reading the source without the customer key
+// if S3 rejects it.
+func (repo *repoUpload) copyObject(ctx context.Context, input *s3.CopyObjectInput) (*s3.CopyObjectOutput, error) {
+	output, err := repo.s3Client.CopyObject(ctx, input)
+	if err != nil && input.CopySourceSSECustomerKey != nil && customerKeyRejected(err) {
+		input.CopySourceSSECustomerAlgorithm, input.CopySourceSSECustomerKey, input.CopySourceSSECustomerKeyMD5 = nil, nil, nil
+		output, err = repo.s3Client.CopyObject(ctx, input)
+	}
+	return output, err
+}
+
+// customerKeyRejected reports whether S3 refused a GET or a copy because it
+// sent the customer key for an object not encrypted with one.
+func customerKeyRejected(err error) bool {
+	var apiErr smithy.APIError
+	if !errors.As(err, &apiErr) || !apiErrorCode(err, "InvalidRequest", "InvalidArgument") {
+		return false
+	}
+
+	message := strings.ToLower(apiErr.ErrorMessage())
+	return slices.ContainsFunc(customerKeyNotApplicable, func(notApplicable string) bool {
+		return strings.Contains(message, notApplicable)
+	})
+}
+
+// apiErrorCode reports whether err is an S3 error with one of codes.
+func apiErrorCode(err error, codes ...string) bool {
+	var apiErr smithy.APIError
+	return errors.As(err, &apiErr) && slices.Contains(codes, apiErr.ErrorCode())
+}
+
+// presignable reports whether objects can be read through presigned URLs.
+// Under sse-c they cannot: the URL would only work with the customer key,
+// which clients must never be given, so objects are read through the
+// service instead.
+func (repo *repoUpload) presignable() bool {
+	return !repo.sse.Customer()
+}
+
+// customerKey returns the SSE-C headers, or nils for other modes.
+func (repo *repoUpload) customerKey() (*string, *string, *string) {
+	if !repo.sse.Customer() {
+		return nil, nil, nil
+	}
+	return aws.String(customerAlgorithm), aws.String(repo.sse.CustomerKey), aws.String(repo.sse.CustomerKeyMD5)
+}
+
+func (repo *repoUpload) kmsKeyID() *string {
+	if repo.sse.KMSKeyID == "" {
+		return nil
+	}
+	return aws.String(repo.sse.KMSKeyID)
+}
+
+func (repo *repoUpload) bucketKey() *bool {
+	if !repo.sse.BucketKey {
+		return nil
+	}
+	return aws.Bool(true)
+}
+
+// encryptionStatus describes how S3 encrypted an object, from the headers
+// of a HEAD response.
+func encryptionStatus(output *s3.HeadObjectOutput) model.EncryptionStatus {
+	status := model.EncryptionStatus{Mode: utils.EncryptionNone}
+
+	switch {
+	case aws.ToString(output.SSECustomerAlgorithm) != "":
+		status.Mode = utils.EncryptionCustomer
+		status.Algorithm = aws.ToString(output.SSECustomerAlgorithm)
+		status.CustomerKeyMD5 = aws.ToString(output.SSECustomerKeyMD5)
+	case strings.HasPrefix(string(output.ServerSideEncryption), string(types.ServerSideEncryptionAwsKms)):
+		status.Mode = utils.EncryptionKMS
+		status.Algorithm = string(output.ServerSideEncryption)
+		status.KMSKeyID = aws.ToString(output.SSEKMSKeyId)
+		status.BucketKey = aws.ToBool(output.BucketKeyEnabled)
+	case output.ServerSideEncryption != "":
+		status.Mode = utils.EncryptionS3
+		status.Algorithm = string(output.ServerSideEncryption)
+	}
+
+	return status
+}
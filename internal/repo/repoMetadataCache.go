@@ -0,0 +1,85 @@
+package repo
+
+import (
+	"container/list"
+	"sync"
+	"time"
+)
+
+// maxCachedMetadata bounds the number of objects whose metadata is cached.
+const maxCachedMetadata = 100000
+
+// metadataCache keeps the metadata of listed objects, so that a listing only
+// sends a HEAD request for the objects that changed since they were last
+// listed. Entries are checked against the ETag and the modification time of
+// the listing; replacing the metadata with CopyObject changes the latter.
+// Writes through this repository also drop the entry of their object.
+type metadataCache struct {
+	mu      sync.Mutex
+	order   *list.List
+	entries map[string]*list.Element
+}
+
+type cachedMetadata struct {
+	key          string
+	etag         string
+	lastModified time.Time
+	metadata     map[string]string
+}
+
+func newMetadataCache() *metadataCache {
+	return &metadataCache{
+		order:   list.New(),
+		entries: make(map[string]*list.Element),
+	}
+}
+
+// get returns the metadata cached for key if the object was not modified
+// since. The map must not be modified.
+func (c *metadataCache) get(key string, etag string, lastModified time.Time) (map[string]string, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	element, ok := c.entries[key]
+	if !ok {
+		return nil, false
+	}
+
+	entry := element.Value.(*cachedMetadata)
+	if entry.etag != etag || !entry.lastModified.Equal(lastModified) {
+		c.remove(element)
+		return nil, false
+	}
+	c.order.MoveToFront(element)
+
+	return entry.metadata, true
+}
+
+func (c *metadataCache) add(key string, etag string, lastModified time.Time, metadata map[string]string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if element, ok := c.entries[key]; ok {
+		c.remove(element)
+	}
+
+	c.entries[key] = c.order.PushFront(&cachedMetadata{key: key, etag: etag, lastModified: lastModified, metadata: metadata})
+	for c.order.Len() > maxCachedMetadata {
+		c.remove(c.order.Back())
+	}
+}
+
+// forget drops the entry of key after the object was written or deleted.
+func (c *metadataCache) forget(key string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if element, ok := c.entries[key]; ok {
+		c.remove(element)
+	}
+}
+
+func (c *metadataCache) remove(element *list.Element) {
+	entry := c.order.Remove(element).(*cachedMetadata)
+	delete(c.entries, entry.key)
+}
@@ -2,11 +2,14 @@ package repo
 
 import (
 	"bytes"
+	"context"
+	"encoding/json"
 	"errors"
 	"fmt"
 	"io"
 	"log"
-	"net/http"
+	"net/url"
+	"strconv"
 	"strings"
 	"time"
 
@@ -17,16 +20,30 @@ import (
 	"github.com/aws/aws-sdk-go-v2/service/s3"
 	"github.com/aws/aws-sdk-go-v2/service/s3/types"
 	"github.com/aws/smithy-go"
-	"github.com/gin-gonic/gin"
 )
 
 type RepoUpload interface {
-	UploadFile(ctx *gin.Context, file io.Reader, objectKey string, attach utils.Upload, largeObject []byte) error
-	ListObjects(ctx *gin.Context) ([]model.FileModel, error)
-	PreviewFile(ctx *gin.Context, objectKey string) (string, error)
-	CopyObject(ctx *gin.Context, objectRequest *model.CopyObjectRequest) error
-	UpdateFile(ctx *gin.Context, oldKey string, file io.Reader, newKey string, attach utils.Upload, largeObject []byte) error
-	DeleteFile(ctx *gin.Context, key string) error
+	UploadFile(ctx context.Context, file io.Reader, objectKey string, attach utils.Upload, largeObject []byte) error
+	ListObjects(ctx context.Context) ([]model.FileModel, error)
+	PreviewFile(ctx context.Context, objectKey string) (string, error)
+	HeadMetadata(ctx context.Context, objectKey string) (map[string]string, error)
+	GetObject(ctx context.Context, objectKey string) (*model.ObjectStream, error)
+	PutObject(ctx context.Context, objectKey string, body []byte, contentType string, metadata map[string]string) error
+	ListKeys(ctx context.Context, prefix string) ([]string, error)
+	ListTenants(ctx context.Context) ([]string, error)
+	StatObject(ctx context.Context, objectKey string) (*model.FileDetails, error)
+	GetFileModel(ctx context.Context, objectKey string) (*model.FileModel, error)
+	GetJSON(ctx context.Context, objectKey string, v any) error
+	PutJSON(ctx context.Context, objectKey string, v any) error
+	GetJSONWithETag(ctx context.Context, objectKey string, v any) (string, error)
+	PutJSONIfMatch(ctx context.Context, objectKey string, v any, etag string) (string, error)
+	DeleteFileIfMatch(ctx context.Context, objectKey string, etag string) error
+	GetTags(ctx context.Context, objectKey string) (map[string]string, error)
+	PutTags(ctx context.Context, objectKey string, tags map[string]string) error
+	DeleteTags(ctx context.Context, objectKey string) error
+	CopyObject(ctx context.Context, objectRequest *model.CopyObjectRequest) error
+	UpdateFile(ctx context.Context, oldKey string, file io.Reader, newKey string, attach utils.Upload, largeObject []byte) error
+	DeleteFile(ctx context.Context, key string) error
 }
 
 type repoUpload struct {
@@ -34,42 +51,41 @@ type repoUpload struct {
 	s3PresignedClient *s3.PresignClient
 	bucketName        string
 	timeout           time.Duration
+	sse               utils.ServerSideEncryption
+	metadata          *metadataCache
 }
 
-func NewRepoUpload(client *s3.Client, presignClient *s3.PresignClient, bucketName string, timeout time.Duration) *repoUpload {
+func NewRepoUpload(client *s3.Client, presignClient *s3.PresignClient, bucketName string, timeout time.Duration, sse utils.ServerSideEncryption) *repoUpload {
 	return &repoUpload{
 		s3Client:          client,
 		s3PresignedClient: presignClient,
 		bucketName:        bucketName,
 		timeout:           timeout,
+		sse:               sse,
+		metadata:          newMetadataCache(),
 	}
 }
 
-func (repo *repoUpload) UploadFile(ctx *gin.Context, file io.Reader, objectKey string, attach utils.Upload, largeObject []byte) error {
+func (repo *repoUpload) UploadFile(ctx context.Context, file io.Reader, objectKey string, attach utils.Upload, largeObject []byte) error {
 	largeBuffer := bytes.NewReader(largeObject)
 	var partMiBs int64 = 10
 
-	key := attach.Prefix + objectKey
+	key := objectKey
 
 	uploader := manager.NewUploader(repo.s3Client, func(u *manager.Uploader) {
 		u.PartSize = partMiBs * 1024 * 1024
 	})
 
-	_, err := uploader.Upload(ctx, &s3.PutObjectInput{
-		Bucket:        aws.String(repo.bucketName),
-		Key:           aws.String(key),
-		Body:          largeBuffer,
-		ContentLength: aws.Int64(attach.Length),
-		ContentType:   aws.String(attach.ContentType),
-	})
+	_, err := uploader.Upload(ctx, repo.putObjectInput(repo.tenantKey(ctx, key), largeBuffer, attach, largeObject, partMiBs*1024*1024))
+	repo.metadata.forget(repo.tenantKey(ctx, key))
 
 	if err != nil {
+		if tooLarge := metadataTooLarge(err); tooLarge != nil {
+			return tooLarge
+		}
 		var apiErr smithy.APIError
 		if errors.As(err, &apiErr) && apiErr.ErrorCode() == "EntityTooLarge" {
-			errMsg := "error uploading. The object is too large.\n" +
-				"The maximum size for a multipart upload is 5TB."
-			utils.ErrorResp(ctx, http.StatusBadRequest, errMsg)
-			return nil
+			return fmt.Errorf("error uploading: %w, the maximum size for a multipart upload is 5TB", utils.ErrObjectTooLarge)
 		} else {
 			log.Printf("Couldn't upload large object to %v:%v. Here's why: %v\n",
 				repo.bucketName, objectKey, err)
@@ -80,16 +96,51 @@ func (repo *repoUpload) UploadFile(ctx *gin.Context, file io.Reader, objectKey s
 	return nil
 }
 
-func (repo *repoUpload) UpdateFile(ctx *gin.Context, oldKey string, file io.Reader, newKey string, attach utils.Upload, largeObject []byte) error {
-	_, err := repo.s3Client.HeadObject(ctx, &s3.HeadObjectInput{
-		Bucket: aws.String(repo.bucketName),
-		Key:    aws.String(oldKey),
-	})
+// putObjectInput builds the PutObjectInput for an upload, including its
+// integrity checksums. Both the SHA-256 and the CRC32C of the body are stored
+// in metadata. S3 accepts a single checksum per request; the CRC32C is sent,
+// since the SDK can also compute it for each part of a multipart upload.
+// Single-part uploads send the full-object value, which S3 verifies and
+// rejects the upload on mismatch; multipart uploads (body larger than
+// partSize) are checked part by part. A partSize of 0 means the body is
+// always sent in a single PutObject call.
+func (repo *repoUpload) putObjectInput(key string, body io.Reader, attach utils.Upload, data []byte, partSize int64) *s3.PutObjectInput {
+	checksums := utils.ComputeChecksums(data)
+
+	metadata := make(map[string]string, len(attach.Metadata)+2)
+	for k, v := range attach.Metadata {
+		metadata[k] = v
+	}
+	metadata[utils.MetaChecksumSHA256] = checksums.SHA256
+	metadata[utils.MetaChecksumCRC32C] = checksums.CRC32C
+
+	input := &s3.PutObjectInput{
+		Bucket:            aws.String(repo.bucketName),
+		Key:               aws.String(key),
+		Body:              body,
+		ContentLength:     aws.Int64(attach.Length),
+		ContentType:       aws.String(attach.ContentType),
+		Metadata:          metadata,
+		ChecksumAlgorithm: types.ChecksumAlgorithmCrc32c,
+	}
+	if partSize == 0 || int64(len(data)) < partSize {
+		input.ChecksumCRC32C = aws.String(checksums.CRC32C)
+	}
+	if len(attach.Tags) > 0 {
+		input.Tagging = aws.String(encodeTags(attach.Tags))
+	}
+	repo.encryptPut(input)
+
+	return input
+}
+
+func (repo *repoUpload) UpdateFile(ctx context.Context, oldKey string, file io.Reader, newKey string, attach utils.Upload, largeObject []byte) error {
+	_, err := repo.headObject(ctx, repo.headInput(repo.tenantKey(ctx, oldKey)))
 	if err != nil {
 		var notFound *types.NotFound
 		if errors.As(err, &notFound) {
 			// utils.ErrorResp(ctx, http.StatusNotFound, "file not found")
-			return fmt.Errorf("file %s not found", oldKey)
+			return fmt.Errorf("file %s %w", oldKey, utils.ErrNotFound)
 			// return nil
 		}
 		return fmt.Errorf("error checking file existence: %v", err)
@@ -103,21 +154,19 @@ func (repo *repoUpload) UpdateFile(ctx *gin.Context, oldKey string, file io.Read
 	largeBuffer := bytes.NewReader(largeObject)
 	var partMiBs int64 = 10
 
-	key := attach.Prefix + newKey
+	key := newKey
 
 	uploader := manager.NewUploader(repo.s3Client, func(u *manager.Uploader) {
 		u.PartSize = partMiBs * 1024 * 1024
 	})
 
-	_, err = uploader.Upload(ctx, &s3.PutObjectInput{
-		Bucket:        aws.String(repo.bucketName),
-		Key:           aws.String(key),
-		Body:          largeBuffer,
-		ContentLength: aws.Int64(attach.Length),
-		ContentType:   aws.String(attach.ContentType),
-	})
+	_, err = uploader.Upload(ctx, repo.putObjectInput(repo.tenantKey(ctx, key), largeBuffer, attach, largeObject, partMiBs*1024*1024))
+	repo.metadata.forget(repo.tenantKey(ctx, key))
 
 	if err != nil {
+		if tooLarge := metadataTooLarge(err); tooLarge != nil {
+			return tooLarge
+		}
 		var smithyErr *smithy.GenericAPIError
 		if errors.As(err, &smithyErr) {
 			log.Printf("Upload error occurred: %v\n", smithyErr.Error())
@@ -130,33 +179,26 @@ func (repo *repoUpload) UpdateFile(ctx *gin.Context, oldKey string, file io.Read
 	return nil
 }
 
-func (repo *repoUpload) DeleteFile(ctx *gin.Context, key string) error {
-	_, err := repo.s3Client.HeadObject(ctx, &s3.HeadObjectInput{
-		Bucket: aws.String(repo.bucketName),
-		Key:    aws.String(key),
-	})
+func (repo *repoUpload) DeleteFile(ctx context.Context, key string) error {
+	_, err := repo.headObject(ctx, repo.headInput(repo.tenantKey(ctx, key)))
 	if err != nil {
 		var notFound *types.NotFound
 		if errors.As(err, &notFound) {
-			return fmt.Errorf("file %s not found", key)
+			return fmt.Errorf("file %s %w", key, utils.ErrNotFound)
 		}
 		return fmt.Errorf("error checking file existence: %v", err)
 	}
 
 	_, err = repo.s3Client.DeleteObject(ctx, &s3.DeleteObjectInput{
 		Bucket: aws.String(repo.bucketName),
-		Key:    aws.String(key),
+		Key:    aws.String(repo.tenantKey(ctx, key)),
 	})
+	repo.metadata.forget(repo.tenantKey(ctx, key))
 	if err != nil {
 		return fmt.Errorf("error deleting file: %v", err)
 	}
 
-	err = s3.NewObjectNotExistsWaiter(repo.s3Client).Wait(ctx,
-		&s3.HeadObjectInput{
-			Bucket: aws.String(repo.bucketName),
-			Key:    aws.String(key),
-		},
-		repo.timeout)
+	err = s3.NewObjectNotExistsWaiter(repo.s3Client).Wait(ctx, repo.headInput(repo.tenantKey(ctx, key)), repo.timeout)
 	if err != nil {
 		return fmt.Errorf("error waiting for file deletion: %v", err)
 	}
@@ -164,11 +206,13 @@ func (repo *repoUpload) DeleteFile(ctx *gin.Context, key string) error {
 	return nil
 }
 
-func (repo *repoUpload) ListObjects(ctx *gin.Context) ([]model.FileModel, error) {
+func (repo *repoUpload) ListObjects(ctx context.Context) ([]model.FileModel, error) {
 	var err error
 	var output *s3.ListObjectsV2Output
+	prefix := repo.tenantKey(ctx, "")
 	input := &s3.ListObjectsV2Input{
 		Bucket: aws.String(repo.bucketName),
+		Prefix: aws.String(prefix),
 	}
 
 	var objects []model.FileModel
@@ -184,18 +228,33 @@ func (repo *repoUpload) ListObjects(ctx *gin.Context) ([]model.FileModel, error)
 			break
 		} else {
 			for _, item := range output.Contents {
-				url, _ := repo.PreviewFile(ctx, *item.Key)
-
-				title := *item.Key
-				if idx := strings.LastIndex(title, "_"); idx != -1 {
-					title = title[:idx]
+				key := strings.TrimPrefix(*item.Key, prefix)
+				if utils.IsReservedKey(key) {
+					continue
 				}
 
 				fileModel := model.FileModel{
-					Key:   *item.Key,
-					Title: title,
-					Url:   url,
+					Key:   key,
+					Title: titleFromKey(key),
+					Size:  aws.ToInt64(item.Size),
+				}
+				previewKey := key
+
+				metadata, err := repo.listedMetadata(ctx, key, item)
+				if err != nil {
+					log.Printf("Couldn't get metadata for object %v:%v. Here's why: %v\n",
+						repo.bucketName, *item.Key, err)
+				} else {
+					applyMetadata(&fileModel, metadata)
+					if contentRef := metadata[utils.MetaContentRef]; contentRef != "" {
+						previewKey = contentRef
+					}
+				}
+
+				if !fileModel.Encrypted && repo.presignable() {
+					fileModel.Url, _ = repo.PreviewFile(ctx, previewKey)
 				}
+
 				objects = append(objects, fileModel)
 			}
 		}
@@ -203,11 +262,138 @@ func (repo *repoUpload) ListObjects(ctx *gin.Context) ([]model.FileModel, error)
 	return objects, err
 }
 
-func (repo *repoUpload) PreviewFile(ctx *gin.Context, objectKey string) (string, error) {
-	presignResult, err := repo.s3PresignedClient.PresignGetObject(ctx, &s3.GetObjectInput{
+// listedMetadata returns the metadata of a listed object, from the cache
+// when the object did not change since it was last listed.
+func (repo *repoUpload) listedMetadata(ctx context.Context, objectKey string, item types.Object) (map[string]string, error) {
+	etag, lastModified := aws.ToString(item.ETag), aws.ToTime(item.LastModified)
+	if metadata, ok := repo.metadata.get(*item.Key, etag, lastModified); ok {
+		return metadata, nil
+	}
+
+	metadata, err := repo.HeadMetadata(ctx, objectKey)
+	if err != nil {
+		return nil, err
+	}
+	repo.metadata.add(*item.Key, etag, lastModified, metadata)
+
+	return metadata, nil
+}
+
+func (repo *repoUpload) HeadMetadata(ctx context.Context, objectKey string) (map[string]string, error) {
+	output, err := repo.headObject(ctx, repo.headInput(repo.tenantKey(ctx, objectKey)))
+	if err != nil {
+		var notFound *types.NotFound
+		if errors.As(err, &notFound) {
+			return nil, fmt.Errorf("file %s %w", objectKey, utils.ErrNotFound)
+		}
+		return nil, fmt.Errorf("error getting file metadata: %v", err)
+	}
+
+	return output.Metadata, nil
+}
+
+func (repo *repoUpload) GetObject(ctx context.Context, objectKey string) (*model.ObjectStream, error) {
+	input := repo.getInput(repo.tenantKey(ctx, objectKey))
+	input.ChecksumMode = types.ChecksumModeEnabled
+
+	output, err := repo.getObject(ctx, input)
+	if err != nil {
+		var noKey *types.NoSuchKey
+		if errors.As(err, &noKey) {
+			return nil, fmt.Errorf("file %s %w", objectKey, utils.ErrNotFound)
+		}
+		return nil, fmt.Errorf("error getting file: %v", err)
+	}
+
+	return &model.ObjectStream{
+		Body:          output.Body,
+		ContentType:   aws.ToString(output.ContentType),
+		ContentLength: aws.ToInt64(output.ContentLength),
+		Metadata:      output.Metadata,
+		ETag:          strings.Trim(aws.ToString(output.ETag), `"`),
+	}, nil
+}
+
+// PutObject stores a small object in a single request. It is used for
+// service-managed objects such as dedup pointers and reference markers.
+func (repo *repoUpload) PutObject(ctx context.Context, objectKey string, body []byte, contentType string, metadata map[string]string) error {
+	attach := utils.Upload{
+		Length:      int64(len(body)),
+		ContentType: contentType,
+		Metadata:    metadata,
+	}
+
+	_, err := repo.s3Client.PutObject(ctx, repo.putObjectInput(repo.tenantKey(ctx, objectKey), bytes.NewReader(body), attach, body, 0))
+	repo.metadata.forget(repo.tenantKey(ctx, objectKey))
+	if err != nil {
+		if tooLarge := metadataTooLarge(err); tooLarge != nil {
+			return tooLarge
+		}
+		log.Printf("Couldn't put object %v:%v. Here's why: %v\n",
+			repo.bucketName, objectKey, err)
+		return err
+	}
+
+	return nil
+}
+
+// ListKeys returns the keys under prefix, relative to the tenant of ctx.
+func (repo *repoUpload) ListKeys(ctx context.Context, prefix string) ([]string, error) {
+	tenantPrefix := repo.tenantKey(ctx, "")
+	input := &s3.ListObjectsV2Input{
 		Bucket: aws.String(repo.bucketName),
-		Key:    aws.String(objectKey),
-	}, func(opts *s3.PresignOptions) {
+		Prefix: aws.String(tenantPrefix + prefix),
+	}
+
+	keys := []string{}
+	objectPaginator := s3.NewListObjectsV2Paginator(repo.s3Client, input)
+	for objectPaginator.HasMorePages() {
+		output, err := objectPaginator.NextPage(ctx)
+		if err != nil {
+			return nil, fmt.Errorf("error listing keys: %v", err)
+		}
+		for _, item := range output.Contents {
+			keys = append(keys, strings.TrimPrefix(*item.Key, tenantPrefix))
+		}
+	}
+
+	return keys, nil
+}
+
+// ListTenants returns the tenants that have stored at least one object.
+func (repo *repoUpload) ListTenants(ctx context.Context) ([]string, error) {
+	input := &s3.ListObjectsV2Input{
+		Bucket:    aws.String(repo.bucketName),
+		Prefix:    aws.String(utils.TenantsPrefix),
+		Delimiter: aws.String("/"),
+	}
+
+	tenants := []string{}
+	objectPaginator := s3.NewListObjectsV2Paginator(repo.s3Client, input)
+	for objectPaginator.HasMorePages() {
+		output, err := objectPaginator.NextPage(ctx)
+		if err != nil {
+			return nil, fmt.Errorf("error listing tenants: %v", err)
+		}
+		for _, commonPrefix := range output.CommonPrefixes {
+			tenant := strings.TrimSuffix(strings.TrimPrefix(aws.ToString(commonPrefix.Prefix), utils.TenantsPrefix), "/")
+			if tenant != "" {
+				tenants = append(tenants, tenant)
+			}
+		}
+	}
+
+	return tenants, nil
+}
+
+// PreviewFile returns a presigned URL of objectKey. It fails with
+// utils.ErrEncryptedObject under sse-c, see presignable.
+func (repo *repoUpload) PreviewFile(ctx context.Context, objectKey string) (string, error) {
+	if !repo.presignable() {
+		return "", utils.ErrEncryptedObject
+	}
+
+	presignResult, err := repo.s3PresignedClient.PresignGetObject(ctx, repo.getInput(repo.tenantKey(ctx, objectKey)), func(opts *s3.PresignOptions) {
 		opts.Expires = repo.timeout
 	})
 	if err != nil {
@@ -219,17 +405,46 @@ func (repo *repoUpload) PreviewFile(ctx *gin.Context, objectKey string) (string,
 	return presignResult.URL, nil
 }
 
-func (repo *repoUpload) CopyObject(ctx *gin.Context, objectRequest *model.CopyObjectRequest) error {
+func (repo *repoUpload) CopyObject(ctx context.Context, objectRequest *model.CopyObjectRequest) error {
 	input := &s3.CopyObjectInput{
 		Bucket:     aws.String(repo.bucketName),
-		CopySource: aws.String(repo.bucketName + "/" + objectRequest.OldKey),
-		Key:        aws.String(objectRequest.NewKey),
+		CopySource: aws.String((&url.URL{Path: repo.bucketName + "/" + repo.tenantKey(ctx, objectRequest.OldKey)}).EscapedPath()),
+		Key:        aws.String(repo.tenantKey(ctx, objectRequest.NewKey)),
+	}
+	if objectRequest.Metadata != nil {
+		input.MetadataDirective = types.MetadataDirectiveReplace
+		input.Metadata = objectRequest.Metadata
+		input.ContentType = aws.String(objectRequest.ContentType)
+		if objectRequest.CacheControl != "" {
+			input.CacheControl = aws.String(objectRequest.CacheControl)
+		}
+		if objectRequest.ContentDisposition != "" {
+			input.ContentDisposition = aws.String(objectRequest.ContentDisposition)
+		}
+	}
+	if objectRequest.Tags != nil {
+		input.TaggingDirective = types.TaggingDirectiveReplace
+		input.Tagging = aws.String(encodeTags(objectRequest.Tags))
 	}
+	if objectRequest.IfMatch != "" {
+		input.CopySourceIfMatch = aws.String(`"` + objectRequest.IfMatch + `"`)
+	}
+	repo.encryptCopy(input)
 
-	_, err := repo.s3Client.CopyObject(ctx, input)
+	_, err := repo.copyObject(ctx, input)
+	repo.metadata.forget(aws.ToString(input.Key))
 	if err != nil {
+		if preconditionFailed(err) {
+			return fmt.Errorf("file %s: %w", objectRequest.OldKey, utils.ErrPreconditionFailed)
+		}
+		if tooLarge := metadataTooLarge(err); tooLarge != nil {
+			return tooLarge
+		}
 		var apiErr smithy.APIError
 		if errors.As(err, &apiErr) {
+			if apiErr.ErrorCode() == "NoSuchKey" {
+				return fmt.Errorf("file %s %w", objectRequest.OldKey, utils.ErrNotFound)
+			}
 			log.Printf("error copying object, code: %s, message: %s", apiErr.ErrorCode(), apiErr.ErrorMessage())
 			return fmt.Errorf("failed to copy object: %s", apiErr.ErrorMessage())
 		}
@@ -238,3 +453,286 @@ func (repo *repoUpload) CopyObject(ctx *gin.Context, objectRequest *model.CopyOb
 
 	return nil
 }
+
+// StatObject returns the details of objectKey as stored, without resolving
+// dedup pointers.
+func (repo *repoUpload) StatObject(ctx context.Context, objectKey string) (*model.FileDetails, error) {
+	input := repo.headInput(repo.tenantKey(ctx, objectKey))
+	input.ChecksumMode = types.ChecksumModeEnabled
+
+	output, err := repo.headObject(ctx, input)
+	if err != nil {
+		var notFound *types.NotFound
+		if errors.As(err, &notFound) {
+			return nil, fmt.Errorf("file %s %w", objectKey, utils.ErrNotFound)
+		}
+		return nil, fmt.Errorf("error getting file details: %v", err)
+	}
+
+	details := &model.FileDetails{
+		FileModel: model.FileModel{
+			Key:   objectKey,
+			Title: titleFromKey(objectKey),
+			Size:  aws.ToInt64(output.ContentLength),
+		},
+		ContentType:        aws.ToString(output.ContentType),
+		ETag:               strings.Trim(aws.ToString(output.ETag), `"`),
+		LastModified:       aws.ToTime(output.LastModified),
+		ChecksumSHA256:     output.Metadata[utils.MetaChecksumSHA256],
+		ChecksumCRC32C:     output.Metadata[utils.MetaChecksumCRC32C],
+		CacheControl:       aws.ToString(output.CacheControl),
+		ContentDisposition: aws.ToString(output.ContentDisposition),
+		Encryption:         encryptionStatus(output),
+		RawMetadata:        output.Metadata,
+	}
+	applyMetadata(&details.FileModel, output.Metadata)
+
+	return details, nil
+}
+
+// GetFileModel returns objectKey as it appears in listings, with a presigned
+// URL of its content unless it is envelope encrypted or stored under sse-c.
+func (repo *repoUpload) GetFileModel(ctx context.Context, objectKey string) (*model.FileModel, error) {
+	details, err := repo.StatObject(ctx, objectKey)
+	if err != nil {
+		return nil, err
+	}
+
+	fileModel := details.FileModel
+	if fileModel.Encrypted || !repo.presignable() {
+		return &fileModel, nil
+	}
+
+	previewKey := objectKey
+	if contentRef := details.RawMetadata[utils.MetaContentRef]; contentRef != "" {
+		previewKey = contentRef
+	}
+
+	fileModel.Url, err = repo.PreviewFile(ctx, previewKey)
+	if err != nil {
+		return nil, err
+	}
+
+	return &fileModel, nil
+}
+
+// GetJSON decodes a service-managed JSON document into v.
+func (repo *repoUpload) GetJSON(ctx context.Context, objectKey string, v any) error {
+	object, err := repo.GetObject(ctx, objectKey)
+	if err != nil {
+		return err
+	}
+	defer object.Body.Close()
+
+	err = json.NewDecoder(object.Body).Decode(v)
+	if err != nil {
+		return fmt.Errorf("error decoding %s: %v", objectKey, err)
+	}
+
+	return nil
+}
+
+// PutJSON stores v as a service-managed JSON document.
+func (repo *repoUpload) PutJSON(ctx context.Context, objectKey string, v any) error {
+	body, err := json.Marshal(v)
+	if err != nil {
+		return fmt.Errorf("error encoding %s: %v", objectKey, err)
+	}
+
+	return repo.PutObject(ctx, objectKey, body, "application/json", nil)
+}
+
+// GetJSONWithETag decodes a service-managed JSON document into v and returns
+// its ETag, for a later PutJSONIfMatch.
+func (repo *repoUpload) GetJSONWithETag(ctx context.Context, objectKey string, v any) (string, error) {
+	object, err := repo.GetObject(ctx, objectKey)
+	if err != nil {
+		return "", err
+	}
+	defer object.Body.Close()
+
+	err = json.NewDecoder(object.Body).Decode(v)
+	if err != nil {
+		return "", fmt.Errorf("error decoding %s: %v", objectKey, err)
+	}
+
+	return object.ETag, nil
+}
+
+// PutJSONIfMatch stores v only if the document still has etag, or does not
+// exist yet when etag is empty, and returns the ETag of the new version.
+// Otherwise it fails with utils.ErrPreconditionFailed.
+func (repo *repoUpload) PutJSONIfMatch(ctx context.Context, objectKey string, v any, etag string) (string, error) {
+	body, err := json.Marshal(v)
+	if err != nil {
+		return "", fmt.Errorf("error encoding %s: %v", objectKey, err)
+	}
+
+	attach := utils.Upload{
+		Length:      int64(len(body)),
+		ContentType: "application/json",
+	}
+	input := repo.putObjectInput(repo.tenantKey(ctx, objectKey), bytes.NewReader(body), attach, body, 0)
+	if etag == "" {
+		input.IfNoneMatch = aws.String("*")
+	} else {
+		input.IfMatch = aws.String(`"` + etag + `"`)
+	}
+
+	output, err := repo.s3Client.PutObject(ctx, input)
+	if err != nil {
+		if preconditionFailed(err) {
+			return "", fmt.Errorf("file %s: %w", objectKey, utils.ErrPreconditionFailed)
+		}
+		return "", fmt.Errorf("error putting file %s: %v", objectKey, err)
+	}
+
+	return strings.Trim(aws.ToString(output.ETag), `"`), nil
+}
+
+// DeleteFileIfMatch deletes a service-managed document only if it still has
+// etag. Otherwise it fails with utils.ErrPreconditionFailed, or with
+// utils.ErrNotFound when the document is already gone.
+func (repo *repoUpload) DeleteFileIfMatch(ctx context.Context, objectKey string, etag string) error {
+	_, err := repo.s3Client.DeleteObject(ctx, &s3.DeleteObjectInput{
+		Bucket:  aws.String(repo.bucketName),
+		Key:     aws.String(repo.tenantKey(ctx, objectKey)),
+		IfMatch: aws.String(`"` + etag + `"`),
+	})
+	repo.metadata.forget(repo.tenantKey(ctx, objectKey))
+	if err != nil {
+		if preconditionFailed(err) {
+			return fmt.Errorf("file %s: %w", objectKey, utils.ErrPreconditionFailed)
+		}
+		return repo.objectError("deleting", objectKey, err)
+	}
+
+	return nil
+}
+
+func (repo *repoUpload) GetTags(ctx context.Context, objectKey string) (map[string]string, error) {
+	output, err := repo.s3Client.GetObjectTagging(ctx, &s3.GetObjectTaggingInput{
+		Bucket: aws.String(repo.bucketName),
+		Key:    aws.String(repo.tenantKey(ctx, objectKey)),
+	})
+	if err != nil {
+		return nil, repo.objectError("getting tags of", objectKey, err)
+	}
+
+	tags := make(map[string]string, len(output.TagSet))
+	for _, tag := range output.TagSet {
+		tags[aws.ToString(tag.Key)] = aws.ToString(tag.Value)
+	}
+
+	return tags, nil
+}
+
+func (repo *repoUpload) PutTags(ctx context.Context, objectKey string, tags map[string]string) error {
+	tagSet := make([]types.Tag, 0, len(tags))
+	for k, v := range tags {
+		tagSet = append(tagSet, types.Tag{Key: aws.String(k), Value: aws.String(v)})
+	}
+
+	_, err := repo.s3Client.PutObjectTagging(ctx, &s3.PutObjectTaggingInput{
+		Bucket:  aws.String(repo.bucketName),
+		Key:     aws.String(repo.tenantKey(ctx, objectKey)),
+		Tagging: &types.Tagging{TagSet: tagSet},
+	})
+	if err != nil {
+		return repo.objectError("setting tags of", objectKey, err)
+	}
+
+	return nil
+}
+
+func (repo *repoUpload) DeleteTags(ctx context.Context, objectKey string) error {
+	_, err := repo.s3Client.DeleteObjectTagging(ctx, &s3.DeleteObjectTaggingInput{
+		Bucket: aws.String(repo.bucketName),
+		Key:    aws.String(repo.tenantKey(ctx, objectKey)),
+	})
+	if err != nil {
+		return repo.objectError("deleting tags of", objectKey, err)
+	}
+
+	return nil
+}
+
+// tenantKey returns the bucket key of objectKey for the tenant of ctx.
+// Requests without a tenant use the bucket root.
+func (repo *repoUpload) tenantKey(ctx context.Context, objectKey string) string {
+	return utils.TenantKey(model.TenantFromContext(ctx), objectKey)
+}
+
+// objectError maps S3 "no such key" errors to utils.ErrNotFound.
+func (repo *repoUpload) objectError(action string, objectKey string, err error) error {
+	var noKey *types.NoSuchKey
+	var apiErr smithy.APIError
+	if errors.As(err, &noKey) || (errors.As(err, &apiErr) && apiErr.ErrorCode() == "NoSuchKey") {
+		return fmt.Errorf("file %s %w", objectKey, utils.ErrNotFound)
+	}
+	return fmt.Errorf("error %s file: %v", action, err)
+}
+
+// preconditionFailed reports whether err is S3 refusing a conditional
+// request: the condition did not hold, or a concurrent conditional write won.
+func preconditionFailed(err error) bool {
+	var apiErr smithy.APIError
+	if !errors.As(err, &apiErr) {
+		return false
+	}
+	return apiErr.ErrorCode() == "PreconditionFailed" || apiErr.ErrorCode() == "ConditionalRequestConflict"
+}
+
+// metadataTooLarge returns utils.ErrInvalidMetadata when S3 rejected a
+// write because the object metadata exceeds its 2 KB limit, so that it is
+// reported as a client error, and nil otherwise.
+func metadataTooLarge(err error) error {
+	var apiErr smithy.APIError
+	if errors.As(err, &apiErr) && apiErr.ErrorCode() == "MetadataTooLarge" {
+		return fmt.Errorf("%w: metadata is larger than %d bytes", utils.ErrInvalidMetadata, utils.MaxMetadataSize)
+	}
+	return nil
+}
+
+func titleFromKey(key string) string {
+	title := key
+	if idx := strings.LastIndex(title, "_"); idx != -1 {
+		title = title[:idx]
+	}
+	return title
+}
+
+// applyMetadata copies the service-managed object metadata into fileModel.
+func applyMetadata(fileModel *model.FileModel, metadata map[string]string) {
+	if title := metadata[utils.MetaTitle]; title != "" {
+		fileModel.Title = utils.DecodeMetadataValue(title)
+	}
+	fileModel.BlurHash = metadata[utils.MetaBlurHash]
+	fileModel.DominantColor = metadata[utils.MetaDominantColor]
+	fileModel.AverageColor = metadata[utils.MetaAverageColor]
+	fileModel.PHash = metadata[utils.MetaPHash]
+	fileModel.UploadedBy = utils.DecodeMetadataValue(metadata[utils.MetaUploadedBy])
+	fileModel.Owner = utils.DecodeMetadataValue(metadata[utils.MetaOwner])
+	fileModel.OriginalSize, _ = strconv.ParseInt(metadata[utils.MetaOriginalSize], 10, 64)
+	if metadata[utils.MetaContentRef] != "" {
+		fileModel.Size, _ = strconv.ParseInt(metadata[utils.MetaContentSize], 10, 64)
+	}
+	if metadata[utils.MetaEnvelopeKey] != "" {
+		fileModel.Encrypted = true
+		fileModel.KeyVersion = metadata[utils.MetaEnvelopeKeyVersion]
+		fileModel.Size, _ = strconv.ParseInt(metadata[utils.MetaEnvelopeSize], 10, 64)
+	}
+	fileModel.Metadata = utils.CustomMetadata(metadata)
+	fileModel.AltText, fileModel.AltTexts = utils.AccessibleText(metadata, utils.MetaAltText)
+	fileModel.Caption, fileModel.Captions = utils.AccessibleText(metadata, utils.MetaCaption)
+}
+
+// encodeTags formats tags as the URL query string S3 expects in the
+// x-amz-tagging header.
+func encodeTags(tags map[string]string) string {
+	values := url.Values{}
+	for k, v := range tags {
+		values.Set(k, v)
+	}
+	return values.Encode()
+}
@@ -0,0 +1,349 @@
+package usecase
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+	"unicode/utf8"
+
+	"github.com/adityaw24/go-aws-garasi/internal/model"
+	"github.com/adityaw24/go-aws-garasi/internal/repo"
+	"github.com/adityaw24/go-aws-garasi/utils"
+	"github.com/google/uuid"
+)
+
+const (
+	apiKeySecretBytes   = 32
+	maxAPIKeyNameLength = 100
+	// lastUsedInterval limits how often the last use of a key is written.
+	lastUsedInterval = time.Minute
+	// apiKeyCacheTTL bounds how long a key document, or the absence of one,
+	// is served from memory. A revocation takes effect at once on the
+	// instance that revoked the key, and within this delay on the others.
+	apiKeyCacheTTL = 30 * time.Second
+	// maxCachedAPIKeys bounds the cache, which unknown key IDs also fill.
+	maxCachedAPIKeys = 10000
+)
+
+type UsecaseAPIKey interface {
+	CreateAPIKey(ctx context.Context, apiKeyRequest *model.APIKeyRequest) (*model.CreatedAPIKey, error)
+	ListAPIKeys(ctx context.Context) ([]model.APIKey, error)
+	RevokeAPIKey(ctx context.Context, keyID string) (*model.APIKey, error)
+	ValidateAPIKey(ctx context.Context, key string) (*model.Identity, error)
+}
+
+// API key documents are shared by all tenants: they are read before the
+// tenant of a request is known. Each key records the tenant it belongs to,
+// and callers only see the keys of their own tenant. Since every request
+// with a key needs its document, documents are cached for apiKeyCacheTTL,
+// and so are the IDs of unknown keys.
+
+type usecaseAPIKey struct {
+	repo repo.RepoUpload
+	// mu guards cache.
+	mu    sync.Mutex
+	cache map[string]cachedAPIKey
+}
+
+// cachedAPIKey is a key document read at most apiKeyCacheTTL ago, or nil
+// when there is no key with its ID.
+type cachedAPIKey struct {
+	apiKey    *model.APIKey
+	expiresAt time.Time
+}
+
+func NewUsecaseAPIKey(repo repo.RepoUpload) UsecaseAPIKey {
+	return &usecaseAPIKey{
+		repo:  repo,
+		cache: map[string]cachedAPIKey{},
+	}
+}
+
+func (u *usecaseAPIKey) CreateAPIKey(ctx context.Context, apiKeyRequest *model.APIKeyRequest) (*model.CreatedAPIKey, error) {
+	name := strings.TrimSpace(apiKeyRequest.Name)
+	if name == "" || utf8.RuneCountInString(name) > maxAPIKeyNameLength {
+		err := fmt.Errorf("%w: name must be 1 to %d characters", utils.ErrInvalidAPIKeyRequest, maxAPIKeyNameLength)
+		utils.ErrorLog("usecase", "CreateAPIKey", err)
+		return nil, err
+	}
+
+	if len(apiKeyRequest.Scopes) == 0 {
+		err := fmt.Errorf("%w: at least one scope is required", utils.ErrInvalidAPIKeyRequest)
+		utils.ErrorLog("usecase", "CreateAPIKey", err)
+		return nil, err
+	}
+	for _, scope := range apiKeyRequest.Scopes {
+		if !slices.Contains(model.APIKeyScopes, scope) {
+			err := fmt.Errorf("%w: unknown scope %q, expected one of %s", utils.ErrInvalidAPIKeyRequest, scope, strings.Join(model.APIKeyScopes, ", "))
+			utils.ErrorLog("usecase", "CreateAPIKey", err)
+			return nil, err
+		}
+	}
+
+	secretBytes := make([]byte, apiKeySecretBytes)
+	_, err := rand.Read(secretBytes)
+	if err != nil {
+		utils.ErrorLog("usecase", "CreateAPIKey rand.Read", err)
+		return nil, err
+	}
+	secret := base64.RawURLEncoding.EncodeToString(secretBytes)
+
+	scopes := slices.Clone(apiKeyRequest.Scopes)
+	slices.Sort(scopes)
+
+	apiKey := model.APIKey{
+		ID:        uuid.New().String(),
+		Name:      name,
+		Hash:      hashAPIKeySecret(secret),
+		Scopes:    slices.Compact(scopes),
+		CreatedAt: time.Now().UTC(),
+	}
+	if identity := model.IdentityFromContext(ctx); identity != nil {
+		apiKey.CreatedBy = identity.Subject
+	}
+	apiKey.Tenant = model.TenantFromContext(ctx)
+
+	err = u.repo.PutJSON(apiKeysContext(ctx), apiKeyKey(apiKey.ID), &apiKey)
+	if err != nil {
+		utils.ErrorLog("usecase", "CreateAPIKey Repository PutJSON", err)
+		return nil, err
+	}
+
+	apiKey.Hash = ""
+	return &model.CreatedAPIKey{
+		APIKey: apiKey,
+		Key:    utils.APIKeyPrefix + apiKey.ID + "_" + secret,
+	}, nil
+}
+
+func (u *usecaseAPIKey) ListAPIKeys(ctx context.Context) ([]model.APIKey, error) {
+	tenant := model.TenantFromContext(ctx)
+	ctx = apiKeysContext(ctx)
+
+	documents, err := u.repo.ListKeys(ctx, utils.APIKeysPrefix)
+	if err != nil {
+		utils.ErrorLog("usecase", "ListAPIKeys Repository ListKeys", err)
+		return nil, err
+	}
+
+	apiKeys := []model.APIKey{}
+	for _, document := range documents {
+		var apiKey model.APIKey
+		err = u.repo.GetJSON(ctx, document, &apiKey)
+		if err != nil {
+			utils.ErrorLog("usecase", "ListAPIKeys Repository GetJSON", err)
+			continue
+		}
+		if apiKey.Tenant != tenant {
+			continue
+		}
+		apiKey.Hash = ""
+		apiKeys = append(apiKeys, apiKey)
+	}
+
+	slices.SortFunc(apiKeys, func(a, b model.APIKey) int {
+		return a.CreatedAt.Compare(b.CreatedAt)
+	})
+
+	return apiKeys, nil
+}
+
+// RevokeAPIKey disables a key permanently. The record is kept for auditing.
+func (u *usecaseAPIKey) RevokeAPIKey(ctx context.Context, keyID string) (*model.APIKey, error) {
+	tenant := model.TenantFromContext(ctx)
+
+	apiKey, err := u.updateAPIKey(ctx, keyID, func(apiKey *model.APIKey) (documentUpdate, error) {
+		if apiKey.Tenant != tenant {
+			return documentUnchanged, fmt.Errorf("api key %s %w", keyID, utils.ErrNotFound)
+		}
+		if apiKey.RevokedAt != nil {
+			return documentUnchanged, nil
+		}
+
+		now := time.Now().UTC()
+		apiKey.RevokedAt = &now
+		return documentPut, nil
+	})
+	if err != nil {
+		utils.ErrorLog("usecase", "RevokeAPIKey updateAPIKey", err)
+		return nil, err
+	}
+	u.forgetAPIKey(keyID)
+
+	apiKey.Hash = ""
+	return apiKey, nil
+}
+
+// ValidateAPIKey returns the identity of a valid, unrevoked key and records
+// its use.
+func (u *usecaseAPIKey) ValidateAPIKey(ctx context.Context, key string) (*model.Identity, error) {
+	keyID, secret, ok := utils.ParseAPIKey(key)
+	if !ok {
+		return nil, fmt.Errorf("%w: malformed key", utils.ErrInvalidAPIKey)
+	}
+
+	apiKey, err := u.cachedAPIKey(ctx, keyID)
+	if errors.Is(err, utils.ErrNotFound) {
+		return nil, fmt.Errorf("%w: unknown key", utils.ErrInvalidAPIKey)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	if subtle.ConstantTimeCompare([]byte(hashAPIKeySecret(secret)), []byte(apiKey.Hash)) != 1 {
+		return nil, fmt.Errorf("%w: unknown key", utils.ErrInvalidAPIKey)
+	}
+	if apiKey.RevokedAt != nil {
+		return nil, fmt.Errorf("%w: key was revoked", utils.ErrInvalidAPIKey)
+	}
+
+	if apiKey.LastUsedAt == nil || time.Since(*apiKey.LastUsedAt) >= lastUsedInterval {
+		// A failed bookkeeping write must not reject a valid key.
+		err = u.touchAPIKey(ctx, apiKey.ID)
+		if err != nil {
+			utils.ErrorLog("usecase", "ValidateAPIKey touchAPIKey", err)
+		}
+	}
+
+	return &model.Identity{
+		Subject: model.AuthMethodAPIKey + ":" + apiKey.ID,
+		Method:  model.AuthMethodAPIKey,
+		Scopes:  apiKey.Scopes,
+		Tenant:  apiKey.Tenant,
+	}, nil
+}
+
+// touchAPIKey records the last use of a key. The write is conditional, so
+// that a concurrent revocation is not overwritten.
+func (u *usecaseAPIKey) touchAPIKey(ctx context.Context, keyID string) error {
+	apiKey, err := u.updateAPIKey(ctx, keyID, func(apiKey *model.APIKey) (documentUpdate, error) {
+		now := time.Now().UTC()
+		apiKey.LastUsedAt = &now
+		return documentPut, nil
+	})
+	if err != nil {
+		return err
+	}
+
+	u.cacheAPIKey(keyID, apiKey)
+	return nil
+}
+
+// updateAPIKey applies change to the document of a key; see updateDocument.
+func (u *usecaseAPIKey) updateAPIKey(ctx context.Context, keyID string, change func(apiKey *model.APIKey) (documentUpdate, error)) (*model.APIKey, error) {
+	if _, err := uuid.Parse(keyID); err != nil {
+		return nil, fmt.Errorf("api key %s %w", keyID, utils.ErrNotFound)
+	}
+
+	return updateDocument(apiKeysContext(ctx), u.repo, apiKeyKey(keyID), func(apiKey *model.APIKey, exists bool) (documentUpdate, error) {
+		if !exists {
+			return documentUnchanged, fmt.Errorf("api key %s %w", keyID, utils.ErrNotFound)
+		}
+		return change(apiKey)
+	})
+}
+
+// cachedAPIKey returns the document of a key from the cache, or reads it
+// and caches it, or the fact that it does not exist.
+func (u *usecaseAPIKey) cachedAPIKey(ctx context.Context, keyID string) (*model.APIKey, error) {
+	u.mu.Lock()
+	cached, ok := u.cache[keyID]
+	u.mu.Unlock()
+	if ok && time.Now().Before(cached.expiresAt) {
+		if cached.apiKey == nil {
+			return nil, fmt.Errorf("api key %s %w", keyID, utils.ErrNotFound)
+		}
+		apiKey := *cached.apiKey
+		return &apiKey, nil
+	}
+
+	apiKey, err := u.getAPIKey(apiKeysContext(ctx), keyID)
+	if errors.Is(err, utils.ErrNotFound) {
+		u.cacheAPIKey(keyID, nil)
+		return nil, err
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	u.cacheAPIKey(keyID, apiKey)
+	return apiKey, nil
+}
+
+// cacheAPIKey caches the document of a key, nil when it does not exist. A
+// full cache first drops its expired entries, then an arbitrary one.
+func (u *usecaseAPIKey) cacheAPIKey(keyID string, apiKey *model.APIKey) {
+	u.mu.Lock()
+	defer u.mu.Unlock()
+
+	now := time.Now()
+	if _, ok := u.cache[keyID]; !ok && len(u.cache) >= maxCachedAPIKeys {
+		for id, cached := range u.cache {
+			if now.After(cached.expiresAt) {
+				delete(u.cache, id)
+			}
+		}
+		for id := range u.cache {
+			if len(u.cache) < maxCachedAPIKeys {
+				break
+			}
+			delete(u.cache, id)
+		}
+	}
+
+	if apiKey != nil {
+		copied := *apiKey
+		apiKey = &copied
+	}
+	u.cache[keyID] = cachedAPIKey{apiKey: apiKey, expiresAt: now.Add(apiKeyCacheTTL)}
+}
+
+func (u *usecaseAPIKey) forgetAPIKey(keyID string) {
+	u.mu.Lock()
+	defer u.mu.Unlock()
+
+	delete(u.cache, keyID)
+}
+
+func (u *usecaseAPIKey) getAPIKey(ctx context.Context, keyID string) (*model.APIKey, error) {
+	if _, err := uuid.Parse(keyID); err != nil {
+		return nil, fmt.Errorf("api key %s %w", keyID, utils.ErrNotFound)
+	}
+
+	var apiKey model.APIKey
+	err := u.repo.GetJSON(ctx, apiKeyKey(keyID), &apiKey)
+	if errors.Is(err, utils.ErrNotFound) {
+		return nil, fmt.Errorf("api key %s %w", keyID, utils.ErrNotFound)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	return &apiKey, nil
+}
+
+// hashAPIKeySecret hashes a secret for storage. Secrets are 256 bits of
+// randomness, so a fast hash is sufficient.
+func hashAPIKeySecret(secret string) string {
+	sum := sha256.Sum256([]byte(secret))
+	return hex.EncodeToString(sum[:])
+}
+
+// apiKeysContext scopes ctx to the bucket root, where API key documents are
+// stored regardless of the tenant of the request.
+func apiKeysContext(ctx context.Context) context.Context {
+	return model.WithTenant(ctx, "")
+}
+
+func apiKeyKey(keyID string) string {
+	return utils.APIKeysPrefix + keyID + ".json"
+}
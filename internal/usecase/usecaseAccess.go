@@ -0,0 +1,354 @@
+package usecase
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/url"
+	"slices"
+	"strings"
+	"time"
+	"unicode"
+	"unicode/utf8"
+
+	"github.com/adityaw24/go-aws-garasi/internal/model"
+	"github.com/adityaw24/go-aws-garasi/utils"
+)
+
+// Object access control: the caller that uploads an object becomes its
+// owner, recorded in the utils.MetaOwner metadata. The owner can share the
+// object with users and groups or make it public; those grants are kept in
+// a model.ObjectAccess document under utils.ACLsPrefix, which only exists
+// while something is shared. Objects without an owner, stored before
+// ownership was recorded, stay accessible to every caller. Unauthenticated
+// requests and admins are not restricted. Access documents are changed with
+// conditional writes, so that concurrent grants and revokes on different
+// instances are all kept.
+
+const maxPrincipalLength = 256
+
+func (u *usecaseUpload) GetAccess(ctx context.Context, objectKey string) (*model.ObjectAccess, error) {
+	metadata, err := u.authorizeKey(ctx, objectKey, model.PermissionOwner)
+	if err != nil {
+		utils.ErrorLog("usecase", "GetAccess authorizeKey", err)
+		return nil, err
+	}
+
+	access, err := u.getAccess(ctx, objectKey, metadata)
+	if err != nil {
+		utils.ErrorLog("usecase", "GetAccess getAccess", err)
+		return nil, err
+	}
+
+	return access, nil
+}
+
+func (u *usecaseUpload) GrantAccess(ctx context.Context, objectKey string, accessRequest *model.AccessRequest) (*model.ObjectAccess, error) {
+	permission := accessRequest.Permission
+	if permission == "" {
+		permission = model.PermissionRead
+	}
+	if !slices.Contains(model.GrantablePermissions, permission) {
+		err := fmt.Errorf("%w: unknown permission %q, expected one of %s", utils.ErrInvalidAccess, permission, strings.Join(model.GrantablePermissions, ", "))
+		utils.ErrorLog("usecase", "GrantAccess", err)
+		return nil, err
+	}
+
+	return u.updateAccess(ctx, "GrantAccess", objectKey, accessRequest, func(access *model.ObjectAccess, users []string, groups []string) {
+		for _, user := range users {
+			access.Users[user] = permission
+		}
+		for _, group := range groups {
+			access.Groups[group] = permission
+		}
+		if accessRequest.Public {
+			access.Public = true
+		}
+	})
+}
+
+func (u *usecaseUpload) RevokeAccess(ctx context.Context, objectKey string, accessRequest *model.AccessRequest) (*model.ObjectAccess, error) {
+	return u.updateAccess(ctx, "RevokeAccess", objectKey, accessRequest, func(access *model.ObjectAccess, users []string, groups []string) {
+		for _, user := range users {
+			delete(access.Users, user)
+		}
+		for _, group := range groups {
+			delete(access.Groups, group)
+		}
+		if accessRequest.Public {
+			access.Public = false
+		}
+	})
+}
+
+// updateAccess applies change to the access document of objectKey. The
+// document is removed when nothing is shared anymore.
+func (u *usecaseUpload) updateAccess(ctx context.Context, funcName string, objectKey string, accessRequest *model.AccessRequest, change func(access *model.ObjectAccess, users []string, groups []string)) (*model.ObjectAccess, error) {
+	users, err := normalizePrincipals(accessRequest.Users)
+	if err != nil {
+		utils.ErrorLog("usecase", funcName+" normalizePrincipals", err)
+		return nil, err
+	}
+	groups, err := normalizePrincipals(accessRequest.Groups)
+	if err != nil {
+		utils.ErrorLog("usecase", funcName+" normalizePrincipals", err)
+		return nil, err
+	}
+	if len(users) == 0 && len(groups) == 0 && !accessRequest.Public {
+		err = fmt.Errorf("%w: users, groups or public is required", utils.ErrInvalidAccess)
+		utils.ErrorLog("usecase", funcName, err)
+		return nil, err
+	}
+
+	metadata, err := u.authorizeKey(ctx, objectKey, model.PermissionOwner)
+	if err != nil {
+		utils.ErrorLog("usecase", funcName+" authorizeKey", err)
+		return nil, err
+	}
+
+	access, err := updateDocument(ctx, u.repo, accessKey(objectKey), func(access *model.ObjectAccess, exists bool) (documentUpdate, error) {
+		prepareAccess(access, objectKey, metadata)
+		change(access, users, groups)
+
+		if !access.Public && len(access.Users) == 0 && len(access.Groups) == 0 {
+			access.UpdatedAt = nil
+			return documentDelete, nil
+		}
+
+		now := time.Now().UTC()
+		access.UpdatedAt = &now
+		return documentPut, nil
+	})
+	if err != nil {
+		utils.ErrorLog("usecase", funcName+" updateDocument", err)
+		return nil, err
+	}
+
+	return access, nil
+}
+
+// AuthorizeObject checks that the caller holds permission on objectKey, for
+// usecases that keep their own documents about objects.
+func (u *usecaseUpload) AuthorizeObject(ctx context.Context, objectKey string, permission string) error {
+	_, err := u.authorizeKey(ctx, objectKey, permission)
+	return err
+}
+
+// FilterReadable keeps the objects the caller may read, for usecases that
+// list objects of their own.
+func (u *usecaseUpload) FilterReadable(ctx context.Context, objects []model.FileModel) ([]model.FileModel, error) {
+	return u.filterReadable(ctx, objects)
+}
+
+// authorizeKey reads the metadata of objectKey and checks that the caller
+// holds permission on it.
+func (u *usecaseUpload) authorizeKey(ctx context.Context, objectKey string, permission string) (map[string]string, error) {
+	metadata, err := u.repo.HeadMetadata(ctx, objectKey)
+	if err != nil {
+		return nil, err
+	}
+
+	err = u.authorize(ctx, objectKey, metadata, permission)
+	if err != nil {
+		return nil, err
+	}
+
+	return metadata, nil
+}
+
+// authorize checks that the caller holds permission on the object with the
+// given metadata. It fails with utils.ErrForbidden.
+func (u *usecaseUpload) authorize(ctx context.Context, objectKey string, metadata map[string]string, permission string) error {
+	identity := model.IdentityFromContext(ctx)
+	owner := utils.DecodeMetadataValue(metadata[utils.MetaOwner])
+	if unrestricted(identity, owner) {
+		return nil
+	}
+
+	if permission != model.PermissionOwner {
+		var access model.ObjectAccess
+		err := u.repo.GetJSON(ctx, accessKey(objectKey), &access)
+		if err != nil && !errors.Is(err, utils.ErrNotFound) {
+			return err
+		}
+		if err == nil && grants(identity, &access, permission) {
+			return nil
+		}
+	}
+
+	return fmt.Errorf("%w: %s does not have %s access to %s", utils.ErrForbidden, identity.Subject, permission, objectKey)
+}
+
+// filterReadable keeps the objects the caller may read. Access documents are
+// listed once so that only shared objects cost a read.
+func (u *usecaseUpload) filterReadable(ctx context.Context, objects []model.FileModel) ([]model.FileModel, error) {
+	identity := model.IdentityFromContext(ctx)
+	if identity == nil || identity.IsAdmin() {
+		return objects, nil
+	}
+
+	documents, err := u.repo.ListKeys(ctx, utils.ACLsPrefix)
+	if err != nil {
+		return nil, err
+	}
+
+	filtered := []model.FileModel{}
+	for _, object := range objects {
+		if unrestricted(identity, object.Owner) {
+			filtered = append(filtered, object)
+			continue
+		}
+		if !slices.Contains(documents, accessKey(object.Key)) {
+			continue
+		}
+
+		var access model.ObjectAccess
+		err = u.repo.GetJSON(ctx, accessKey(object.Key), &access)
+		if err != nil {
+			utils.ErrorLog("usecase", "filterReadable Repository GetJSON", err)
+			continue
+		}
+		if grants(identity, &access, model.PermissionRead) {
+			filtered = append(filtered, object)
+		}
+	}
+
+	return filtered, nil
+}
+
+// filterReadableDuplicates keeps the duplicates the caller may read.
+func (u *usecaseUpload) filterReadableDuplicates(ctx context.Context, duplicates []model.DuplicateModel) ([]model.DuplicateModel, error) {
+	objects := make([]model.FileModel, len(duplicates))
+	for i, duplicate := range duplicates {
+		objects[i] = duplicate.FileModel
+	}
+
+	readable, err := u.filterReadable(ctx, objects)
+	if err != nil {
+		return nil, err
+	}
+
+	filtered := make([]model.DuplicateModel, 0, len(readable))
+	for _, duplicate := range duplicates {
+		if slices.ContainsFunc(readable, func(object model.FileModel) bool {
+			return object.Key == duplicate.Key
+		}) {
+			filtered = append(filtered, duplicate)
+		}
+	}
+	return filtered, nil
+}
+
+// unrestricted reports whether identity may do anything with an object
+// owned by owner. Only an explicit admin scope bypasses ownership; callers
+// without scope restrictions are still bound by it.
+func unrestricted(identity *model.Identity, owner string) bool {
+	return identity == nil ||
+		owner == "" ||
+		owner == identity.Subject ||
+		identity.IsAdmin()
+}
+
+// grants reports whether access gives identity permission, directly, through
+// one of its groups or, for reading, because the object is public.
+func grants(identity *model.Identity, access *model.ObjectAccess, permission string) bool {
+	if permission == model.PermissionRead && access.Public {
+		return true
+	}
+
+	satisfies := func(granted string) bool {
+		return granted == permission || granted == model.PermissionWrite && permission == model.PermissionRead
+	}
+	if satisfies(access.Users[identity.Subject]) {
+		return true
+	}
+	for _, group := range identity.Groups {
+		if satisfies(access.Groups[group]) {
+			return true
+		}
+	}
+	return false
+}
+
+// getAccess returns the access document of objectKey, or an empty one when
+// the object is not shared.
+func (u *usecaseUpload) getAccess(ctx context.Context, objectKey string, metadata map[string]string) (*model.ObjectAccess, error) {
+	access := &model.ObjectAccess{}
+	err := u.repo.GetJSON(ctx, accessKey(objectKey), access)
+	if err != nil && !errors.Is(err, utils.ErrNotFound) {
+		return nil, err
+	}
+
+	prepareAccess(access, objectKey, metadata)
+	return access, nil
+}
+
+// prepareAccess completes an access document read from the repository, or
+// left empty because the object is not shared.
+func prepareAccess(access *model.ObjectAccess, objectKey string, metadata map[string]string) {
+	access.Key = objectKey
+	if access.Users == nil {
+		access.Users = map[string]string{}
+	}
+	if access.Groups == nil {
+		access.Groups = map[string]string{}
+	}
+	access.Owner = utils.DecodeMetadataValue(metadata[utils.MetaOwner])
+}
+
+// deleteAccess drops the access document of a deleted object.
+func (u *usecaseUpload) deleteAccess(ctx context.Context, funcName string, objectKey string) {
+	err := u.repo.DeleteFile(ctx, accessKey(objectKey))
+	if err != nil && !errors.Is(err, utils.ErrNotFound) {
+		utils.ErrorLog("usecase", funcName+" deleteAccess", err)
+	}
+}
+
+// moveAccess carries the access document of oldKey over to newKey after the
+// object has been replaced or renamed.
+func (u *usecaseUpload) moveAccess(ctx context.Context, funcName string, oldKey string, newKey string) {
+	if oldKey == newKey {
+		return
+	}
+
+	err := moveDocument(ctx, u.repo, accessKey(oldKey), accessKey(newKey), func(access *model.ObjectAccess, moved *model.ObjectAccess) {
+		*access = *moved
+		access.Key = newKey
+	})
+	if err != nil {
+		utils.ErrorLog("usecase", funcName+" moveAccess", err)
+	}
+}
+
+// setOwner records the caller as the owner of a new object, or keeps owner
+// when the object replaces one that already had an owner.
+func setOwner(ctx context.Context, metadata map[string]string, owner string) {
+	if owner != "" {
+		metadata[utils.MetaOwner] = owner
+		return
+	}
+	if identity := model.IdentityFromContext(ctx); identity != nil {
+		metadata[utils.MetaOwner] = utils.EncodeMetadataValue(identity.Subject)
+	}
+}
+
+func normalizePrincipals(principals []string) ([]string, error) {
+	normalized := make([]string, 0, len(principals))
+	for _, principal := range principals {
+		principal = strings.TrimSpace(principal)
+		switch {
+		case principal == "":
+			return nil, fmt.Errorf("%w: users and groups must not be empty", utils.ErrInvalidAccess)
+		case !utf8.ValidString(principal) || utf8.RuneCountInString(principal) > maxPrincipalLength:
+			return nil, fmt.Errorf("%w: users and groups must be at most %d characters of valid UTF-8", utils.ErrInvalidAccess, maxPrincipalLength)
+		case strings.IndexFunc(principal, unicode.IsControl) != -1:
+			return nil, fmt.Errorf("%w: users and groups must not contain control characters", utils.ErrInvalidAccess)
+		}
+		normalized = append(normalized, principal)
+	}
+	return normalized, nil
+}
+
+func accessKey(objectKey string) string {
+	return utils.ACLsPrefix + url.PathEscape(objectKey) + ".json"
+}
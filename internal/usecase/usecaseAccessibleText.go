@@ -0,0 +1,200 @@
+package usecase
+
+import (
+	"context"
+	"errors"
+	"net/url"
+	"sync"
+
+	"github.com/adityaw24/go-aws-garasi/internal/model"
+	"github.com/adityaw24/go-aws-garasi/utils"
+)
+
+// Alt texts and captions: an object that has any keeps them in a
+// model.AccessibleText document under utils.TextsPrefix, since a few
+// captions in several languages do not fit in the 2 KB of S3 metadata.
+// Objects stored before those documents existed have them in their metadata,
+// which is read while they have no document and replaced by one the next
+// time their text is changed.
+
+// textsMu serializes the read-modify-write cycles on text documents within
+// this process.
+var textsMu sync.Mutex
+
+// accessibleTextOf returns the alt texts and captions of objectKey, whose
+// metadata is given for objects that have no document yet.
+func (u *usecaseUpload) accessibleTextOf(ctx context.Context, objectKey string, metadata map[string]string) (*model.AccessibleText, error) {
+	texts := model.AccessibleText{Key: objectKey}
+	err := u.repo.GetJSON(ctx, textsKey(objectKey), &texts)
+	if errors.Is(err, utils.ErrNotFound) {
+		texts.AltText, texts.AltTexts = utils.AccessibleText(metadata, utils.MetaAltText)
+		texts.Caption, texts.Captions = utils.AccessibleText(metadata, utils.MetaCaption)
+		return &texts, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	return &texts, nil
+}
+
+// updateAccessibleText applies alt text and caption changes to the document
+// of objectKey; see setAccessibleText.
+func (u *usecaseUpload) updateAccessibleText(ctx context.Context, objectKey string, metadata map[string]string, altText *string, caption *string, altTexts map[string]string, captions map[string]string) error {
+	textsMu.Lock()
+	defer textsMu.Unlock()
+
+	texts, err := u.accessibleTextOf(ctx, objectKey, metadata)
+	if err != nil {
+		return err
+	}
+
+	err = setAccessibleText(texts, altText, caption, altTexts, captions)
+	if err != nil {
+		return err
+	}
+
+	return u.storeAccessibleText(ctx, objectKey, texts)
+}
+
+// putAccessibleText stores texts as the document of objectKey.
+func (u *usecaseUpload) putAccessibleText(ctx context.Context, objectKey string, texts *model.AccessibleText) error {
+	textsMu.Lock()
+	defer textsMu.Unlock()
+
+	return u.storeAccessibleText(ctx, objectKey, texts)
+}
+
+// storeAccessibleText writes the document of objectKey, or deletes it when
+// texts is empty. The caller holds textsMu.
+func (u *usecaseUpload) storeAccessibleText(ctx context.Context, objectKey string, texts *model.AccessibleText) error {
+	texts.Key = objectKey
+	if texts.AltText == "" && texts.Caption == "" && len(texts.AltTexts) == 0 && len(texts.Captions) == 0 {
+		err := u.repo.DeleteFile(ctx, textsKey(objectKey))
+		if errors.Is(err, utils.ErrNotFound) {
+			return nil
+		}
+		return err
+	}
+
+	return u.repo.PutJSON(ctx, textsKey(objectKey), texts)
+}
+
+// moveAccessibleText transfers the document of oldKey to newKey after a
+// rename.
+func (u *usecaseUpload) moveAccessibleText(ctx context.Context, funcName string, oldKey string, newKey string) {
+	if oldKey == newKey {
+		return
+	}
+
+	textsMu.Lock()
+	defer textsMu.Unlock()
+
+	var texts model.AccessibleText
+	err := u.repo.GetJSON(ctx, textsKey(oldKey), &texts)
+	if errors.Is(err, utils.ErrNotFound) {
+		return
+	}
+	if err == nil {
+		texts.Key = newKey
+		err = u.repo.PutJSON(ctx, textsKey(newKey), &texts)
+	}
+	if err == nil {
+		err = u.repo.DeleteFile(ctx, textsKey(oldKey))
+	}
+	if err != nil {
+		utils.ErrorLog("usecase", funcName+" moveAccessibleText", err)
+	}
+}
+
+func (u *usecaseUpload) deleteAccessibleText(ctx context.Context, funcName string, objectKey string) {
+	textsMu.Lock()
+	defer textsMu.Unlock()
+
+	err := u.repo.DeleteFile(ctx, textsKey(objectKey))
+	if err != nil && !errors.Is(err, utils.ErrNotFound) {
+		utils.ErrorLog("usecase", funcName+" deleteAccessibleText", err)
+	}
+}
+
+// attachAccessibleText fills in the alt texts and captions of objects from
+// their documents. Objects without one keep those read from their metadata.
+// A single object reads its document directly; longer lists first list the
+// documents, so that objects without one cost no request.
+func (u *usecaseUpload) attachAccessibleText(ctx context.Context, objects []model.FileModel) error {
+	if len(objects) == 1 {
+		var texts model.AccessibleText
+		err := u.repo.GetJSON(ctx, textsKey(objects[0].Key), &texts)
+		if errors.Is(err, utils.ErrNotFound) {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		applyAccessibleText(&objects[0], &texts)
+		return nil
+	}
+
+	documents, err := u.repo.ListKeys(ctx, utils.TextsPrefix)
+	if err != nil {
+		return err
+	}
+	if len(documents) == 0 {
+		return nil
+	}
+
+	exists := make(map[string]bool, len(documents))
+	for _, document := range documents {
+		exists[document] = true
+	}
+
+	for i := range objects {
+		if !exists[textsKey(objects[i].Key)] {
+			continue
+		}
+
+		var texts model.AccessibleText
+		err = u.repo.GetJSON(ctx, textsKey(objects[i].Key), &texts)
+		if err != nil {
+			utils.ErrorLog("usecase", "attachAccessibleText Repository GetJSON", err)
+			continue
+		}
+		applyAccessibleText(&objects[i], &texts)
+	}
+
+	return nil
+}
+
+func applyAccessibleText(object *model.FileModel, texts *model.AccessibleText) {
+	object.AltText, object.AltTexts = texts.AltText, texts.AltTexts
+	object.Caption, object.Captions = texts.Caption, texts.Captions
+}
+
+// PresentObjects completes objects read from the repository for a response:
+// it attaches their alt texts and captions and replaces the URLs of
+// published images with their public URL.
+func (u *usecaseUpload) PresentObjects(ctx context.Context, objects []model.FileModel) ([]model.FileModel, error) {
+	err := u.attachAccessibleText(ctx, objects)
+	if err != nil {
+		return nil, err
+	}
+
+	return u.publicURLs(ctx, objects), nil
+}
+
+// setAccessibleText applies alt text and caption changes to texts; see
+// utils.SetAccessibleText.
+func setAccessibleText(texts *model.AccessibleText, altText *string, caption *string, altTexts map[string]string, captions map[string]string) error {
+	var err error
+	texts.AltText, texts.AltTexts, err = utils.SetAccessibleText(utils.MetaAltText, texts.AltText, texts.AltTexts, altText, altTexts)
+	if err != nil {
+		return err
+	}
+
+	texts.Caption, texts.Captions, err = utils.SetAccessibleText(utils.MetaCaption, texts.Caption, texts.Captions, caption, captions)
+	return err
+}
+
+func textsKey(objectKey string) string {
+	return utils.TextsPrefix + url.PathEscape(objectKey) + ".json"
+}
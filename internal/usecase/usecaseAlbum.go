@@ -0,0 +1,367 @@
+package usecase
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"slices"
+	"strings"
+	"time"
+	"unicode/utf8"
+
+	"github.com/adityaw24/go-aws-garasi/internal/model"
+	"github.com/adityaw24/go-aws-garasi/internal/repo"
+	"github.com/adityaw24/go-aws-garasi/utils"
+	"github.com/google/uuid"
+)
+
+const (
+	maxAlbumNameLength = 100
+	maxAlbumKeys       = 1000
+)
+
+type UsecaseAlbum interface {
+	ListAlbums(ctx context.Context) ([]model.AlbumModel, error)
+	CreateAlbum(ctx context.Context, name string) (*model.Album, error)
+	RenameAlbum(ctx context.Context, albumID string, name string) (*model.Album, error)
+	DeleteAlbum(ctx context.Context, albumID string) error
+	AddToAlbum(ctx context.Context, albumID string, keys []string) (*model.Album, error)
+	RemoveFromAlbum(ctx context.Context, albumID string, keys []string) (*model.Album, error)
+	ReorderAlbum(ctx context.Context, albumID string, keys []string) (*model.Album, error)
+	SetAlbumCover(ctx context.Context, albumID string, key string) (*model.Album, error)
+	AlbumContents(ctx context.Context, albumID string) (*model.AlbumContents, error)
+}
+
+type usecaseAlbum struct {
+	repo    repo.RepoUpload
+	uploads UsecaseUpload
+}
+
+// NewUsecaseAlbum creates the album usecase. Objects are checked through
+// uploads, so albums only show what the caller may read.
+func NewUsecaseAlbum(repo repo.RepoUpload, uploads UsecaseUpload) UsecaseAlbum {
+	return &usecaseAlbum{
+		repo:    repo,
+		uploads: uploads,
+	}
+}
+
+func (u *usecaseAlbum) ListAlbums(ctx context.Context) ([]model.AlbumModel, error) {
+	keys, err := u.repo.ListKeys(ctx, utils.AlbumsPrefix)
+	if err != nil {
+		utils.ErrorLog("usecase", "ListAlbums Repository ListKeys", err)
+		return nil, err
+	}
+
+	albums := []model.AlbumModel{}
+	for _, key := range keys {
+		var album model.Album
+		err = u.repo.GetJSON(ctx, key, &album)
+		if err != nil {
+			utils.ErrorLog("usecase", "ListAlbums Repository GetJSON", err)
+			continue
+		}
+		albums = append(albums, u.albumModel(ctx, &album))
+	}
+
+	slices.SortFunc(albums, func(a, b model.AlbumModel) int {
+		return strings.Compare(a.Name, b.Name)
+	})
+
+	return albums, nil
+}
+
+func (u *usecaseAlbum) CreateAlbum(ctx context.Context, name string) (*model.Album, error) {
+	name, err := normalizeAlbumName(name)
+	if err != nil {
+		utils.ErrorLog("usecase", "CreateAlbum normalizeAlbumName", err)
+		return nil, err
+	}
+
+	now := time.Now().UTC()
+	album := &model.Album{
+		ID:        uuid.New().String(),
+		Name:      name,
+		Keys:      []string{},
+		CreatedAt: now,
+		UpdatedAt: now,
+	}
+
+	err = u.repo.PutJSON(ctx, albumKey(album.ID), album)
+	if err != nil {
+		utils.ErrorLog("usecase", "CreateAlbum Repository PutJSON", err)
+		return nil, err
+	}
+
+	return album, nil
+}
+
+func (u *usecaseAlbum) RenameAlbum(ctx context.Context, albumID string, name string) (*model.Album, error) {
+	name, err := normalizeAlbumName(name)
+	if err != nil {
+		utils.ErrorLog("usecase", "RenameAlbum normalizeAlbumName", err)
+		return nil, err
+	}
+
+	return u.updateAlbum(ctx, "RenameAlbum", albumID, func(album *model.Album) error {
+		album.Name = name
+		return nil
+	})
+}
+
+// DeleteAlbum deletes the album only; its objects are kept.
+func (u *usecaseAlbum) DeleteAlbum(ctx context.Context, albumID string) error {
+	_, err := u.getAlbum(ctx, albumID)
+	if err != nil {
+		utils.ErrorLog("usecase", "DeleteAlbum getAlbum", err)
+		return err
+	}
+
+	err = u.repo.DeleteFile(ctx, albumKey(albumID))
+	if err != nil {
+		utils.ErrorLog("usecase", "DeleteAlbum Repository DeleteFile", err)
+		return err
+	}
+
+	return nil
+}
+
+// AddToAlbum appends keys that are not in the album yet, in the given order.
+func (u *usecaseAlbum) AddToAlbum(ctx context.Context, albumID string, keys []string) (*model.Album, error) {
+	for _, key := range keys {
+		err := u.uploads.AuthorizeObject(ctx, key, model.PermissionRead)
+		if err != nil {
+			utils.ErrorLog("usecase", "AddToAlbum AuthorizeObject", err)
+			return nil, err
+		}
+	}
+
+	return u.updateAlbum(ctx, "AddToAlbum", albumID, func(album *model.Album) error {
+		for _, key := range keys {
+			if !slices.Contains(album.Keys, key) {
+				album.Keys = append(album.Keys, key)
+			}
+		}
+		if len(album.Keys) > maxAlbumKeys {
+			return fmt.Errorf("%w: an album holds at most %d objects", utils.ErrInvalidAlbum, maxAlbumKeys)
+		}
+		return nil
+	})
+}
+
+func (u *usecaseAlbum) RemoveFromAlbum(ctx context.Context, albumID string, keys []string) (*model.Album, error) {
+	return u.updateAlbum(ctx, "RemoveFromAlbum", albumID, func(album *model.Album) error {
+		album.Keys = slices.DeleteFunc(album.Keys, func(key string) bool {
+			return slices.Contains(keys, key)
+		})
+		if slices.Contains(keys, album.CoverKey) {
+			album.CoverKey = ""
+		}
+		return nil
+	})
+}
+
+// ReorderAlbum replaces the order of the album. keys must hold exactly the
+// objects of the album.
+func (u *usecaseAlbum) ReorderAlbum(ctx context.Context, albumID string, keys []string) (*model.Album, error) {
+	return u.updateAlbum(ctx, "ReorderAlbum", albumID, func(album *model.Album) error {
+		current := slices.Clone(album.Keys)
+		ordered := slices.Clone(keys)
+		slices.Sort(current)
+		slices.Sort(ordered)
+		if !slices.Equal(current, ordered) {
+			return fmt.Errorf("%w: the new order must contain every object of the album exactly once", utils.ErrInvalidAlbum)
+		}
+
+		album.Keys = slices.Clone(keys)
+		return nil
+	})
+}
+
+func (u *usecaseAlbum) SetAlbumCover(ctx context.Context, albumID string, key string) (*model.Album, error) {
+	if key != "" {
+		err := u.uploads.AuthorizeObject(ctx, key, model.PermissionRead)
+		if err != nil {
+			utils.ErrorLog("usecase", "SetAlbumCover AuthorizeObject", err)
+			return nil, err
+		}
+	}
+
+	return u.updateAlbum(ctx, "SetAlbumCover", albumID, func(album *model.Album) error {
+		if key != "" && !slices.Contains(album.Keys, key) {
+			return fmt.Errorf("%w: the cover must be an object of the album", utils.ErrInvalidAlbum)
+		}
+		album.CoverKey = key
+		return nil
+	})
+}
+
+// AlbumContents returns the album with its objects in album order. Objects
+// that were deleted since they were added, or that the caller may not read,
+// are left out.
+func (u *usecaseAlbum) AlbumContents(ctx context.Context, albumID string) (*model.AlbumContents, error) {
+	album, err := u.getAlbum(ctx, albumID)
+	if err != nil {
+		utils.ErrorLog("usecase", "AlbumContents getAlbum", err)
+		return nil, err
+	}
+
+	items := []model.FileModel{}
+	for _, key := range album.Keys {
+		fileModel, err := u.repo.GetFileModel(ctx, key)
+		if errors.Is(err, utils.ErrNotFound) {
+			continue
+		}
+		if err != nil {
+			utils.ErrorLog("usecase", "AlbumContents Repository GetFileModel", err)
+			return nil, err
+		}
+		items = append(items, *fileModel)
+	}
+
+	items, err = u.uploads.FilterReadable(ctx, items)
+	if err != nil {
+		utils.ErrorLog("usecase", "AlbumContents FilterReadable", err)
+		return nil, err
+	}
+	items, err = u.uploads.PresentObjects(ctx, items)
+	if err != nil {
+		utils.ErrorLog("usecase", "AlbumContents PresentObjects", err)
+		return nil, err
+	}
+
+	return &model.AlbumContents{
+		AlbumModel: u.albumModel(ctx, album),
+		Items:      items,
+	}, nil
+}
+
+// updateAlbum loads an album, applies update and stores the result; see
+// updateDocument.
+func (u *usecaseAlbum) updateAlbum(ctx context.Context, funcName string, albumID string, update func(album *model.Album) error) (*model.Album, error) {
+	if _, err := uuid.Parse(albumID); err != nil {
+		err = fmt.Errorf("album %s %w", albumID, utils.ErrNotFound)
+		utils.ErrorLog("usecase", funcName, err)
+		return nil, err
+	}
+
+	album, err := updateDocument(ctx, u.repo, albumKey(albumID), func(album *model.Album, exists bool) (documentUpdate, error) {
+		if !exists {
+			return documentUnchanged, fmt.Errorf("album %s %w", albumID, utils.ErrNotFound)
+		}
+
+		err := update(album)
+		if err != nil {
+			return documentUnchanged, err
+		}
+		album.UpdatedAt = time.Now().UTC()
+		return documentPut, nil
+	})
+	if err != nil {
+		utils.ErrorLog("usecase", funcName+" updateDocument", err)
+		return nil, err
+	}
+
+	return album, nil
+}
+
+func (u *usecaseAlbum) getAlbum(ctx context.Context, albumID string) (*model.Album, error) {
+	if _, err := uuid.Parse(albumID); err != nil {
+		return nil, fmt.Errorf("album %s %w", albumID, utils.ErrNotFound)
+	}
+
+	var album model.Album
+	err := u.repo.GetJSON(ctx, albumKey(albumID), &album)
+	if errors.Is(err, utils.ErrNotFound) {
+		return nil, fmt.Errorf("album %s %w", albumID, utils.ErrNotFound)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	return &album, nil
+}
+
+// albumModel adds the object count and the URL of the cover, which defaults
+// to the first object. The URL is left out when the caller may not
+// read the cover.
+func (u *usecaseAlbum) albumModel(ctx context.Context, album *model.Album) model.AlbumModel {
+	albumModel := model.AlbumModel{
+		Album: *album,
+		Count: len(album.Keys),
+	}
+
+	coverKey := album.CoverKey
+	if coverKey == "" && len(album.Keys) > 0 {
+		coverKey = album.Keys[0]
+	}
+	if coverKey != "" && u.uploads.AuthorizeObject(ctx, coverKey, model.PermissionRead) == nil {
+		cover, err := u.repo.GetFileModel(ctx, coverKey)
+		if err == nil {
+			covers, err := u.uploads.PresentObjects(ctx, []model.FileModel{*cover})
+			if err == nil {
+				albumModel.CoverUrl = covers[0].Url
+			}
+		}
+	}
+
+	return albumModel
+}
+
+// moveAlbumKeys replaces oldKey by newKey in every album after a rename.
+func (u *usecaseUpload) moveAlbumKeys(ctx context.Context, funcName string, oldKey string, newKey string) {
+	if oldKey == newKey {
+		return
+	}
+	u.updateAlbumKeys(ctx, funcName+" moveAlbumKeys", oldKey, newKey)
+}
+
+// deleteAlbumKeys removes a deleted object from every album.
+func (u *usecaseUpload) deleteAlbumKeys(ctx context.Context, funcName string, objectKey string) {
+	u.updateAlbumKeys(ctx, funcName+" deleteAlbumKeys", objectKey, "")
+}
+
+// updateAlbumKeys replaces oldKey by newKey, or removes it when newKey is
+// empty, in the albums and covers of the tenant.
+func (u *usecaseUpload) updateAlbumKeys(ctx context.Context, funcName string, oldKey string, newKey string) {
+	keys, err := u.repo.ListKeys(ctx, utils.AlbumsPrefix)
+	if err != nil {
+		utils.ErrorLog("usecase", funcName+" Repository ListKeys", err)
+		return
+	}
+
+	for _, key := range keys {
+		_, err = updateDocument(ctx, u.repo, key, func(album *model.Album, exists bool) (documentUpdate, error) {
+			i := slices.Index(album.Keys, oldKey)
+			if !exists || i == -1 {
+				return documentUnchanged, nil
+			}
+
+			if newKey == "" || slices.Contains(album.Keys, newKey) {
+				album.Keys = slices.Delete(album.Keys, i, i+1)
+			} else {
+				album.Keys[i] = newKey
+			}
+			if album.CoverKey == oldKey {
+				album.CoverKey = newKey
+			}
+			album.UpdatedAt = time.Now().UTC()
+			return documentPut, nil
+		})
+		if err != nil {
+			utils.ErrorLog("usecase", funcName+" updateDocument", err)
+		}
+	}
+}
+
+func normalizeAlbumName(name string) (string, error) {
+	name = strings.TrimSpace(name)
+	if name == "" || utf8.RuneCountInString(name) > maxAlbumNameLength {
+		return "", fmt.Errorf("%w: name must be 1 to %d characters", utils.ErrInvalidAlbum, maxAlbumNameLength)
+	}
+	return name, nil
+}
+
+func albumKey(albumID string) string {
+	return utils.AlbumsPrefix + albumID + ".json"
+}
@@ -0,0 +1,169 @@
+package usecase
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"net/url"
+	"strconv"
+
+	"github.com/adityaw24/go-aws-garasi/internal/model"
+	"github.com/adityaw24/go-aws-garasi/utils"
+)
+
+// Content-addressed storage: with DEDUP_ENABLED the bytes of an upload are
+// stored once under utils.ContentPrefix + sha256, and the logical key becomes
+// an empty pointer object whose metadata references the content. Each
+// logical key holds a marker under utils.RefsPrefix + sha256 + "/", and the
+// content is deleted when the last marker goes.
+
+// releasedSuffix marks the copy of content kept while it is being deleted,
+// so that it can be restored if a reference appears meanwhile.
+const releasedSuffix = ".released"
+
+// storeDeduplicated stores fileBytes, whose SHA-256 is hash, for the logical
+// key, reusing existing content with the same hash.
+func (u *usecaseUpload) storeDeduplicated(ctx context.Context, key string, attach utils.Upload, fileBytes []byte, hash string) error {
+	contentKey := utils.ContentPrefix + hash
+
+	// The reference is registered before the content is checked, so a
+	// concurrent release of the same content never observes zero references.
+	err := u.repo.PutObject(ctx, refKey(hash, key), nil, "application/octet-stream", nil)
+	if err != nil {
+		return err
+	}
+
+	_, err = u.repo.HeadMetadata(ctx, contentKey)
+	if errors.Is(err, utils.ErrNotFound) {
+		content := utils.Upload{
+			Length:      attach.Length,
+			ContentType: attach.ContentType,
+		}
+		err = u.repo.UploadFile(ctx, bytes.NewReader(fileBytes), contentKey, content, fileBytes)
+	}
+	if err != nil {
+		return err
+	}
+
+	metadata := make(map[string]string, len(attach.Metadata)+3)
+	for k, v := range attach.Metadata {
+		metadata[k] = v
+	}
+	metadata[utils.MetaContentRef] = contentKey
+	metadata[utils.MetaContentHash] = hash
+	metadata[utils.MetaContentSize] = strconv.Itoa(len(fileBytes))
+
+	err = u.repo.PutObject(ctx, key, nil, attach.ContentType, metadata)
+	if err != nil {
+		return err
+	}
+
+	if len(attach.Tags) > 0 {
+		return u.repo.PutTags(ctx, key, attach.Tags)
+	}
+
+	return nil
+}
+
+// releaseContent drops the reference held by key and deletes the shared
+// content once no references remain. Objects that are not dedup pointers are
+// ignored.
+//
+// A concurrent storeDeduplicated may register its reference right after the
+// references are listed and find the content still there, so it skips the
+// upload. The content is therefore copied aside before it is deleted, the
+// references are listed again, and the copy is restored if one appeared.
+func (u *usecaseUpload) releaseContent(ctx context.Context, key string, metadata map[string]string) error {
+	hash := metadata[utils.MetaContentHash]
+	if metadata[utils.MetaContentRef] == "" || hash == "" {
+		return nil
+	}
+
+	err := u.repo.DeleteFile(ctx, refKey(hash, key))
+	if err != nil && !errors.Is(err, utils.ErrNotFound) {
+		return err
+	}
+
+	referenced, err := u.contentReferenced(ctx, hash)
+	if err != nil || referenced {
+		return err
+	}
+
+	contentKey := metadata[utils.MetaContentRef]
+	releasedKey := contentKey + releasedSuffix
+	err = u.repo.CopyObject(ctx, &model.CopyObjectRequest{OldKey: contentKey, NewKey: releasedKey})
+	if errors.Is(err, utils.ErrNotFound) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+
+	err = u.repo.DeleteFile(ctx, contentKey)
+	if err != nil {
+		return err
+	}
+
+	referenced, err = u.contentReferenced(ctx, hash)
+	if err == nil && referenced {
+		err = u.repo.CopyObject(ctx, &model.CopyObjectRequest{OldKey: releasedKey, NewKey: contentKey})
+	}
+	if err != nil {
+		// The copy is kept so that the content can still be recovered.
+		return fmt.Errorf("content %s may be missing, a copy is kept at %s: %v", contentKey, releasedKey, err)
+	}
+
+	return u.repo.DeleteFile(ctx, releasedKey)
+}
+
+// contentReferenced reports whether any logical key references the content
+// with hash.
+func (u *usecaseUpload) contentReferenced(ctx context.Context, hash string) (bool, error) {
+	refs, err := u.repo.ListKeys(ctx, utils.RefsPrefix+hash+"/")
+	if err != nil {
+		return false, err
+	}
+	return len(refs) > 0, nil
+}
+
+// moveReference transfers the reference held by oldKey to newKey after a
+// dedup pointer has been renamed.
+func (u *usecaseUpload) moveReference(ctx context.Context, oldKey string, newKey string, metadata map[string]string) error {
+	hash := metadata[utils.MetaContentHash]
+	if metadata[utils.MetaContentRef] == "" || hash == "" {
+		return nil
+	}
+
+	err := u.repo.PutObject(ctx, refKey(hash, newKey), nil, "application/octet-stream", nil)
+	if err != nil {
+		return err
+	}
+
+	return u.repo.DeleteFile(ctx, refKey(hash, oldKey))
+}
+
+// resolveKey returns the key holding the bytes of objectKey, which differs
+// from objectKey only for dedup pointers. The caller must be allowed to read
+// objectKey.
+func (u *usecaseUpload) resolveKey(ctx context.Context, objectKey string) (string, error) {
+	metadata, err := u.authorizeKey(ctx, objectKey, model.PermissionRead)
+	if err != nil {
+		return "", err
+	}
+
+	return contentKeyOf(objectKey, metadata), nil
+}
+
+// contentKeyOf returns the key holding the bytes of objectKey, given its
+// metadata.
+func contentKeyOf(objectKey string, metadata map[string]string) string {
+	if contentRef := metadata[utils.MetaContentRef]; contentRef != "" {
+		return contentRef
+	}
+	return objectKey
+}
+
+func refKey(hash string, key string) string {
+	return utils.RefsPrefix + hash + "/" + url.PathEscape(key)
+}
@@ -0,0 +1,108 @@
+package usecase
+
+import (
+	"context"
+	"errors"
+	"fmt"
+
+	"github.com/adityaw24/go-aws-garasi/internal/repo"
+	"github.com/adityaw24/go-aws-garasi/utils"
+)
+
+// Service documents such as access grants, albums, references and share
+// links are changed by every instance of the service. Each change reads the
+// document with its ETag and is only written if the document is still that
+// version; when another instance changed it in between, the change starts
+// over from the new version, so that neither update is lost.
+
+// maxDocumentAttempts bounds the attempts of updateDocument on a document
+// that keeps changing.
+const maxDocumentAttempts = 10
+
+// documentUpdate tells updateDocument what to do with a changed document.
+type documentUpdate int
+
+const (
+	documentUnchanged documentUpdate = iota
+	documentPut
+	documentDelete
+)
+
+// updateDocument reads the JSON document objectKey into a new T, which is
+// left zero when the document does not exist, and applies change to it. The
+// result is stored or deleted only if the document is still the version that
+// was read; otherwise change is applied again to the new version, so it must
+// not have other side effects. It returns the document as change left it.
+func updateDocument[T any](ctx context.Context, repo repo.RepoUpload, objectKey string, change func(doc *T, exists bool) (documentUpdate, error)) (*T, error) {
+	for attempt := 1; attempt <= maxDocumentAttempts; attempt++ {
+		doc := new(T)
+		etag, err := repo.GetJSONWithETag(ctx, objectKey, doc)
+		exists := err == nil
+		if err != nil && !errors.Is(err, utils.ErrNotFound) {
+			return nil, err
+		}
+
+		update, err := change(doc, exists)
+		if err != nil {
+			return nil, err
+		}
+
+		switch {
+		case update == documentPut:
+			_, err = repo.PutJSONIfMatch(ctx, objectKey, doc, etag)
+		case update == documentDelete && exists:
+			err = repo.DeleteFileIfMatch(ctx, objectKey, etag)
+			if errors.Is(err, utils.ErrNotFound) {
+				err = nil
+			}
+		}
+		if errors.Is(err, utils.ErrPreconditionFailed) {
+			continue
+		}
+		if err != nil {
+			return nil, err
+		}
+
+		return doc, nil
+	}
+
+	return nil, fmt.Errorf("document %s kept changing after %d attempts: %w", objectKey, maxDocumentAttempts, utils.ErrPreconditionFailed)
+}
+
+// moveDocument moves the JSON document oldKey to newKey after the object it
+// describes was renamed. merge applies the moved document to the one at
+// newKey, which is zero when it does not exist. The document at oldKey is
+// only deleted if it was not changed while it was moved; otherwise it is
+// moved again, so merge must accept the same document twice.
+func moveDocument[T any](ctx context.Context, repo repo.RepoUpload, oldKey string, newKey string, merge func(doc *T, moved *T)) error {
+	for attempt := 1; attempt <= maxDocumentAttempts; attempt++ {
+		moved := new(T)
+		etag, err := repo.GetJSONWithETag(ctx, oldKey, moved)
+		if errors.Is(err, utils.ErrNotFound) {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+
+		_, err = updateDocument(ctx, repo, newKey, func(doc *T, _ bool) (documentUpdate, error) {
+			merge(doc, moved)
+			return documentPut, nil
+		})
+		if err != nil {
+			return err
+		}
+
+		err = repo.DeleteFileIfMatch(ctx, oldKey, etag)
+		if errors.Is(err, utils.ErrPreconditionFailed) {
+			continue
+		}
+		if err != nil && !errors.Is(err, utils.ErrNotFound) {
+			return err
+		}
+
+		return nil
+	}
+
+	return fmt.Errorf("document %s kept changing after %d attempts: %w", oldKey, maxDocumentAttempts, utils.ErrPreconditionFailed)
+}
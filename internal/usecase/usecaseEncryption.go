@@ -0,0 +1,349 @@
+package usecase
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"strings"
+	"time"
+
+	"github.com/adityaw24/go-aws-garasi/internal/model"
+	"github.com/adityaw24/go-aws-garasi/internal/repo"
+	"github.com/adityaw24/go-aws-garasi/utils"
+	"github.com/google/uuid"
+)
+
+// Master key rotation: after a new version is added to ENVELOPE_MASTER_KEYS
+// and made current, the re-wrap job unwraps the data key of every envelope
+// encrypted object with its old version and wraps it with the current one.
+// Only the metadata is rewritten, with a self-copy; the ciphertext does not
+// change. Once the job completes with no failed objects and none to retry,
+// old versions can be removed from the configuration.
+
+// rewrapJobKey is the progress document of the re-wrap job, in the bucket
+// root.
+const rewrapJobKey = utils.JobsPrefix + "rewrap"
+
+// rewrapSaveInterval is how many objects are scanned between progress saves.
+const rewrapSaveInterval = 50
+
+// rewrapLease is how long a run holds the job without saving its progress
+// before another run may take it over. Progress is saved well within it.
+const rewrapLease = 5 * time.Minute
+
+// errRewrapLeaseLost stops a run whose job was taken over by another run.
+var errRewrapLeaseLost = fmt.Errorf("re-wrap lease was taken over: %w", utils.ErrJobRunning)
+
+type UsecaseEncryption interface {
+	StartRewrap(ctx context.Context) (*model.RewrapJob, error)
+	RunRewrap(ctx context.Context) (*model.RewrapJob, error)
+	GetRewrap(ctx context.Context) (*model.RewrapJob, error)
+}
+
+type usecaseEncryption struct {
+	repo repo.RepoUpload
+	keys *utils.MasterKeys
+}
+
+// NewUsecaseEncryption creates the encryption usecase. keys may be nil, in
+// which case envelope encryption is disabled and there is nothing to rotate.
+func NewUsecaseEncryption(repo repo.RepoUpload, keys *utils.MasterKeys) UsecaseEncryption {
+	return &usecaseEncryption{
+		repo: repo,
+		keys: keys,
+	}
+}
+
+// StartRewrap starts the re-wrap job in the background and returns its
+// initial state. An unfinished job for the current key version is resumed.
+func (u *usecaseEncryption) StartRewrap(ctx context.Context) (*model.RewrapJob, error) {
+	run, err := u.beginRewrap(ctx)
+	if err != nil {
+		utils.ErrorLog("usecase", "StartRewrap beginRewrap", err)
+		return nil, err
+	}
+
+	started := *run.job
+	go u.rewrap(context.Background(), run)
+
+	return &started, nil
+}
+
+// RunRewrap runs the re-wrap job until it is done, for use outside the
+// server.
+func (u *usecaseEncryption) RunRewrap(ctx context.Context) (*model.RewrapJob, error) {
+	run, err := u.beginRewrap(ctx)
+	if err != nil {
+		utils.ErrorLog("usecase", "RunRewrap beginRewrap", err)
+		return nil, err
+	}
+
+	err = u.rewrap(ctx, run)
+	if err != nil {
+		return run.job, err
+	}
+	if run.job.Status == model.JobFailed {
+		return run.job, errors.New(run.job.LastError)
+	}
+
+	return run.job, nil
+}
+
+// GetRewrap returns the progress of the last re-wrap job.
+func (u *usecaseEncryption) GetRewrap(ctx context.Context) (*model.RewrapJob, error) {
+	var job model.RewrapJob
+	err := u.repo.GetJSON(jobsContext(ctx), rewrapJobKey, &job)
+	if errors.Is(err, utils.ErrNotFound) {
+		return nil, fmt.Errorf("re-wrap job %w", utils.ErrNotFound)
+	}
+	if err != nil {
+		utils.ErrorLog("usecase", "GetRewrap Repository GetJSON", err)
+		return nil, err
+	}
+
+	return &job, nil
+}
+
+// rewrapRun is a job being run by this process, with the ETag of the job
+// document as last written by it.
+type rewrapRun struct {
+	job   *model.RewrapJob
+	etag  string
+	saved time.Time
+}
+
+// beginRewrap takes the lease on the job document and returns the job to
+// run: the unfinished one for the current key version, or a new one. The
+// server and the rewrap command may both try; the conditional write lets
+// only one of them through.
+func (u *usecaseEncryption) beginRewrap(ctx context.Context) (*rewrapRun, error) {
+	if u.keys == nil {
+		return nil, utils.ErrEncryptionDisabled
+	}
+
+	now := time.Now().UTC()
+	job := &model.RewrapJob{}
+	etag, err := u.repo.GetJSONWithETag(jobsContext(ctx), rewrapJobKey, job)
+	if errors.Is(err, utils.ErrNotFound) {
+		job = nil
+	} else if err != nil {
+		utils.ErrorLog("usecase", "beginRewrap Repository GetJSONWithETag", err)
+		return nil, err
+	}
+
+	if job != nil && job.Status == model.JobRunning && job.LeaseExpiresAt != nil && now.Before(*job.LeaseExpiresAt) {
+		return nil, utils.ErrJobRunning
+	}
+	if job == nil || job.Status == model.JobCompleted || job.KeyVersion != u.keys.Current() {
+		job = &model.RewrapJob{
+			KeyVersion: u.keys.Current(),
+			StartedAt:  now,
+		}
+	}
+	job.Status = model.JobRunning
+	job.LastError = ""
+	job.LeaseID = uuid.NewString()
+
+	run := &rewrapRun{job: job, etag: etag}
+	err = u.saveRewrap(ctx, run)
+	if err != nil {
+		return nil, err
+	}
+
+	return run, nil
+}
+
+// rewrap processes the bucket root and every tenant from where the job
+// stopped, saving its progress as it goes. It returns an error only when
+// the lease was lost, in which case the other run owns the job document.
+func (u *usecaseEncryption) rewrap(ctx context.Context, run *rewrapRun) error {
+	job := run.job
+	err := u.rewrapTenants(ctx, run)
+	if errors.Is(err, errRewrapLeaseLost) {
+		utils.ErrorLog("usecase", "rewrap rewrapTenants", err)
+		return err
+	}
+	if err != nil {
+		utils.ErrorLog("usecase", "rewrap rewrapTenants", err)
+		job.Status = model.JobFailed
+		job.LastError = err.Error()
+	} else {
+		completedAt := time.Now().UTC()
+		job.Status = model.JobCompleted
+		job.CompletedAt = &completedAt
+	}
+
+	return u.saveRewrap(ctx, run)
+}
+
+func (u *usecaseEncryption) rewrapTenants(ctx context.Context, run *rewrapRun) error {
+	job := run.job
+	tenants, err := u.repo.ListTenants(ctx)
+	if err != nil {
+		return err
+	}
+	tenants = append([]string{""}, tenants...)
+	job.Tenants = len(tenants)
+
+	for i, tenant := range tenants {
+		// Tenants are listed in order after the root, so the ones before
+		// the job's tenant were already processed.
+		if tenant < job.Tenant {
+			continue
+		}
+		if tenant != job.Tenant {
+			job.Tenant = tenant
+			job.After = ""
+		}
+		job.TenantsDone = i
+
+		err = u.rewrapTenant(model.WithTenant(ctx, tenant), run)
+		if err != nil {
+			return fmt.Errorf("tenant %q: %w", tenant, err)
+		}
+	}
+	job.TenantsDone = len(tenants)
+
+	return nil
+}
+
+func (u *usecaseEncryption) rewrapTenant(ctx context.Context, run *rewrapRun) error {
+	job := run.job
+	keys, err := u.repo.ListKeys(ctx, "")
+	if err != nil {
+		return err
+	}
+
+	for _, key := range keys {
+		if key <= job.After || !rewrappable(key) {
+			continue
+		}
+
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+
+		rewrapped, err := u.rewrapObject(ctx, key)
+		switch {
+		case errors.Is(err, utils.ErrNotFound):
+			// Deleted since it was listed: nothing left to re-wrap.
+		case errors.Is(err, utils.ErrPreconditionFailed):
+			// Replaced since it was read; the next run picks it up.
+			job.RetryLater++
+		case err != nil:
+			utils.ErrorLog("usecase", "rewrapTenant rewrapObject", err)
+			job.Failed++
+			job.LastError = fmt.Sprintf("%s: %v", key, err)
+		case rewrapped:
+			job.Rewrapped++
+		}
+		job.Scanned++
+		job.After = key
+
+		if job.Scanned%rewrapSaveInterval == 0 || time.Since(run.saved) > rewrapLease/4 {
+			err = u.saveRewrap(ctx, run)
+			if err != nil {
+				return err
+			}
+		}
+	}
+
+	return u.saveRewrap(ctx, run)
+}
+
+// rewrapObject re-wraps the data key of objectKey with the current master
+// key version. It reports false for objects that are not envelope encrypted
+// or already use the current version.
+func (u *usecaseEncryption) rewrapObject(ctx context.Context, objectKey string) (bool, error) {
+	details, err := u.repo.StatObject(ctx, objectKey)
+	if err != nil {
+		return false, err
+	}
+
+	metadata := details.RawMetadata
+	if metadata[utils.MetaEnvelopeKey] == "" || metadata[utils.MetaEnvelopeKeyVersion] == u.keys.Current() {
+		return false, nil
+	}
+
+	dataKey, err := u.keys.Unwrap(metadata[utils.MetaEnvelopeKeyVersion], metadata[utils.MetaEnvelopeKey])
+	if err != nil {
+		return false, err
+	}
+
+	keyVersion, wrappedKey, err := u.keys.Wrap(dataKey)
+	if err != nil {
+		return false, err
+	}
+
+	newMetadata := make(map[string]string, len(metadata)+1)
+	for k, v := range metadata {
+		newMetadata[k] = v
+	}
+	newMetadata[utils.MetaEnvelopeKey] = wrappedKey
+	newMetadata[utils.MetaEnvelopeKeyVersion] = keyVersion
+
+	// The copy only applies to the version read above: an object replaced
+	// in between has another data key, which the old one must not replace.
+	err = u.repo.CopyObject(ctx, &model.CopyObjectRequest{
+		OldKey:             objectKey,
+		NewKey:             objectKey,
+		Metadata:           newMetadata,
+		ContentType:        details.ContentType,
+		CacheControl:       details.CacheControl,
+		ContentDisposition: details.ContentDisposition,
+		IfMatch:            details.ETag,
+	})
+	if err != nil {
+		return false, err
+	}
+
+	return true, nil
+}
+
+// saveRewrap stores the progress of run and renews its lease while it is
+// running, or releases it. The write is conditional on the document being
+// as run last wrote it; if another run took the job over after the lease
+// expired, saveRewrap fails with errRewrapLeaseLost. Other failed saves are
+// only logged: the job then resumes from an earlier point, which is
+// harmless.
+func (u *usecaseEncryption) saveRewrap(ctx context.Context, run *rewrapRun) error {
+	job := run.job
+	now := time.Now().UTC()
+	job.UpdatedAt = now
+	if job.Status == model.JobRunning {
+		leaseExpiresAt := now.Add(rewrapLease)
+		job.LeaseExpiresAt = &leaseExpiresAt
+	} else {
+		job.LeaseID = ""
+		job.LeaseExpiresAt = nil
+	}
+
+	// The job may have been stopped by ctx; its progress is still saved.
+	etag, err := u.repo.PutJSONIfMatch(jobsContext(context.WithoutCancel(ctx)), rewrapJobKey, job, run.etag)
+	if errors.Is(err, utils.ErrPreconditionFailed) {
+		return errRewrapLeaseLost
+	}
+	if err != nil {
+		utils.ErrorLog("usecase", "saveRewrap Repository PutJSONIfMatch", err)
+	} else {
+		run.etag = etag
+		run.saved = now
+	}
+
+	log.Printf("Re-wrap to key version %s: %s, tenant %d/%d, %d scanned, %d re-wrapped, %d to retry, %d failed\n",
+		job.KeyVersion, job.Status, job.TenantsDone, job.Tenants, job.Scanned, job.Rewrapped, job.RetryLater, job.Failed)
+	return nil
+}
+
+// rewrappable reports whether key may hold an envelope encrypted object:
+// uploaded objects and kept originals.
+func rewrappable(key string) bool {
+	return !utils.IsReservedKey(key) || strings.HasPrefix(key, utils.OriginalsPrefix)
+}
+
+// jobsContext stores job documents in the bucket root, whatever the tenant
+// of the caller.
+func jobsContext(ctx context.Context) context.Context {
+	return model.WithTenant(ctx, "")
+}
@@ -0,0 +1,156 @@
+package usecase
+
+import (
+	"context"
+	"errors"
+	"sort"
+	"sync"
+
+	"github.com/adityaw24/go-aws-garasi/internal/model"
+	"github.com/adityaw24/go-aws-garasi/utils"
+)
+
+// Near duplicates: the perceptual hashes of the images of a tenant are kept
+// in one model.PerceptualHashIndex document, so that an upload checked
+// against DUPLICATE_POLICY reads that document instead of the metadata of
+// every object. The index of a tenant is built from a listing the first time
+// it is needed, which also covers the objects stored before it existed.
+
+const phashIndexKey = utils.PHashPrefix + "index.json"
+
+// phashMu serializes the read-modify-write cycles on the index within this
+// process.
+var phashMu sync.Mutex
+
+// nearDuplicates returns the objects whose perceptual hash is at most
+// distance bits from hash, closest first.
+func (u *usecaseUpload) nearDuplicates(ctx context.Context, hash uint64, distance int, excludeKey string) ([]model.DuplicateModel, error) {
+	phashMu.Lock()
+	index, err := u.loadPHashIndex(ctx)
+	phashMu.Unlock()
+	if err != nil {
+		return nil, err
+	}
+
+	duplicates := []model.DuplicateModel{}
+	for key, phash := range index.Hashes {
+		if key == excludeKey {
+			continue
+		}
+
+		objectHash, err := utils.ParsePerceptualHash(phash)
+		if err != nil {
+			continue
+		}
+
+		d := utils.HammingDistance(hash, objectHash)
+		if d > distance {
+			continue
+		}
+
+		// Entries of objects deleted by another process are skipped.
+		fileModel, err := u.repo.GetFileModel(ctx, key)
+		if errors.Is(err, utils.ErrNotFound) {
+			continue
+		}
+		if err != nil {
+			return nil, err
+		}
+		duplicates = append(duplicates, model.DuplicateModel{
+			FileModel: *fileModel,
+			Distance:  d,
+		})
+	}
+
+	sort.Slice(duplicates, func(i, j int) bool {
+		if duplicates[i].Distance != duplicates[j].Distance {
+			return duplicates[i].Distance < duplicates[j].Distance
+		}
+		return duplicates[i].Key < duplicates[j].Key
+	})
+
+	return duplicates, nil
+}
+
+// setPHash records the perceptual hash of a stored object, or removes the
+// object from the index when it has none.
+func (u *usecaseUpload) setPHash(ctx context.Context, funcName string, objectKey string, phash string) {
+	u.changePHashIndex(ctx, funcName+" setPHash", func(hashes map[string]string) {
+		if phash == "" {
+			delete(hashes, objectKey)
+			return
+		}
+		hashes[objectKey] = phash
+	})
+}
+
+// movePHash transfers the entry of oldKey to newKey after a rename.
+func (u *usecaseUpload) movePHash(ctx context.Context, funcName string, oldKey string, newKey string) {
+	if oldKey == newKey {
+		return
+	}
+
+	u.changePHashIndex(ctx, funcName+" movePHash", func(hashes map[string]string) {
+		if phash, ok := hashes[oldKey]; ok {
+			hashes[newKey] = phash
+			delete(hashes, oldKey)
+		}
+	})
+}
+
+func (u *usecaseUpload) deletePHash(ctx context.Context, funcName string, objectKey string) {
+	u.changePHashIndex(ctx, funcName+" deletePHash", func(hashes map[string]string) {
+		delete(hashes, objectKey)
+	})
+}
+
+// changePHashIndex applies change to the index of the tenant. Errors are
+// only logged; the object itself was already stored.
+func (u *usecaseUpload) changePHashIndex(ctx context.Context, funcName string, change func(hashes map[string]string)) {
+	phashMu.Lock()
+	defer phashMu.Unlock()
+
+	index, err := u.loadPHashIndex(ctx)
+	if err == nil {
+		change(index.Hashes)
+		err = u.repo.PutJSON(ctx, phashIndexKey, index)
+	}
+	if err != nil {
+		utils.ErrorLog("usecase", funcName, err)
+	}
+}
+
+// loadPHashIndex returns the index of the tenant, building it from a listing
+// when it does not exist yet. The caller holds phashMu.
+func (u *usecaseUpload) loadPHashIndex(ctx context.Context) (*model.PerceptualHashIndex, error) {
+	var index model.PerceptualHashIndex
+	err := u.repo.GetJSON(ctx, phashIndexKey, &index)
+	if err != nil && !errors.Is(err, utils.ErrNotFound) {
+		return nil, err
+	}
+	if err == nil {
+		if index.Hashes == nil {
+			index.Hashes = map[string]string{}
+		}
+		return &index, nil
+	}
+
+	objects, err := u.repo.ListObjects(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	index.Hashes = map[string]string{}
+	for _, object := range objects {
+		if object.PHash != "" {
+			index.Hashes[object.Key] = object.PHash
+		}
+	}
+
+	err = u.repo.PutJSON(ctx, phashIndexKey, &index)
+	if err != nil {
+		return nil, err
+	}
+
+	return &index, nil
+}
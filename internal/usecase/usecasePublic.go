@@ -0,0 +1,134 @@
+package usecase
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"io"
+	"net/url"
+	"path/filepath"
+	"strings"
+
+	"github.com/adityaw24/go-aws-garasi/internal/model"
+	"github.com/adityaw24/go-aws-garasi/utils"
+)
+
+// Public images: when a watermark is configured, JPEG and PNG images are
+// published through /public instead of presigned URLs, so that the clean
+// original stays private. Public URLs are opened without authentication;
+// like share tokens, their signature is the credential. It covers the tenant
+// and the key, so a URL can neither be forged nor pointed at another object.
+
+// PublicFile renders the watermarked public variant of an image. The stored
+// original is never modified.
+func (u *usecaseUpload) PublicFile(ctx context.Context, objectKey string, tenant string, signature string) ([]byte, string, error) {
+	if u.watermark == nil {
+		return nil, "", utils.ErrWatermarkDisabled
+	}
+
+	if !hmac.Equal([]byte(signature), []byte(u.publicSignature(tenant, objectKey))) {
+		return nil, "", utils.ErrForbidden
+	}
+
+	// The image is read in the tenant of the URL, on behalf of the service.
+	ctx = model.WithIdentity(model.WithTenant(ctx, tenant), nil)
+
+	details, err := u.repo.StatObject(ctx, objectKey)
+	if err != nil {
+		utils.ErrorLog("usecase", "PublicFile Repository StatObject", err)
+		return nil, "", err
+	}
+
+	if details.Encrypted {
+		return nil, "", utils.ErrEncryptedObject
+	}
+
+	contentKey := contentKeyOf(objectKey, details.RawMetadata)
+	cacheKey := tenant + "\x00" + contentKey + "\x00" + details.ETag
+	if u.publicCache != nil {
+		if data, contentType, ok := u.publicCache.Get(cacheKey); ok {
+			return data, contentType, nil
+		}
+	}
+
+	object, err := u.openObject(ctx, contentKey)
+	if err != nil {
+		utils.ErrorLog("usecase", "PublicFile openObject", err)
+		return nil, "", err
+	}
+	defer object.Body.Close()
+
+	data, err := io.ReadAll(object.Body)
+	if err != nil {
+		utils.ErrorLog("usecase", "PublicFile ReadAll", err)
+		return nil, "", err
+	}
+
+	img, format, err := utils.DecodeImageLimited(data, u.cfg.WATERMARK_MAX_PIXELS)
+	if err != nil {
+		utils.ErrorLog("usecase", "PublicFile DecodeImageLimited", err)
+		return nil, "", utils.ErrUnsupportedImage
+	}
+
+	var buf bytes.Buffer
+	contentType, err := utils.EncodeImage(&buf, u.watermark.Apply(img), format)
+	if err != nil {
+		utils.ErrorLog("usecase", "PublicFile EncodeImage", err)
+		return nil, "", err
+	}
+
+	if u.publicCache != nil {
+		u.publicCache.Add(cacheKey, buf.Bytes(), contentType)
+	}
+
+	return buf.Bytes(), contentType, nil
+}
+
+// publicURLs replaces the URLs of published images with their public URL.
+func (u *usecaseUpload) publicURLs(ctx context.Context, objects []model.FileModel) []model.FileModel {
+	for i := range objects {
+		if u.published(objects[i].Key, objects[i].PHash, objects[i].Encrypted) {
+			objects[i].Url = u.publicURL(ctx, objects[i].Key)
+		}
+	}
+	return objects
+}
+
+// published reports whether objectKey is published through PublicFile:
+// a watermark is configured and the object is an unencrypted image it can
+// decode, which is known from its perceptual hash or its extension.
+func (u *usecaseUpload) published(objectKey string, phash string, encrypted bool) bool {
+	if u.watermark == nil || encrypted {
+		return false
+	}
+	if phash != "" {
+		return true
+	}
+
+	switch strings.ToLower(filepath.Ext(objectKey)) {
+	case ".jpg", ".jpeg", ".png":
+		return true
+	}
+	return false
+}
+
+func (u *usecaseUpload) publicURL(ctx context.Context, objectKey string) string {
+	tenant := model.TenantFromContext(ctx)
+
+	query := url.Values{}
+	if tenant != "" {
+		query.Set("tenant", tenant)
+	}
+	query.Set("sig", u.publicSignature(tenant, objectKey))
+
+	path := (&url.URL{Path: objectKey}).EscapedPath()
+	return u.cfg.SHARE_BASE_URL + u.cfg.API_GROUP + "/public/" + path + "?" + query.Encode()
+}
+
+func (u *usecaseUpload) publicSignature(tenant string, objectKey string) string {
+	mac := hmac.New(sha256.New, []byte(u.cfg.WATERMARK_URL_SECRET))
+	mac.Write([]byte(tenant + "\x00" + objectKey))
+	return base64.RawURLEncoding.EncodeToString(mac.Sum(nil))
+}
@@ -0,0 +1,365 @@
+package usecase
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"net/url"
+	"slices"
+	"strings"
+	"time"
+	"unicode"
+	"unicode/utf8"
+
+	"github.com/adityaw24/go-aws-garasi/internal/model"
+	"github.com/adityaw24/go-aws-garasi/internal/repo"
+	"github.com/adityaw24/go-aws-garasi/utils"
+)
+
+// External references: other services register the entities that use an
+// object and release them when their records go away. Each tracked object
+// has a model.ObjectReferences document under utils.ReferencesPrefix. Once
+// the last reference is released the object is orphaned, and the garbage
+// collector deletes it after the grace period. Objects that were never
+// referenced are not tracked and never collected. Objects with references
+// cannot be deleted until they are released. Reference documents are changed
+// with conditional writes, so that a reference registered on one instance
+// is never lost to a release or a deletion on another.
+
+const (
+	maxEntityLength = 256
+	// deletingTimeout bounds how long a deletion keeps references from
+	// being registered, should the instance deleting the object stop
+	// before it is done.
+	deletingTimeout = 5 * time.Minute
+)
+
+type UsecaseReference interface {
+	RegisterReference(ctx context.Context, referenceRequest *model.ReferenceRequest) (*model.ObjectReferences, error)
+	ReleaseReference(ctx context.Context, referenceRequest *model.ReferenceRequest) (*model.ObjectReferences, error)
+	GetReferences(ctx context.Context, objectKey string) (*model.ObjectReferences, error)
+	CollectGarbage(ctx context.Context) (*model.GarbageCollection, error)
+	RunGarbageCollector(ctx context.Context, interval time.Duration)
+}
+
+type usecaseReference struct {
+	repo        repo.RepoUpload
+	uploads     UsecaseUpload
+	gracePeriod time.Duration
+}
+
+// NewUsecaseReference creates the reference usecase. Collected objects are
+// deleted through uploads so that dedup content and kept originals are
+// released as well.
+func NewUsecaseReference(repo repo.RepoUpload, uploads UsecaseUpload, gracePeriod time.Duration) UsecaseReference {
+	return &usecaseReference{
+		repo:        repo,
+		uploads:     uploads,
+		gracePeriod: gracePeriod,
+	}
+}
+
+func (u *usecaseReference) RegisterReference(ctx context.Context, referenceRequest *model.ReferenceRequest) (*model.ObjectReferences, error) {
+	entity, err := normalizeEntity(referenceRequest.Entity)
+	if err != nil {
+		utils.ErrorLog("usecase", "RegisterReference normalizeEntity", err)
+		return nil, err
+	}
+
+	// A reference keeps the object from being collected and its release
+	// lets the collector delete it, so both need write access.
+	err = u.uploads.AuthorizeObject(ctx, referenceRequest.Key, model.PermissionWrite)
+	if err != nil {
+		utils.ErrorLog("usecase", "RegisterReference AuthorizeObject", err)
+		return nil, err
+	}
+
+	references, err := updateDocument(ctx, u.repo, referencesKey(referenceRequest.Key), func(references *model.ObjectReferences, exists bool) (documentUpdate, error) {
+		prepareReferences(references, referenceRequest.Key)
+		if deleting(references) {
+			return documentUnchanged, fmt.Errorf("file %s is being deleted: %w", referenceRequest.Key, utils.ErrNotFound)
+		}
+
+		if slices.ContainsFunc(references.References, func(reference model.Reference) bool {
+			return reference.Entity == entity
+		}) {
+			return documentUnchanged, nil
+		}
+
+		references.References = append(references.References, model.Reference{
+			Entity:    entity,
+			CreatedAt: time.Now().UTC(),
+		})
+		references.OrphanedAt = nil
+		references.DeletingAt = nil
+		return documentPut, nil
+	})
+	if err != nil {
+		utils.ErrorLog("usecase", "RegisterReference updateDocument", err)
+		return nil, err
+	}
+
+	return references, nil
+}
+
+func (u *usecaseReference) ReleaseReference(ctx context.Context, referenceRequest *model.ReferenceRequest) (*model.ObjectReferences, error) {
+	entity, err := normalizeEntity(referenceRequest.Entity)
+	if err != nil {
+		utils.ErrorLog("usecase", "ReleaseReference normalizeEntity", err)
+		return nil, err
+	}
+
+	err = u.uploads.AuthorizeObject(ctx, referenceRequest.Key, model.PermissionWrite)
+	if err != nil {
+		utils.ErrorLog("usecase", "ReleaseReference AuthorizeObject", err)
+		return nil, err
+	}
+
+	references, err := updateDocument(ctx, u.repo, referencesKey(referenceRequest.Key), func(references *model.ObjectReferences, exists bool) (documentUpdate, error) {
+		prepareReferences(references, referenceRequest.Key)
+
+		count := len(references.References)
+		references.References = slices.DeleteFunc(references.References, func(reference model.Reference) bool {
+			return reference.Entity == entity
+		})
+		if len(references.References) == count {
+			return documentUnchanged, fmt.Errorf("reference %s to %s %w", entity, referenceRequest.Key, utils.ErrNotFound)
+		}
+
+		if len(references.References) == 0 {
+			now := time.Now().UTC()
+			references.OrphanedAt = &now
+		}
+		return documentPut, nil
+	})
+	if err != nil {
+		utils.ErrorLog("usecase", "ReleaseReference updateDocument", err)
+		return nil, err
+	}
+
+	return references, nil
+}
+
+func (u *usecaseReference) GetReferences(ctx context.Context, objectKey string) (*model.ObjectReferences, error) {
+	err := u.uploads.AuthorizeObject(ctx, objectKey, model.PermissionRead)
+	if err != nil {
+		utils.ErrorLog("usecase", "GetReferences AuthorizeObject", err)
+		return nil, err
+	}
+
+	references, err := u.getReferences(ctx, objectKey)
+	if err != nil {
+		utils.ErrorLog("usecase", "GetReferences getReferences", err)
+		return nil, err
+	}
+
+	return references, nil
+}
+
+// CollectGarbage deletes the objects of the tenant of ctx that have been
+// orphaned for longer than the grace period.
+func (u *usecaseReference) CollectGarbage(ctx context.Context) (*model.GarbageCollection, error) {
+	documents, err := u.repo.ListKeys(ctx, utils.ReferencesPrefix)
+	if err != nil {
+		utils.ErrorLog("usecase", "CollectGarbage Repository ListKeys", err)
+		return nil, err
+	}
+
+	result := &model.GarbageCollection{Deleted: []string{}}
+	for _, document := range documents {
+		var references model.ObjectReferences
+		err = u.repo.GetJSON(ctx, document, &references)
+		if err != nil {
+			utils.ErrorLog("usecase", "CollectGarbage Repository GetJSON", err)
+			continue
+		}
+		if !u.expired(&references) {
+			continue
+		}
+
+		deleted, err := u.collect(ctx, references.Key)
+		if err != nil {
+			utils.ErrorLog("usecase", "CollectGarbage collect", err)
+			continue
+		}
+		if deleted {
+			result.Deleted = append(result.Deleted, references.Key)
+		}
+	}
+
+	return result, nil
+}
+
+// RunGarbageCollector collects garbage in the bucket root and every tenant
+// each interval until ctx is done.
+func (u *usecaseReference) RunGarbageCollector(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			tenants, err := u.repo.ListTenants(ctx)
+			if err != nil {
+				utils.ErrorLog("usecase", "RunGarbageCollector Repository ListTenants", err)
+				continue
+			}
+
+			for _, tenant := range append([]string{""}, tenants...) {
+				result, err := u.CollectGarbage(model.WithTenant(ctx, tenant))
+				if err != nil {
+					continue
+				}
+				if len(result.Deleted) > 0 {
+					log.Printf("Garbage collection deleted %d orphaned objects of tenant %q: %v\n", len(result.Deleted), tenant, result.Deleted)
+				}
+			}
+		}
+	}
+}
+
+// collect deletes an orphaned object. DeleteFile checks again that it was
+// not referenced in the meantime.
+func (u *usecaseReference) collect(ctx context.Context, objectKey string) (bool, error) {
+	references, err := u.getReferences(ctx, objectKey)
+	if err != nil {
+		return false, err
+	}
+	if !u.expired(references) {
+		return false, nil
+	}
+
+	// Collection deletes on behalf of the service, not of the caller that
+	// triggered it, so object ownership does not apply.
+	err = u.uploads.DeleteFile(model.WithIdentity(ctx, nil), &model.DeleteFileRequest{Key: objectKey})
+	if errors.Is(err, utils.ErrReferenced) {
+		return false, nil
+	}
+	if errors.Is(err, utils.ErrNotFound) {
+		// The object is already gone; only the document is left.
+		return false, u.repo.DeleteFile(ctx, referencesKey(objectKey))
+	}
+	if err != nil {
+		return false, err
+	}
+
+	return true, nil
+}
+
+func (u *usecaseReference) expired(references *model.ObjectReferences) bool {
+	return len(references.References) == 0 &&
+		references.OrphanedAt != nil &&
+		time.Since(*references.OrphanedAt) >= u.gracePeriod
+}
+
+// getReferences returns the reference document of objectKey, or an empty one
+// when the object is not tracked.
+func (u *usecaseReference) getReferences(ctx context.Context, objectKey string) (*model.ObjectReferences, error) {
+	references := &model.ObjectReferences{}
+	err := u.repo.GetJSON(ctx, referencesKey(objectKey), references)
+	if err != nil && !errors.Is(err, utils.ErrNotFound) {
+		return nil, err
+	}
+
+	prepareReferences(references, objectKey)
+	return references, nil
+}
+
+// prepareReferences completes a reference document read from the
+// repository, or left empty because the object is not tracked.
+func prepareReferences(references *model.ObjectReferences, objectKey string) {
+	references.Key = objectKey
+	if references.References == nil {
+		references.References = []model.Reference{}
+	}
+}
+
+// deleting reports whether the object of references is being deleted.
+func deleting(references *model.ObjectReferences) bool {
+	return references.DeletingAt != nil && time.Since(*references.DeletingAt) < deletingTimeout
+}
+
+// deleteUnreferenced deletes an object and its reference document, or
+// returns utils.ErrReferenced while external entities still reference it.
+// The reference document is first marked as deleting with a conditional
+// write, so that no reference can be registered between the check and the
+// deletion; the mark is removed again if the object could not be deleted.
+func (u *usecaseUpload) deleteUnreferenced(ctx context.Context, objectKey string) error {
+	// An orphaned object keeps its document, and its grace period, if it
+	// could not be deleted.
+	orphaned := false
+	_, err := updateDocument(ctx, u.repo, referencesKey(objectKey), func(references *model.ObjectReferences, exists bool) (documentUpdate, error) {
+		if n := len(references.References); n > 0 {
+			return documentUnchanged, fmt.Errorf("%w: %s has %d references, release them first", utils.ErrReferenced, objectKey, n)
+		}
+
+		orphaned = references.OrphanedAt != nil
+		prepareReferences(references, objectKey)
+		now := time.Now().UTC()
+		references.DeletingAt = &now
+		return documentPut, nil
+	})
+	if err != nil {
+		return err
+	}
+
+	err = u.repo.DeleteFile(ctx, objectKey)
+	if err != nil {
+		_, markErr := updateDocument(ctx, u.repo, referencesKey(objectKey), func(references *model.ObjectReferences, exists bool) (documentUpdate, error) {
+			if !exists || references.DeletingAt == nil {
+				return documentUnchanged, nil
+			}
+			if !orphaned {
+				return documentDelete, nil
+			}
+			references.DeletingAt = nil
+			return documentPut, nil
+		})
+		if markErr != nil {
+			utils.ErrorLog("usecase", "deleteUnreferenced updateDocument", markErr)
+		}
+		return err
+	}
+
+	err = u.repo.DeleteFile(ctx, referencesKey(objectKey))
+	if err != nil && !errors.Is(err, utils.ErrNotFound) {
+		utils.ErrorLog("usecase", "deleteUnreferenced Repository DeleteFile", err)
+	}
+	return nil
+}
+
+// moveReferences carries the reference document of oldKey over to newKey
+// after the object has been replaced or renamed.
+func (u *usecaseUpload) moveReferences(ctx context.Context, funcName string, oldKey string, newKey string) {
+	if oldKey == newKey {
+		return
+	}
+
+	err := moveDocument(ctx, u.repo, referencesKey(oldKey), referencesKey(newKey), func(references *model.ObjectReferences, moved *model.ObjectReferences) {
+		*references = *moved
+		references.Key = newKey
+	})
+	if err != nil {
+		utils.ErrorLog("usecase", funcName+" moveReferences", err)
+	}
+}
+
+func normalizeEntity(entity string) (string, error) {
+	entity = strings.TrimSpace(entity)
+	switch {
+	case entity == "":
+		return "", fmt.Errorf("%w: entity is required", utils.ErrInvalidEntity)
+	case !utf8.ValidString(entity) || utf8.RuneCountInString(entity) > maxEntityLength:
+		return "", fmt.Errorf("%w: entity must be at most %d characters of valid UTF-8", utils.ErrInvalidEntity, maxEntityLength)
+	case strings.IndexFunc(entity, unicode.IsControl) != -1:
+		return "", fmt.Errorf("%w: entity must not contain control characters", utils.ErrInvalidEntity)
+	}
+	return entity, nil
+}
+
+func referencesKey(objectKey string) string {
+	return utils.ReferencesPrefix + url.PathEscape(objectKey) + ".json"
+}
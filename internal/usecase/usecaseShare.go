@@ -0,0 +1,465 @@
+package usecase
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"net/url"
+	"slices"
+	"time"
+
+	"github.com/adityaw24/go-aws-garasi/internal/model"
+	"github.com/adityaw24/go-aws-garasi/internal/repo"
+	"github.com/adityaw24/go-aws-garasi/utils"
+	"golang.org/x/crypto/bcrypt"
+)
+
+// Share links resolve a short token to an object without authentication.
+// Their documents are stored under utils.SharesPrefix in the bucket root,
+// because the tenant is only known once the link has been read; each link
+// records its tenant and the object is read in that tenant. The tokens of the
+// links to an object are indexed by its key under utils.ShareIndexPrefix, in
+// the tenant of the object, and follow it when it is renamed. Both are
+// changed with conditional writes, since downloads are counted by every
+// instance of the service.
+
+const (
+	shareTokenBytes = 16
+	// maxShareAccesses bounds the access log kept in a share document.
+	maxShareAccesses = 100
+	// maxSharePasswordLength is the bcrypt input limit, in bytes.
+	maxSharePasswordLength = 72
+)
+
+type UsecaseShare interface {
+	CreateShare(ctx context.Context, shareRequest *model.ShareRequest) (*model.ShareLink, error)
+	ListShares(ctx context.Context, objectKey string) ([]model.ShareLink, error)
+	GetShare(ctx context.Context, token string) (*model.ShareLink, error)
+	RevokeShare(ctx context.Context, token string) (*model.ShareLink, error)
+	OpenShare(ctx context.Context, token string, password string, access model.ShareAccess) (*model.ObjectStream, error)
+}
+
+type usecaseShare struct {
+	repo       repo.RepoUpload
+	uploads    UsecaseUpload
+	baseURL    string
+	defaultTTL time.Duration
+	maxTTL     time.Duration
+}
+
+// NewUsecaseShare creates the share usecase. Links are returned as baseURL
+// followed by the token. Objects are read through uploads so that dedup
+// pointers and checksums are handled as for downloads.
+func NewUsecaseShare(repo repo.RepoUpload, uploads UsecaseUpload, baseURL string, defaultTTL time.Duration, maxTTL time.Duration) UsecaseShare {
+	return &usecaseShare{
+		repo:       repo,
+		uploads:    uploads,
+		baseURL:    baseURL,
+		defaultTTL: defaultTTL,
+		maxTTL:     maxTTL,
+	}
+}
+
+// CreateShare creates a link to an object. Like access grants, links can
+// only be created by the owner of the object.
+func (u *usecaseShare) CreateShare(ctx context.Context, shareRequest *model.ShareRequest) (*model.ShareLink, error) {
+	err := utils.ValidateKey(shareRequest.Key)
+	if err != nil {
+		utils.ErrorLog("usecase", "CreateShare ValidateKey", err)
+		return nil, err
+	}
+
+	ttl := time.Duration(shareRequest.ExpiresIn) * time.Second
+	if shareRequest.ExpiresIn == 0 {
+		ttl = u.defaultTTL
+	}
+	if ttl <= 0 || ttl > u.maxTTL {
+		err = fmt.Errorf("%w: expiresIn must be between 1 and %d seconds", utils.ErrInvalidShare, int(u.maxTTL.Seconds()))
+		utils.ErrorLog("usecase", "CreateShare", err)
+		return nil, err
+	}
+
+	if shareRequest.MaxDownloads < 0 {
+		err = fmt.Errorf("%w: maxDownloads must not be negative", utils.ErrInvalidShare)
+		utils.ErrorLog("usecase", "CreateShare", err)
+		return nil, err
+	}
+
+	if len(shareRequest.Password) > maxSharePasswordLength {
+		err = fmt.Errorf("%w: password must be at most %d bytes", utils.ErrInvalidShare, maxSharePasswordLength)
+		utils.ErrorLog("usecase", "CreateShare", err)
+		return nil, err
+	}
+
+	_, err = u.uploads.GetAccess(ctx, shareRequest.Key)
+	if err != nil {
+		utils.ErrorLog("usecase", "CreateShare GetAccess", err)
+		return nil, err
+	}
+
+	metadata, err := u.repo.HeadMetadata(ctx, shareRequest.Key)
+	if err != nil {
+		utils.ErrorLog("usecase", "CreateShare Repository HeadMetadata", err)
+		return nil, err
+	}
+
+	tokenBytes := make([]byte, shareTokenBytes)
+	_, err = rand.Read(tokenBytes)
+	if err != nil {
+		utils.ErrorLog("usecase", "CreateShare rand.Read", err)
+		return nil, err
+	}
+
+	now := time.Now().UTC()
+	share := model.ShareLink{
+		Token:        base64.RawURLEncoding.EncodeToString(tokenBytes),
+		Key:          shareRequest.Key,
+		ObjectID:     metadata[utils.MetaObjectID],
+		Tenant:       model.TenantFromContext(ctx),
+		MaxDownloads: shareRequest.MaxDownloads,
+		CreatedAt:    now,
+		ExpiresAt:    now.Add(ttl),
+	}
+	if identity := model.IdentityFromContext(ctx); identity != nil {
+		share.CreatedBy = identity.Subject
+	}
+
+	if shareRequest.Password != "" {
+		hash, err := bcrypt.GenerateFromPassword([]byte(shareRequest.Password), bcrypt.DefaultCost)
+		if err != nil {
+			utils.ErrorLog("usecase", "CreateShare GenerateFromPassword", err)
+			return nil, err
+		}
+		share.PasswordHash = string(hash)
+		share.HasPassword = true
+	}
+
+	err = u.repo.PutJSON(sharesContext(ctx), shareKey(share.Token), &share)
+	if err != nil {
+		utils.ErrorLog("usecase", "CreateShare Repository PutJSON", err)
+		return nil, err
+	}
+
+	err = u.indexShare(ctx, share.Key, share.Token)
+	if err != nil {
+		utils.ErrorLog("usecase", "CreateShare indexShare", err)
+		return nil, err
+	}
+
+	return u.present(&share, true), nil
+}
+
+// ListShares returns the links to an object, without their access logs.
+func (u *usecaseShare) ListShares(ctx context.Context, objectKey string) ([]model.ShareLink, error) {
+	_, err := u.uploads.GetAccess(ctx, objectKey)
+	if err != nil {
+		utils.ErrorLog("usecase", "ListShares GetAccess", err)
+		return nil, err
+	}
+
+	var index model.ShareIndex
+	err = u.repo.GetJSON(ctx, shareIndexKey(objectKey), &index)
+	if err != nil && !errors.Is(err, utils.ErrNotFound) {
+		utils.ErrorLog("usecase", "ListShares Repository GetJSON", err)
+		return nil, err
+	}
+
+	tenant := model.TenantFromContext(ctx)
+	shares := []model.ShareLink{}
+	for _, token := range index.Tokens {
+		share, err := u.getShare(ctx, token)
+		if err != nil {
+			utils.ErrorLog("usecase", "ListShares getShare", err)
+			continue
+		}
+		if share.Tenant != tenant || share.Key != objectKey {
+			continue
+		}
+		shares = append(shares, *u.present(share, false))
+	}
+
+	slices.SortFunc(shares, func(a, b model.ShareLink) int {
+		return a.CreatedAt.Compare(b.CreatedAt)
+	})
+
+	return shares, nil
+}
+
+// GetShare returns a link with its access log.
+func (u *usecaseShare) GetShare(ctx context.Context, token string) (*model.ShareLink, error) {
+	share, err := u.getManagedShare(ctx, token)
+	if err != nil {
+		utils.ErrorLog("usecase", "GetShare getManagedShare", err)
+		return nil, err
+	}
+
+	return u.present(share, true), nil
+}
+
+// RevokeShare disables a link permanently. The record is kept for its
+// access log.
+func (u *usecaseShare) RevokeShare(ctx context.Context, token string) (*model.ShareLink, error) {
+	_, err := u.getManagedShare(ctx, token)
+	if err != nil {
+		utils.ErrorLog("usecase", "RevokeShare getManagedShare", err)
+		return nil, err
+	}
+
+	share, err := u.updateShare(ctx, token, func(share *model.ShareLink) {
+		if share.RevokedAt == nil {
+			now := time.Now().UTC()
+			share.RevokedAt = &now
+		}
+	})
+	if err != nil {
+		utils.ErrorLog("usecase", "RevokeShare updateShare", err)
+		return nil, err
+	}
+
+	return u.present(share, true), nil
+}
+
+// OpenShare checks a link and its password, counts the download and opens
+// the object. Every attempt is recorded in the access log.
+func (u *usecaseShare) OpenShare(ctx context.Context, token string, password string, access model.ShareAccess) (*model.ObjectStream, error) {
+	share, err := u.getShare(ctx, token)
+	if err != nil {
+		utils.ErrorLog("usecase", "OpenShare getShare", err)
+		return nil, err
+	}
+
+	if err = shareUnavailable(share); err != nil {
+		utils.ErrorLog("usecase", "OpenShare shareUnavailable", err)
+		u.logAccess(ctx, token, access, model.ShareResultUnavailable, 0)
+		return nil, err
+	}
+
+	// The password is checked before the download is counted; bcrypt is slow
+	// on purpose. Availability is checked again when it is counted.
+	if share.HasPassword && bcrypt.CompareHashAndPassword([]byte(share.PasswordHash), []byte(password)) != nil {
+		u.logAccess(ctx, token, access, model.ShareResultInvalidPassword, 0)
+		return nil, utils.ErrInvalidPassword
+	}
+
+	// Links are opened without a caller; the object is read in the tenant of
+	// the link, on behalf of the service.
+	objectCtx := model.WithIdentity(model.WithTenant(ctx, share.Tenant), nil)
+
+	metadata, err := u.repo.HeadMetadata(objectCtx, share.Key)
+	if err != nil {
+		utils.ErrorLog("usecase", "OpenShare Repository HeadMetadata", err)
+		u.logAccess(ctx, token, access, model.ShareResultError, 0)
+		return nil, err
+	}
+	if metadata[utils.MetaObjectID] != share.ObjectID {
+		err = fmt.Errorf("%w: the object was deleted and replaced", utils.ErrShareUnavailable)
+		utils.ErrorLog("usecase", "OpenShare", err)
+		u.logAccess(ctx, token, access, model.ShareResultUnavailable, 0)
+		return nil, err
+	}
+
+	var unavailable error
+	share, err = u.updateShare(ctx, token, func(share *model.ShareLink) {
+		unavailable = shareUnavailable(share)
+		if unavailable != nil {
+			appendShareAccess(share, access, model.ShareResultUnavailable)
+			return
+		}
+		share.Downloads++
+		appendShareAccess(share, access, model.ShareResultOK)
+	})
+	if err == nil {
+		err = unavailable
+	}
+	if err != nil {
+		utils.ErrorLog("usecase", "OpenShare updateShare", err)
+		return nil, err
+	}
+
+	object, err := u.uploads.DownloadFile(objectCtx, share.Key)
+	if err != nil {
+		utils.ErrorLog("usecase", "OpenShare DownloadFile", err)
+		// The download did not happen, so it is not counted.
+		u.logAccess(ctx, token, access, model.ShareResultError, -1)
+		return nil, err
+	}
+
+	return object, nil
+}
+
+// logAccess records a failed attempt and adjusts the download count by
+// downloads. Errors are only logged, so they do not hide the outcome.
+func (u *usecaseShare) logAccess(ctx context.Context, token string, access model.ShareAccess, result string, downloads int) {
+	_, err := u.updateShare(ctx, token, func(share *model.ShareLink) {
+		share.Downloads += downloads
+		appendShareAccess(share, access, result)
+	})
+	if err != nil {
+		utils.ErrorLog("usecase", "OpenShare logAccess", err)
+	}
+}
+
+// updateShare applies change to the share document; see updateDocument.
+func (u *usecaseShare) updateShare(ctx context.Context, token string, change func(share *model.ShareLink)) (*model.ShareLink, error) {
+	return updateDocument(sharesContext(ctx), u.repo, shareKey(token), func(share *model.ShareLink, exists bool) (documentUpdate, error) {
+		if !exists {
+			return documentUnchanged, fmt.Errorf("share link %s %w", token, utils.ErrNotFound)
+		}
+
+		change(share)
+		return documentPut, nil
+	})
+}
+
+// indexShare adds token to the index of objectKey.
+func (u *usecaseShare) indexShare(ctx context.Context, objectKey string, token string) error {
+	_, err := updateDocument(ctx, u.repo, shareIndexKey(objectKey), func(index *model.ShareIndex, _ bool) (documentUpdate, error) {
+		index.Key = objectKey
+		index.Tokens = append(index.Tokens, token)
+		return documentPut, nil
+	})
+	return err
+}
+
+// moveShares points the links to oldKey at newKey after a rename. The index
+// is moved first, so that links created on oldKey in the meantime are moved
+// too.
+func (u *usecaseUpload) moveShares(ctx context.Context, funcName string, oldKey string, newKey string) {
+	if oldKey == newKey {
+		return
+	}
+
+	err := moveDocument(ctx, u.repo, shareIndexKey(oldKey), shareIndexKey(newKey), func(index *model.ShareIndex, moved *model.ShareIndex) {
+		index.Key = newKey
+		for _, token := range moved.Tokens {
+			if !slices.Contains(index.Tokens, token) {
+				index.Tokens = append(index.Tokens, token)
+			}
+		}
+	})
+	if err != nil {
+		utils.ErrorLog("usecase", funcName+" moveShares moveDocument", err)
+		return
+	}
+
+	var index model.ShareIndex
+	err = u.repo.GetJSON(ctx, shareIndexKey(newKey), &index)
+	if errors.Is(err, utils.ErrNotFound) {
+		return
+	}
+	if err != nil {
+		utils.ErrorLog("usecase", funcName+" moveShares Repository GetJSON", err)
+		return
+	}
+
+	for _, token := range index.Tokens {
+		_, err = updateDocument(sharesContext(ctx), u.repo, shareKey(token), func(share *model.ShareLink, exists bool) (documentUpdate, error) {
+			if !exists || share.Key != oldKey {
+				return documentUnchanged, nil
+			}
+			share.Key = newKey
+			return documentPut, nil
+		})
+		if err != nil {
+			utils.ErrorLog("usecase", funcName+" moveShares", err)
+		}
+	}
+}
+
+// deleteShareIndex forgets the links to a deleted object. Their documents
+// are kept for their access logs; the links no longer open since the
+// object is gone, or replaced by another one.
+func (u *usecaseUpload) deleteShareIndex(ctx context.Context, funcName string, objectKey string) {
+	err := u.repo.DeleteFile(ctx, shareIndexKey(objectKey))
+	if err != nil && !errors.Is(err, utils.ErrNotFound) {
+		utils.ErrorLog("usecase", funcName+" deleteShareIndex", err)
+	}
+}
+
+// getManagedShare returns a link of the caller's tenant that the caller
+// created or whose object the caller owns.
+func (u *usecaseShare) getManagedShare(ctx context.Context, token string) (*model.ShareLink, error) {
+	share, err := u.getShare(ctx, token)
+	if err != nil {
+		return nil, err
+	}
+	if share.Tenant != model.TenantFromContext(ctx) {
+		return nil, fmt.Errorf("share link %s %w", token, utils.ErrNotFound)
+	}
+
+	identity := model.IdentityFromContext(ctx)
+	if identity != nil && share.CreatedBy != identity.Subject {
+		_, err = u.uploads.GetAccess(ctx, share.Key)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	return share, nil
+}
+
+func (u *usecaseShare) getShare(ctx context.Context, token string) (*model.ShareLink, error) {
+	tokenBytes, err := base64.RawURLEncoding.DecodeString(token)
+	if err != nil || len(tokenBytes) != shareTokenBytes {
+		return nil, fmt.Errorf("share link %s %w", token, utils.ErrNotFound)
+	}
+
+	var share model.ShareLink
+	err = u.repo.GetJSON(sharesContext(ctx), shareKey(token), &share)
+	if errors.Is(err, utils.ErrNotFound) {
+		return nil, fmt.Errorf("share link %s %w", token, utils.ErrNotFound)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	return &share, nil
+}
+
+// present prepares a link for a response: the password hash is removed and
+// the access log only kept when withAccesses is set.
+func (u *usecaseShare) present(share *model.ShareLink, withAccesses bool) *model.ShareLink {
+	presented := *share
+	presented.PasswordHash = ""
+	presented.Url = u.baseURL + share.Token
+	if !withAccesses {
+		presented.Accesses = nil
+	}
+	return &presented
+}
+
+func shareUnavailable(share *model.ShareLink) error {
+	switch {
+	case share.RevokedAt != nil:
+		return fmt.Errorf("%w: it was revoked", utils.ErrShareUnavailable)
+	case time.Now().After(share.ExpiresAt):
+		return fmt.Errorf("%w: it has expired", utils.ErrShareUnavailable)
+	case share.MaxDownloads > 0 && share.Downloads >= share.MaxDownloads:
+		return fmt.Errorf("%w: the download limit was reached", utils.ErrShareUnavailable)
+	}
+	return nil
+}
+
+func appendShareAccess(share *model.ShareLink, access model.ShareAccess, result string) {
+	access.Result = result
+	share.Accesses = append(share.Accesses, access)
+	if len(share.Accesses) > maxShareAccesses {
+		share.Accesses = share.Accesses[len(share.Accesses)-maxShareAccesses:]
+	}
+}
+
+// sharesContext scopes ctx to the bucket root, where share documents are
+// stored regardless of the tenant of the request.
+func sharesContext(ctx context.Context) context.Context {
+	return model.WithTenant(ctx, "")
+}
+
+func shareKey(token string) string {
+	return utils.SharesPrefix + token + ".json"
+}
+
+func shareIndexKey(objectKey string) string {
+	return utils.ShareIndexPrefix + url.PathEscape(objectKey) + ".json"
+}
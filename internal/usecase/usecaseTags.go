@@ -0,0 +1,86 @@
+package usecase
+
+import (
+	"context"
+	"github.com/adityaw24/go-aws-garasi/internal/model"
+	"github.com/adityaw24/go-aws-garasi/utils"
+)
+
+func (u *usecaseUpload) GetTags(ctx context.Context, objectKey string) (map[string]string, error) {
+	_, err := u.authorizeKey(ctx, objectKey, model.PermissionRead)
+	if err != nil {
+		utils.ErrorLog("usecase", "GetTags authorizeKey", err)
+		return nil, err
+	}
+
+	tags, err := u.repo.GetTags(ctx, objectKey)
+	if err != nil {
+		utils.ErrorLog("usecase", "GetTags Repository", err)
+		return nil, err
+	}
+
+	return tags, nil
+}
+
+func (u *usecaseUpload) SetTags(ctx context.Context, objectKey string, tags map[string]string) error {
+	err := utils.ValidateTags(tags)
+	if err != nil {
+		utils.ErrorLog("usecase", "SetTags ValidateTags", err)
+		return err
+	}
+
+	_, err = u.authorizeKey(ctx, objectKey, model.PermissionWrite)
+	if err != nil {
+		utils.ErrorLog("usecase", "SetTags authorizeKey", err)
+		return err
+	}
+
+	err = u.repo.PutTags(ctx, objectKey, tags)
+	if err != nil {
+		utils.ErrorLog("usecase", "SetTags Repository", err)
+		return err
+	}
+
+	return nil
+}
+
+func (u *usecaseUpload) DeleteTags(ctx context.Context, objectKey string) error {
+	_, err := u.authorizeKey(ctx, objectKey, model.PermissionWrite)
+	if err != nil {
+		utils.ErrorLog("usecase", "DeleteTags authorizeKey", err)
+		return err
+	}
+
+	err = u.repo.DeleteTags(ctx, objectKey)
+	if err != nil {
+		utils.ErrorLog("usecase", "DeleteTags Repository", err)
+		return err
+	}
+
+	return nil
+}
+
+// filterByTags keeps the objects that carry every tag in tags. Tags are not
+// part of the listing, so each object costs one GetObjectTagging call.
+func (u *usecaseUpload) filterByTags(ctx context.Context, objects []model.FileModel, tags map[string]string) ([]model.FileModel, error) {
+	filtered := []model.FileModel{}
+	for _, object := range objects {
+		objectTags, err := u.repo.GetTags(ctx, object.Key)
+		if err != nil {
+			return nil, err
+		}
+
+		matches := true
+		for k, v := range tags {
+			if objectTags[k] != v {
+				matches = false
+				break
+			}
+		}
+		if matches {
+			filtered = append(filtered, object)
+		}
+	}
+
+	return filtered, nil
+}
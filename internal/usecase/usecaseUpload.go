@@ -1,38 +1,75 @@
 package usecase
 
 import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
 	"errors"
+	"fmt"
 	"io"
+	"log"
 	"net/http"
-	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
 
+	"github.com/adityaw24/go-aws-garasi/configs"
 	"github.com/adityaw24/go-aws-garasi/internal/model"
 	"github.com/adityaw24/go-aws-garasi/internal/repo"
 	"github.com/adityaw24/go-aws-garasi/utils"
-	"github.com/gin-gonic/gin"
 	"github.com/google/uuid"
 )
 
+const maxKeyAttempts = 100
+
 type UsecaseUpload interface {
-	UploadFile(ctx *gin.Context, fileRequest *model.FileRequest, validMimeTypes []string) (listObjects []model.FileModel, err error)
-	PreviewFile(ctx *gin.Context, objectKey string) (string, error)
-	ListObjects(ctx *gin.Context) ([]model.FileModel, error)
-	UpdateFile(ctx *gin.Context, fileRequest *model.UpdateFileRequest, validMimeTypes []string) error
-	DeleteFile(ctx *gin.Context, fileRequest *model.DeleteFileRequest) error
-	UpdateObject(ctx *gin.Context, objectRequest *model.CopyObjectRequest) error
+	UploadFile(ctx context.Context, fileRequest *model.FileRequest, validMimeTypes []string) (*model.FileModel, error)
+	PreviewFile(ctx context.Context, objectKey string) (string, error)
+	ListObjects(ctx context.Context, filter *model.ListFilter) ([]model.FileModel, error)
+	UpdateFile(ctx context.Context, fileRequest *model.UpdateFileRequest, validMimeTypes []string) (*model.FileModel, error)
+	DeleteFile(ctx context.Context, fileRequest *model.DeleteFileRequest) error
+	UpdateObject(ctx context.Context, objectRequest *model.CopyObjectRequest) (*model.FileModel, error)
+	FindDuplicates(ctx context.Context, objectKey string, distance int) ([]model.DuplicateModel, error)
+	PublicFile(ctx context.Context, objectKey string, tenant string, signature string) ([]byte, string, error)
+	PresentObjects(ctx context.Context, objects []model.FileModel) ([]model.FileModel, error)
+	DetailFile(ctx context.Context, objectKey string) (*model.FileDetails, error)
+	DownloadFile(ctx context.Context, objectKey string) (*model.ObjectStream, error)
+	UpdateMetadata(ctx context.Context, objectKey string, metadataRequest *model.UpdateMetadataRequest) (*model.FileDetails, error)
+	GetTags(ctx context.Context, objectKey string) (map[string]string, error)
+	SetTags(ctx context.Context, objectKey string, tags map[string]string) error
+	DeleteTags(ctx context.Context, objectKey string) error
+	GetAccess(ctx context.Context, objectKey string) (*model.ObjectAccess, error)
+	GrantAccess(ctx context.Context, objectKey string, accessRequest *model.AccessRequest) (*model.ObjectAccess, error)
+	RevokeAccess(ctx context.Context, objectKey string, accessRequest *model.AccessRequest) (*model.ObjectAccess, error)
+	AuthorizeObject(ctx context.Context, objectKey string, permission string) error
+	FilterReadable(ctx context.Context, objects []model.FileModel) ([]model.FileModel, error)
 }
 
 type usecaseUpload struct {
-	repo repo.RepoUpload
+	repo      repo.RepoUpload
+	cfg       configs.Config
+	watermark *utils.Watermark
+	// publicCache holds rendered public variants; nil when disabled.
+	publicCache *utils.ImageCache
 }
 
-func NewUsecaseUpload(repo repo.RepoUpload) UsecaseUpload {
-	return &usecaseUpload{
-		repo: repo,
+// NewUsecaseUpload creates the upload usecase. watermark may be nil, in which
+// case PublicFile is disabled.
+func NewUsecaseUpload(repo repo.RepoUpload, cfg configs.Config, watermark *utils.Watermark) UsecaseUpload {
+	u := &usecaseUpload{
+		repo:      repo,
+		cfg:       cfg,
+		watermark: watermark,
+	}
+	if watermark != nil && cfg.WATERMARK_CACHE_SIZE > 0 {
+		u.publicCache = utils.NewImageCache(cfg.WATERMARK_CACHE_SIZE)
 	}
+	return u
 }
 
-func (u *usecaseUpload) UploadFile(ctx *gin.Context, fileRequest *model.FileRequest, validMimeTypes []string) (listObjects []model.FileModel, err error) {
+func (u *usecaseUpload) UploadFile(ctx context.Context, fileRequest *model.FileRequest, validMimeTypes []string) (*model.FileModel, error) {
 
 	file, err := fileRequest.File.Open()
 	if err != nil {
@@ -41,9 +78,9 @@ func (u *usecaseUpload) UploadFile(ctx *gin.Context, fileRequest *model.FileRequ
 	}
 	defer file.Close()
 
-	fileBytes, err := io.ReadAll(file)
+	fileBytes, fileHash, err := readContent(file)
 	if err != nil {
-		utils.ErrorLog("usecase", "UploadFile ReadAll", err)
+		utils.ErrorLog("usecase", "UploadFile readContent", err)
 		return nil, err
 	}
 
@@ -74,46 +111,133 @@ func (u *usecaseUpload) UploadFile(ctx *gin.Context, fileRequest *model.FileRequ
 		return nil, err
 	}
 
+	encrypt, err := u.envelopeRequired(fileRequest.Encrypt, nil)
+	if err != nil {
+		utils.ErrorLog("usecase", "UploadFile envelopeRequired", err)
+		return nil, err
+	}
+
 	fileUpload := utils.Upload{
 		Length:      fileRequest.File.Size,
 		ContentType: contentType,
-		Prefix:      fileRequest.Title + "_",
-		Ext:         filepath.Ext(fileRequest.File.Filename),
+		Ext:         utils.NormalizeExt(fileRequest.File.Filename, contentType),
+		Metadata:    imageMetadata("UploadFile", fileBytes),
 	}
+	fileUpload.Metadata[utils.MetaTitle] = utils.EncodeMetadataValue(fileRequest.Title)
+	fileUpload.Tags = fileRequest.Tags
+	setUploadedBy(ctx, fileUpload.Metadata)
+	setOwner(ctx, fileUpload.Metadata, "")
+	setObjectID(fileUpload.Metadata, nil)
 
-	key := uuid.New().String() + fileUpload.Ext
+	texts := &model.AccessibleText{}
+	err = setAccessibleText(texts, &fileRequest.AltText, &fileRequest.Caption, fileRequest.AltTexts, fileRequest.Captions)
+	if err != nil {
+		utils.ErrorLog("usecase", "UploadFile setAccessibleText", err)
+		return nil, err
+	}
 
-	err = u.repo.UploadFile(ctx, file, key, fileUpload, fileBytes)
+	err = u.setCustomMetadata(fileUpload.Metadata, fileRequest.Metadata)
 	if err != nil {
-		utils.ErrorLog("usecase", "UploadFile Repository", err)
+		utils.ErrorLog("usecase", "UploadFile setCustomMetadata", err)
 		return nil, err
 	}
 
-	objects, err := u.repo.ListObjects(ctx)
+	err = utils.ValidateNewMetadataSize(fileUpload.Metadata)
 	if err != nil {
-		utils.ErrorLog("usecase", "UploadFile Repository ListObjects", err)
+		utils.ErrorLog("usecase", "UploadFile ValidateNewMetadataSize", err)
 		return nil, err
 	}
 
-	// var (
-	// 	results []model.FileModel
-	// )
-	// for _, object := range objects {
-	// 	url, _ := u.repo.PreviewFile(ctx, *object.Key)
-	// 	result := model.FileModel{
-	// 		Key:   *object.Key,
-	// 		Title: fileRequest.Title,
-	// 		Url:   url,
-	// 	}
+	err = utils.ValidateTags(fileUpload.Tags)
+	if err != nil {
+		utils.ErrorLog("usecase", "UploadFile ValidateTags", err)
+		return nil, err
+	}
 
-	// 	results = append(results, result)
-	// }
+	err = u.rejectNearDuplicate(ctx, fileUpload.Metadata[utils.MetaPHash], "")
+	if err != nil {
+		utils.ErrorLog("usecase", "UploadFile rejectNearDuplicate", err)
+		return nil, err
+	}
 
-	return objects, nil
+	originalUpload, originalBytes := fileUpload, fileBytes
+	fileBytes, fileHash = u.optimizeImage("UploadFile", fileBytes, fileHash, &fileUpload)
+
+	// Encrypted objects are keyed by the hash of their ciphertext, which
+	// reveals nothing about the plaintext.
+	if encrypt {
+		fileBytes, fileHash, err = u.sealUpload(&fileUpload, fileBytes)
+		if err != nil {
+			utils.ErrorLog("usecase", "UploadFile sealUpload", err)
+			return nil, err
+		}
+	}
+
+	key, err := u.newObjectKey(ctx, utils.KeyParams{
+		Title: fileRequest.Title,
+		Ext:   fileUpload.Ext,
+		Hash:  fileHash,
+		Time:  time.Now(),
+	}, "")
+	if err != nil {
+		utils.ErrorLog("usecase", "UploadFile newObjectKey", err)
+		return nil, err
+	}
+
+	err = u.keepOriginal(ctx, key, &fileUpload, originalUpload, originalBytes)
+	if err != nil {
+		utils.ErrorLog("usecase", "UploadFile keepOriginal", err)
+		return nil, err
+	}
+
+	err = u.storeFile(ctx, key, fileUpload, fileBytes, fileHash)
+	if err != nil {
+		utils.ErrorLog("usecase", "UploadFile storeFile", err)
+		u.discardOriginal(ctx, "UploadFile", fileUpload.Metadata, nil)
+		return nil, err
+	}
+
+	err = u.putAccessibleText(ctx, key, texts)
+	if err != nil {
+		utils.ErrorLog("usecase", "UploadFile putAccessibleText", err)
+		return nil, err
+	}
+	u.setPHash(ctx, "UploadFile", key, fileUpload.Metadata[utils.MetaPHash])
+
+	fileModel, err := u.repo.GetFileModel(ctx, key)
+	if err != nil {
+		utils.ErrorLog("usecase", "UploadFile Repository GetFileModel", err)
+		return nil, err
+	}
+
+	objects, err := u.PresentObjects(ctx, []model.FileModel{*fileModel})
+	if err != nil {
+		utils.ErrorLog("usecase", "UploadFile PresentObjects", err)
+		return nil, err
+	}
+
+	return &objects[0], nil
 }
 
-func (u *usecaseUpload) PreviewFile(ctx *gin.Context, objectKey string) (string, error) {
-	presignedURL, err := u.repo.PreviewFile(ctx, objectKey)
+// PreviewFile returns a presigned URL of the object content, or its public
+// URL when it is published watermarked. Envelope encrypted objects have none,
+// since S3 only holds their ciphertext.
+func (u *usecaseUpload) PreviewFile(ctx context.Context, objectKey string) (string, error) {
+	metadata, err := u.authorizeKey(ctx, objectKey, model.PermissionRead)
+	if err != nil {
+		utils.ErrorLog("usecase", "PreviewFile authorizeKey", err)
+		return "", err
+	}
+
+	if metadata[utils.MetaEnvelopeKey] != "" {
+		return "", utils.ErrEncryptedObject
+	}
+
+	if u.published(objectKey, metadata[utils.MetaPHash], false) {
+		return u.publicURL(ctx, objectKey), nil
+	}
+
+	presignedURL, err := u.repo.PreviewFile(ctx, contentKeyOf(objectKey, metadata))
 	if err != nil {
 		utils.ErrorLog("usecase", "PreviewFile Repository", err)
 		return "", err
@@ -122,98 +246,882 @@ func (u *usecaseUpload) PreviewFile(ctx *gin.Context, objectKey string) (string,
 	return presignedURL, nil
 }
 
-func (u *usecaseUpload) ListObjects(ctx *gin.Context) ([]model.FileModel, error) {
+func (u *usecaseUpload) ListObjects(ctx context.Context, filter *model.ListFilter) ([]model.FileModel, error) {
 	objects, err := u.repo.ListObjects(ctx)
 	if err != nil {
 		utils.ErrorLog("usecase", "ListObjects Repository", err)
 		return nil, err
 	}
 
+	objects, err = u.filterReadable(ctx, objects)
+	if err != nil {
+		utils.ErrorLog("usecase", "ListObjects filterReadable", err)
+		return nil, err
+	}
+
+	if filter != nil && len(filter.Tags) > 0 {
+		objects, err = u.filterByTags(ctx, objects, filter.Tags)
+		if err != nil {
+			utils.ErrorLog("usecase", "ListObjects filterByTags", err)
+			return nil, err
+		}
+	}
+
+	objects, err = u.PresentObjects(ctx, objects)
+	if err != nil {
+		utils.ErrorLog("usecase", "ListObjects PresentObjects", err)
+		return nil, err
+	}
+
+	if filter != nil && filter.MissingAltText {
+		objects = filterMissingAltText(objects, filter.Language)
+	}
+
 	return objects, nil
 }
 
-func (u *usecaseUpload) UpdateFile(ctx *gin.Context, fileRequest *model.UpdateFileRequest, validMimeTypes []string) error {
+func (u *usecaseUpload) UpdateFile(ctx context.Context, fileRequest *model.UpdateFileRequest, validMimeTypes []string) (*model.FileModel, error) {
 	file, err := fileRequest.File.Open()
 	if err != nil {
 		utils.ErrorLog("usecase", "UpdateFile Open Fileheader", err)
-		return err
+		return nil, err
 	}
 	defer file.Close()
 
-	fileBytes, err := io.ReadAll(file)
+	fileBytes, fileHash, err := readContent(file)
 	if err != nil {
-		utils.ErrorLog("usecase", "UpdateFile ReadAll", err)
-		return err
+		utils.ErrorLog("usecase", "UpdateFile readContent", err)
+		return nil, err
 	}
 
 	_, err = file.Seek(0, 0)
 	if err != nil {
 		utils.ErrorLog("usecase", "UpdateFile Seek Fileheader", err)
-		return err
+		return nil, err
 	}
 
 	buff := make([]byte, 512)
 	_, err = file.Read(buff)
 	if err != nil {
 		utils.ErrorLog("usecase", "UpdateFile Read Fileheader", err)
-		return err
+		return nil, err
 	}
 
 	contentType := http.DetectContentType(buff)
 	err = utils.ValidateContentType(contentType, validMimeTypes)
 	if err != nil {
 		utils.ErrorLog("usecase", "UpdateFile ValidateContentType", err)
-		return err
+		return nil, err
 	}
 
 	fileUpload := utils.Upload{
 		Length:      fileRequest.File.Size,
 		ContentType: contentType,
-		Prefix:      fileRequest.Title + "_",
-		Ext:         filepath.Ext(fileRequest.File.Filename),
+		Ext:         utils.NormalizeExt(fileRequest.File.Filename, contentType),
+		Metadata:    imageMetadata("UpdateFile", fileBytes),
 	}
+	fileUpload.Metadata[utils.MetaTitle] = utils.EncodeMetadataValue(fileRequest.Title)
+	fileUpload.Tags = fileRequest.Tags
+	setUploadedBy(ctx, fileUpload.Metadata)
 
-	newKey := uuid.New().String() + fileUpload.Ext
+	oldMetadata, err := u.repo.HeadMetadata(ctx, fileRequest.Key)
+	if err != nil {
+		utils.ErrorLog("usecase", "UpdateFile Repository HeadMetadata", err)
+		return nil, err
+	}
 
-	err = u.repo.UpdateFile(ctx, fileRequest.Key, file, newKey, fileUpload, fileBytes)
+	err = u.authorize(ctx, fileRequest.Key, oldMetadata, model.PermissionWrite)
 	if err != nil {
-		utils.ErrorLog("usecase", "UpdateFile Repository", err)
-		return err
+		utils.ErrorLog("usecase", "UpdateFile authorize", err)
+		return nil, err
 	}
+	setOwner(ctx, fileUpload.Metadata, oldMetadata[utils.MetaOwner])
+	setObjectID(fileUpload.Metadata, oldMetadata)
 
-	return nil
+	encrypt, err := u.envelopeRequired(fileRequest.Encrypt, oldMetadata)
+	if err != nil {
+		utils.ErrorLog("usecase", "UpdateFile envelopeRequired", err)
+		return nil, err
+	}
+
+	// Without new custom metadata or tags the replacement keeps those of the
+	// old object. Alt texts and captions are kept and merged with new ones.
+	texts, err := u.accessibleTextOf(ctx, fileRequest.Key, oldMetadata)
+	if err != nil {
+		utils.ErrorLog("usecase", "UpdateFile accessibleTextOf", err)
+		return nil, err
+	}
+	err = setAccessibleText(texts, nonEmpty(fileRequest.AltText), nonEmpty(fileRequest.Caption), fileRequest.AltTexts, fileRequest.Captions)
+	if err != nil {
+		utils.ErrorLog("usecase", "UpdateFile setAccessibleText", err)
+		return nil, err
+	}
+
+	custom := fileRequest.Metadata
+	if custom == nil {
+		custom = utils.CustomMetadata(oldMetadata)
+	}
+	err = u.setCustomMetadata(fileUpload.Metadata, custom)
+	if err != nil {
+		utils.ErrorLog("usecase", "UpdateFile setCustomMetadata", err)
+		return nil, err
+	}
+
+	err = utils.ValidateNewMetadataSize(fileUpload.Metadata)
+	if err != nil {
+		utils.ErrorLog("usecase", "UpdateFile ValidateNewMetadataSize", err)
+		return nil, err
+	}
+
+	if fileUpload.Tags == nil {
+		fileUpload.Tags, err = u.repo.GetTags(ctx, fileRequest.Key)
+		if err != nil {
+			utils.ErrorLog("usecase", "UpdateFile Repository GetTags", err)
+			return nil, err
+		}
+	}
+
+	err = utils.ValidateTags(fileUpload.Tags)
+	if err != nil {
+		utils.ErrorLog("usecase", "UpdateFile ValidateTags", err)
+		return nil, err
+	}
+
+	err = u.rejectNearDuplicate(ctx, fileUpload.Metadata[utils.MetaPHash], fileRequest.Key)
+	if err != nil {
+		utils.ErrorLog("usecase", "UpdateFile rejectNearDuplicate", err)
+		return nil, err
+	}
+
+	originalUpload, originalBytes := fileUpload, fileBytes
+	fileBytes, fileHash = u.optimizeImage("UpdateFile", fileBytes, fileHash, &fileUpload)
+
+	// Encrypted objects are keyed by the hash of their ciphertext, which
+	// reveals nothing about the plaintext.
+	if encrypt {
+		fileBytes, fileHash, err = u.sealUpload(&fileUpload, fileBytes)
+		if err != nil {
+			utils.ErrorLog("usecase", "UpdateFile sealUpload", err)
+			return nil, err
+		}
+	}
+
+	newKey, err := u.newObjectKey(ctx, utils.KeyParams{
+		Title: fileRequest.Title,
+		Ext:   fileUpload.Ext,
+		Hash:  fileHash,
+		Time:  time.Now(),
+	}, fileRequest.Key)
+	if err != nil {
+		utils.ErrorLog("usecase", "UpdateFile newObjectKey", err)
+		return nil, err
+	}
+
+	err = u.keepOriginal(ctx, newKey, &fileUpload, originalUpload, originalBytes)
+	if err != nil {
+		utils.ErrorLog("usecase", "UpdateFile keepOriginal", err)
+		return nil, err
+	}
+
+	if u.cfg.DEDUP_ENABLED || oldMetadata[utils.MetaContentRef] != "" {
+		err = u.storeFile(ctx, newKey, fileUpload, fileBytes, fileHash)
+		if err != nil {
+			utils.ErrorLog("usecase", "UpdateFile storeFile", err)
+			u.discardOriginal(ctx, "UpdateFile", fileUpload.Metadata, oldMetadata)
+			return nil, err
+		}
+
+		// A replacement stored under the same key has overwritten the old
+		// object, and holds its content reference if the content is the same.
+		if newKey != fileRequest.Key {
+			err = u.repo.DeleteFile(ctx, fileRequest.Key)
+			if err != nil {
+				utils.ErrorLog("usecase", "UpdateFile Repository DeleteFile", err)
+				return nil, err
+			}
+		}
+
+		if newKey != fileRequest.Key || oldMetadata[utils.MetaContentHash] != fileHash {
+			err = u.releaseContent(ctx, fileRequest.Key, oldMetadata)
+			if err != nil {
+				utils.ErrorLog("usecase", "UpdateFile releaseContent", err)
+			}
+		}
+	} else {
+		err = u.repo.UpdateFile(ctx, fileRequest.Key, bytes.NewReader(fileBytes), newKey, fileUpload, fileBytes)
+		if err != nil {
+			utils.ErrorLog("usecase", "UpdateFile Repository", err)
+			u.discardOriginal(ctx, "UpdateFile", fileUpload.Metadata, oldMetadata)
+			return nil, err
+		}
+	}
+
+	if oldMetadata[utils.MetaOriginalKey] != fileUpload.Metadata[utils.MetaOriginalKey] {
+		u.deleteOriginal(ctx, "UpdateFile", oldMetadata)
+	}
+	u.moveReferences(ctx, "UpdateFile", fileRequest.Key, newKey)
+	u.moveAccess(ctx, "UpdateFile", fileRequest.Key, newKey)
+	u.moveAlbumKeys(ctx, "UpdateFile", fileRequest.Key, newKey)
+	u.moveShares(ctx, "UpdateFile", fileRequest.Key, newKey)
+
+	err = u.putAccessibleText(ctx, newKey, texts)
+	if err != nil {
+		utils.ErrorLog("usecase", "UpdateFile putAccessibleText", err)
+		return nil, err
+	}
+	if newKey != fileRequest.Key {
+		u.deleteAccessibleText(ctx, "UpdateFile", fileRequest.Key)
+		u.deletePHash(ctx, "UpdateFile", fileRequest.Key)
+	}
+	u.setPHash(ctx, "UpdateFile", newKey, fileUpload.Metadata[utils.MetaPHash])
+
+	fileModel, err := u.repo.GetFileModel(ctx, newKey)
+	if err != nil {
+		utils.ErrorLog("usecase", "UpdateFile Repository GetFileModel", err)
+		return nil, err
+	}
+
+	objects, err := u.PresentObjects(ctx, []model.FileModel{*fileModel})
+	if err != nil {
+		utils.ErrorLog("usecase", "UpdateFile PresentObjects", err)
+		return nil, err
+	}
+
+	return &objects[0], nil
 }
 
-func (u *usecaseUpload) DeleteFile(ctx *gin.Context, fileRequest *model.DeleteFileRequest) error {
+func (u *usecaseUpload) DeleteFile(ctx context.Context, fileRequest *model.DeleteFileRequest) error {
 	if fileRequest.Key == "" {
 		utils.ErrorLog("usecase", "DeleteFile", errors.New("key is required"))
 		return errors.New("key is required")
 	}
 
-	err := u.repo.DeleteFile(ctx, fileRequest.Key)
+	metadata, err := u.repo.HeadMetadata(ctx, fileRequest.Key)
+	if err != nil {
+		utils.ErrorLog("usecase", "DeleteFile Repository HeadMetadata", err)
+		return err
+	}
+
+	err = u.authorize(ctx, fileRequest.Key, metadata, model.PermissionOwner)
 	if err != nil {
-		utils.ErrorLog("usecase", "DeleteFile Repository", err)
+		utils.ErrorLog("usecase", "DeleteFile authorize", err)
 		return err
 	}
 
+	err = u.deleteUnreferenced(ctx, fileRequest.Key)
+	if err != nil {
+		utils.ErrorLog("usecase", "DeleteFile deleteUnreferenced", err)
+		return err
+	}
+
+	err = u.releaseContent(ctx, fileRequest.Key, metadata)
+	if err != nil {
+		utils.ErrorLog("usecase", "DeleteFile releaseContent", err)
+		return err
+	}
+
+	u.deleteOriginal(ctx, "DeleteFile", metadata)
+	u.deleteAccess(ctx, "DeleteFile", fileRequest.Key)
+	u.deleteAlbumKeys(ctx, "DeleteFile", fileRequest.Key)
+	u.deleteShareIndex(ctx, "DeleteFile", fileRequest.Key)
+	u.deletePHash(ctx, "DeleteFile", fileRequest.Key)
+	u.deleteAccessibleText(ctx, "DeleteFile", fileRequest.Key)
+
 	return nil
 }
 
-func (u *usecaseUpload) UpdateObject(ctx *gin.Context, objectRequest *model.CopyObjectRequest) error {
-	err := u.repo.CopyObject(ctx, &model.CopyObjectRequest{
-		OldKey: objectRequest.OldKey,
-		NewKey: objectRequest.NewKey + filepath.Ext(objectRequest.OldKey),
+func (u *usecaseUpload) UpdateObject(ctx context.Context, objectRequest *model.CopyObjectRequest) (*model.FileModel, error) {
+	details, err := u.repo.StatObject(ctx, objectRequest.OldKey)
+	if err != nil {
+		utils.ErrorLog("usecase", "UpdateObject Repository StatObject", err)
+		return nil, err
+	}
+	metadata := details.RawMetadata
+
+	err = u.authorize(ctx, objectRequest.OldKey, metadata, model.PermissionWrite)
+	if err != nil {
+		utils.ErrorLog("usecase", "UpdateObject authorize", err)
+		return nil, err
+	}
+
+	hash := metadata[utils.MetaContentHash]
+	if hash == "" {
+		hash = checksumHex(details.ChecksumSHA256)
+	}
+
+	newKey, err := u.newObjectKey(ctx, utils.KeyParams{
+		Title: objectRequest.NewKey,
+		Ext:   utils.NormalizeExt(objectRequest.OldKey, details.ContentType),
+		Hash:  hash,
+		Time:  time.Now(),
+	}, objectRequest.OldKey)
+	if err != nil {
+		utils.ErrorLog("usecase", "UpdateObject newObjectKey", err)
+		return nil, err
+	}
+
+	newMetadata := make(map[string]string, len(metadata)+1)
+	for k, v := range metadata {
+		newMetadata[k] = v
+	}
+	newMetadata[utils.MetaTitle] = utils.EncodeMetadataValue(objectRequest.NewKey)
+
+	err = u.repo.CopyObject(ctx, &model.CopyObjectRequest{
+		OldKey:             objectRequest.OldKey,
+		NewKey:             newKey,
+		Metadata:           newMetadata,
+		ContentType:        details.ContentType,
+		CacheControl:       details.CacheControl,
+		ContentDisposition: details.ContentDisposition,
 	})
 	if err != nil {
 		utils.ErrorLog("usecase", "UpdateObject Repository", err)
+		return nil, err
+	}
+
+	// A title that renders to the same key only replaces the metadata of the
+	// object in place.
+	if newKey != objectRequest.OldKey {
+		err = u.moveReference(ctx, objectRequest.OldKey, newKey, metadata)
+		if err != nil {
+			utils.ErrorLog("usecase", "UpdateObject moveReference", err)
+			return nil, err
+		}
+
+		err = u.repo.DeleteFile(ctx, objectRequest.OldKey)
+		if err != nil {
+			utils.ErrorLog("usecase", "UpdateObject DeleteFile", err)
+			return nil, err
+		}
+
+		u.moveReferences(ctx, "UpdateObject", objectRequest.OldKey, newKey)
+		u.moveAccess(ctx, "UpdateObject", objectRequest.OldKey, newKey)
+		u.moveAccessibleText(ctx, "UpdateObject", objectRequest.OldKey, newKey)
+		u.moveAlbumKeys(ctx, "UpdateObject", objectRequest.OldKey, newKey)
+		u.moveShares(ctx, "UpdateObject", objectRequest.OldKey, newKey)
+		u.movePHash(ctx, "UpdateObject", objectRequest.OldKey, newKey)
+	}
+
+	fileModel, err := u.repo.GetFileModel(ctx, newKey)
+	if err != nil {
+		utils.ErrorLog("usecase", "UpdateObject Repository GetFileModel", err)
+		return nil, err
+	}
+
+	objects, err := u.PresentObjects(ctx, []model.FileModel{*fileModel})
+	if err != nil {
+		utils.ErrorLog("usecase", "UpdateObject PresentObjects", err)
+		return nil, err
+	}
+
+	return &objects[0], nil
+}
+
+func (u *usecaseUpload) FindDuplicates(ctx context.Context, objectKey string, distance int) ([]model.DuplicateModel, error) {
+	if distance < 0 {
+		distance = u.cfg.DUPLICATE_DISTANCE
+	}
+
+	metadata, err := u.authorizeKey(ctx, objectKey, model.PermissionRead)
+	if err != nil {
+		utils.ErrorLog("usecase", "FindDuplicates authorizeKey", err)
+		return nil, err
+	}
+
+	hash, err := utils.ParsePerceptualHash(metadata[utils.MetaPHash])
+	if err != nil {
+		utils.ErrorLog("usecase", "FindDuplicates ParsePerceptualHash", err)
+		return nil, utils.ErrNoPerceptualHash
+	}
+
+	duplicates, err := u.nearDuplicates(ctx, hash, distance, objectKey)
+	if err != nil {
+		utils.ErrorLog("usecase", "FindDuplicates nearDuplicates", err)
+		return nil, err
+	}
+
+	duplicates, err = u.filterReadableDuplicates(ctx, duplicates)
+	if err != nil {
+		utils.ErrorLog("usecase", "FindDuplicates filterReadableDuplicates", err)
+		return nil, err
+	}
+
+	objects := make([]model.FileModel, len(duplicates))
+	for i := range duplicates {
+		objects[i] = duplicates[i].FileModel
+	}
+	objects, err = u.PresentObjects(ctx, objects)
+	if err != nil {
+		utils.ErrorLog("usecase", "FindDuplicates PresentObjects", err)
+		return nil, err
+	}
+	for i := range duplicates {
+		duplicates[i].FileModel = objects[i]
+	}
+
+	return duplicates, nil
+}
+
+// newObjectKey renders a key with the configured KEY_TEMPLATE for the object
+// currently stored under currentKey, which is empty for new uploads, and
+// checks it with utils.ValidateKey like keys received from clients. Templates
+// without {uuid} get a collision suffix until the key is free, except content
+// addressed ones: their key may only be reused by currentKey itself, and is
+// otherwise rejected with utils.ErrDuplicateContent.
+func (u *usecaseUpload) newObjectKey(ctx context.Context, params utils.KeyParams, currentKey string) (string, error) {
+	for attempt := 1; attempt <= maxKeyAttempts; attempt++ {
+		key := u.cfg.KEY_TEMPLATE.Render(params, attempt)
+		err := utils.ValidateKey(key)
+		if err != nil {
+			return "", fmt.Errorf("generated key %q: %w", key, err)
+		}
+		if u.cfg.KEY_TEMPLATE.Unique() || key == currentKey {
+			return key, nil
+		}
+
+		_, err = u.repo.HeadMetadata(ctx, key)
+		if errors.Is(err, utils.ErrNotFound) {
+			return key, nil
+		}
+		if err == nil && u.cfg.KEY_TEMPLATE.ContentAddressed() {
+			return "", utils.ErrDuplicateContent
+		}
+		if err != nil {
+			return "", err
+		}
+	}
+
+	return "", fmt.Errorf("no free key found for %q after %d attempts", params.Title, maxKeyAttempts)
+}
+
+// storeFile uploads fileBytes under key, through the content-addressed store
+// when DEDUP_ENABLED is set and the upload is not envelope encrypted. hash is
+// the SHA-256 of fileBytes, as returned by readContent or optimizeImage.
+func (u *usecaseUpload) storeFile(ctx context.Context, key string, attach utils.Upload, fileBytes []byte, hash string) error {
+	if u.cfg.DEDUP_ENABLED && attach.Metadata[utils.MetaEnvelopeKey] == "" {
+		return u.storeDeduplicated(ctx, key, attach, fileBytes, hash)
+	}
+
+	return u.repo.UploadFile(ctx, bytes.NewReader(fileBytes), key, attach, fileBytes)
+}
+
+// optimizeImage applies the OPTIMIZE_* policy to an upload. When the
+// re-encoded image is smaller it is returned with its hash and attach is
+// updated to describe it, otherwise fileBytes and hash are returned
+// unchanged.
+func (u *usecaseUpload) optimizeImage(funcName string, fileBytes []byte, hash string, attach *utils.Upload) ([]byte, string) {
+	if !u.cfg.OPTIMIZE_ENABLED {
+		return fileBytes, hash
+	}
+
+	img, format, err := utils.DecodeImage(fileBytes)
+	if err != nil {
+		utils.ErrorLog("usecase", funcName+" optimizeImage DecodeImage", err)
+		return fileBytes, hash
+	}
+
+	optimized, err := utils.OptimizeImage(img, format, utils.OptimizePolicy{
+		JPEGQuality: u.cfg.OPTIMIZE_JPEG_QUALITY,
+		Convert:     u.cfg.OPTIMIZE_CONVERT,
+	})
+	if err != nil {
+		utils.ErrorLog("usecase", funcName+" optimizeImage OptimizeImage", err)
+		return fileBytes, hash
+	}
+
+	if len(optimized.Data) >= len(fileBytes) {
+		return fileBytes, hash
+	}
+
+	log.Printf("Optimized %s image from %d to %d bytes (%s)\n",
+		format, len(fileBytes), len(optimized.Data), optimized.Format)
+
+	metadata := make(map[string]string, len(attach.Metadata)+2)
+	for k, v := range attach.Metadata {
+		metadata[k] = v
+	}
+	metadata[utils.MetaOriginalSize] = strconv.Itoa(len(fileBytes))
+	metadata[utils.MetaOriginalContentType] = attach.ContentType
+
+	attach.Length = int64(len(optimized.Data))
+	attach.ContentType = optimized.ContentType
+	attach.Ext = optimized.Ext
+	attach.Metadata = metadata
+
+	return optimized.Data, contentHash(optimized.Data)
+}
+
+// keepOriginal stores the unoptimized upload under utils.OriginalsPrefix when
+// OPTIMIZE_KEEP_ORIGINAL is set and the image was actually optimized. It is
+// encrypted with its own data key when attach is envelope encrypted.
+func (u *usecaseUpload) keepOriginal(ctx context.Context, key string, attach *utils.Upload, original utils.Upload, originalBytes []byte) error {
+	if !u.cfg.OPTIMIZE_KEEP_ORIGINAL || attach.Metadata[utils.MetaOriginalSize] == "" {
+		return nil
+	}
+
+	original.Metadata = nil
+	originalKey := utils.OriginalsPrefix + strings.TrimSuffix(key, attach.Ext) + original.Ext
+
+	if attach.Metadata[utils.MetaEnvelopeKey] != "" {
+		var err error
+		originalBytes, _, err = u.sealUpload(&original, originalBytes)
+		if err != nil {
+			return err
+		}
+	}
+
+	err := u.repo.UploadFile(ctx, bytes.NewReader(originalBytes), originalKey, original, originalBytes)
+	if err != nil {
 		return err
 	}
 
-	err = u.repo.DeleteFile(ctx, objectRequest.OldKey)
+	attach.Metadata[utils.MetaOriginalKey] = originalKey
+	return nil
+}
+
+func (u *usecaseUpload) deleteOriginal(ctx context.Context, funcName string, metadata map[string]string) {
+	originalKey := metadata[utils.MetaOriginalKey]
+	if originalKey == "" {
+		return
+	}
+
+	err := u.repo.DeleteFile(ctx, originalKey)
+	if err != nil {
+		utils.ErrorLog("usecase", funcName+" deleteOriginal", err)
+	}
+}
+
+// discardOriginal deletes the original kept by keepOriginal for an upload
+// that could not be stored, unless it is the one oldMetadata, the metadata of
+// the object being replaced, still refers to.
+func (u *usecaseUpload) discardOriginal(ctx context.Context, funcName string, metadata map[string]string, oldMetadata map[string]string) {
+	if metadata[utils.MetaOriginalKey] == oldMetadata[utils.MetaOriginalKey] {
+		return
+	}
+
+	u.deleteOriginal(ctx, funcName, metadata)
+}
+
+func (u *usecaseUpload) DetailFile(ctx context.Context, objectKey string) (*model.FileDetails, error) {
+	contentKey, err := u.resolveKey(ctx, objectKey)
+	if err != nil {
+		utils.ErrorLog("usecase", "DetailFile resolveKey", err)
+		return nil, err
+	}
+
+	details, err := u.repo.StatObject(ctx, objectKey)
+	if err != nil {
+		utils.ErrorLog("usecase", "DetailFile Repository StatObject", err)
+		return nil, err
+	}
+
+	details.Tags, err = u.repo.GetTags(ctx, objectKey)
+	if err != nil {
+		utils.ErrorLog("usecase", "DetailFile Repository GetTags", err)
+		return nil, err
+	}
+
+	texts, err := u.accessibleTextOf(ctx, objectKey, details.RawMetadata)
+	if err != nil {
+		utils.ErrorLog("usecase", "DetailFile accessibleTextOf", err)
+		return nil, err
+	}
+	applyAccessibleText(&details.FileModel, texts)
+
+	if contentKey != objectKey {
+		content, err := u.repo.StatObject(ctx, contentKey)
+		if err != nil {
+			utils.ErrorLog("usecase", "DetailFile Repository StatObject content", err)
+			return nil, err
+		}
+		details.ContentType = content.ContentType
+		details.ETag = content.ETag
+		details.ChecksumSHA256 = content.ChecksumSHA256
+		details.ChecksumCRC32C = content.ChecksumCRC32C
+		details.Encryption = content.Encryption
+	}
+
+	return details, nil
+}
+
+// DownloadFile streams an object through the service, decrypting envelope
+// encrypted objects; see openObject.
+func (u *usecaseUpload) DownloadFile(ctx context.Context, objectKey string) (*model.ObjectStream, error) {
+	contentKey, err := u.resolveKey(ctx, objectKey)
+	if err != nil {
+		utils.ErrorLog("usecase", "DownloadFile resolveKey", err)
+		return nil, err
+	}
+
+	object, err := u.openObject(ctx, contentKey)
+	if err != nil {
+		utils.ErrorLog("usecase", "DownloadFile openObject", err)
+		return nil, err
+	}
+
+	return object, nil
+}
+
+// openObject reads the object stored under contentKey. The returned body
+// verifies the checksums recorded at upload time and fails with
+// utils.ErrChecksumMismatch at the end of the stream if they differ. Envelope
+// encrypted objects are decrypted after verification; their checksums cover
+// the ciphertext, so they are left out of the returned metadata.
+func (u *usecaseUpload) openObject(ctx context.Context, contentKey string) (*model.ObjectStream, error) {
+	object, err := u.repo.GetObject(ctx, contentKey)
+	if err != nil {
+		return nil, err
+	}
+
+	var body io.Reader = utils.NewVerifyingReader(object.Body, utils.Checksums{
+		SHA256: object.Metadata[utils.MetaChecksumSHA256],
+		CRC32C: object.Metadata[utils.MetaChecksumCRC32C],
+	})
+
+	if object.Metadata[utils.MetaEnvelopeKey] != "" {
+		body, err = u.openEnvelope(body, object.Metadata)
+		if err != nil {
+			object.Body.Close()
+			return nil, err
+		}
+		object.ContentLength, _ = strconv.ParseInt(object.Metadata[utils.MetaEnvelopeSize], 10, 64)
+		delete(object.Metadata, utils.MetaChecksumSHA256)
+		delete(object.Metadata, utils.MetaChecksumCRC32C)
+	}
+
+	object.Body = verifiedBody{
+		Reader: body,
+		Closer: object.Body,
+	}
+
+	return object, nil
+}
+
+type verifiedBody struct {
+	io.Reader
+	io.Closer
+}
+
+// UpdateMetadata rewrites the metadata of an object in place with a
+// self-copy, so its content and key do not change.
+func (u *usecaseUpload) UpdateMetadata(ctx context.Context, objectKey string, metadataRequest *model.UpdateMetadataRequest) (*model.FileDetails, error) {
+	details, err := u.repo.StatObject(ctx, objectKey)
+	if err != nil {
+		utils.ErrorLog("usecase", "UpdateMetadata Repository StatObject", err)
+		return nil, err
+	}
+
+	err = u.authorize(ctx, objectKey, details.RawMetadata, model.PermissionWrite)
+	if err != nil {
+		utils.ErrorLog("usecase", "UpdateMetadata authorize", err)
+		return nil, err
+	}
+
+	metadata := make(map[string]string, len(details.RawMetadata))
+	for k, v := range details.RawMetadata {
+		metadata[k] = v
+	}
+
+	if metadataRequest.Title != nil {
+		metadata[utils.MetaTitle] = utils.EncodeMetadataValue(*metadataRequest.Title)
+	}
+
+	// Texts still stored in the metadata move to the text document.
+	utils.RemoveAccessibleText(metadata)
+
+	if metadataRequest.Metadata != nil {
+		err = u.setCustomMetadata(metadata, metadataRequest.Metadata)
+		if err != nil {
+			utils.ErrorLog("usecase", "UpdateMetadata setCustomMetadata", err)
+			return nil, err
+		}
+	}
+
+	err = utils.ValidateMetadataSize(metadata)
+	if err != nil {
+		utils.ErrorLog("usecase", "UpdateMetadata ValidateMetadataSize", err)
+		return nil, err
+	}
+
+	if metadataRequest.Tags != nil {
+		err = utils.ValidateTags(metadataRequest.Tags)
+		if err != nil {
+			utils.ErrorLog("usecase", "UpdateMetadata ValidateTags", err)
+			return nil, err
+		}
+	}
+
+	copyRequest := &model.CopyObjectRequest{
+		OldKey:             objectKey,
+		NewKey:             objectKey,
+		Metadata:           metadata,
+		ContentType:        details.ContentType,
+		CacheControl:       details.CacheControl,
+		ContentDisposition: details.ContentDisposition,
+		Tags:               metadataRequest.Tags,
+	}
+	if metadataRequest.CacheControl != nil {
+		copyRequest.CacheControl = *metadataRequest.CacheControl
+	}
+	if metadataRequest.ContentDisposition != nil {
+		copyRequest.ContentDisposition = *metadataRequest.ContentDisposition
+	}
+
+	// The text changes are checked before the copy but only stored once it
+	// succeeded, so that a failed update changes neither the object nor its
+	// texts.
+	texts, err := u.accessibleTextOf(ctx, objectKey, details.RawMetadata)
+	if err != nil {
+		utils.ErrorLog("usecase", "UpdateMetadata accessibleTextOf", err)
+		return nil, err
+	}
+	err = setAccessibleText(texts, metadataRequest.AltText, metadataRequest.Caption, metadataRequest.AltTexts, metadataRequest.Captions)
+	if err != nil {
+		utils.ErrorLog("usecase", "UpdateMetadata setAccessibleText", err)
+		return nil, err
+	}
+
+	err = u.repo.CopyObject(ctx, copyRequest)
+	if err != nil {
+		utils.ErrorLog("usecase", "UpdateMetadata Repository CopyObject", err)
+		return nil, err
+	}
+
+	err = u.updateAccessibleText(ctx, objectKey, details.RawMetadata, metadataRequest.AltText, metadataRequest.Caption, metadataRequest.AltTexts, metadataRequest.Captions)
+	if err != nil {
+		utils.ErrorLog("usecase", "UpdateMetadata updateAccessibleText", err)
+		return nil, err
+	}
+
+	return u.DetailFile(ctx, objectKey)
+}
+
+// setCustomMetadata validates custom against the METADATA_SCHEMA and stores
+// it in metadata, which must stay within the S3 size limit.
+func (u *usecaseUpload) setCustomMetadata(metadata map[string]string, custom map[string]string) error {
+	err := u.cfg.METADATA_SCHEMA.Validate(custom)
 	if err != nil {
-		utils.ErrorLog("usecase", "UpdateObject DeleteFile", err)
 		return err
 	}
 
+	utils.SetCustomMetadata(metadata, custom)
+
+	return nil
+}
+
+// filterMissingAltText keeps the objects without alt text. With a language
+// only the variant for that language counts.
+func filterMissingAltText(objects []model.FileModel, lang string) []model.FileModel {
+	filtered := []model.FileModel{}
+	for _, object := range objects {
+		altText := object.AltText
+		if lang != "" {
+			altText = object.AltTexts[lang]
+		}
+		if altText == "" {
+			filtered = append(filtered, object)
+		}
+	}
+	return filtered
+}
+
+// setUploadedBy records the authenticated caller as the uploader.
+func setUploadedBy(ctx context.Context, metadata map[string]string) {
+	if identity := model.IdentityFromContext(ctx); identity != nil {
+		metadata[utils.MetaUploadedBy] = utils.EncodeMetadataValue(identity.Subject)
+	}
+}
+
+// setObjectID gives a new object an identifier, or keeps that of
+// oldMetadata for a replacement. Replacements of objects stored before
+// identifiers existed keep having none, so that their links keep working.
+func setObjectID(metadata map[string]string, oldMetadata map[string]string) {
+	if oldMetadata == nil {
+		metadata[utils.MetaObjectID] = uuid.New().String()
+		return
+	}
+	if objectID := oldMetadata[utils.MetaObjectID]; objectID != "" {
+		metadata[utils.MetaObjectID] = objectID
+	}
+}
+
+func nonEmpty(value string) *string {
+	if value == "" {
+		return nil
+	}
+	return &value
+}
+
+// rejectNearDuplicate enforces the DUPLICATE_POLICY for a newly computed
+// perceptual hash. excludeKey is the object being replaced, if any.
+func (u *usecaseUpload) rejectNearDuplicate(ctx context.Context, phash string, excludeKey string) error {
+	if u.cfg.DUPLICATE_POLICY != configs.DuplicatePolicyReject || phash == "" {
+		return nil
+	}
+
+	hash, err := utils.ParsePerceptualHash(phash)
+	if err != nil {
+		return err
+	}
+
+	duplicates, err := u.nearDuplicates(ctx, hash, u.cfg.DUPLICATE_DISTANCE, excludeKey)
+	if err != nil {
+		return err
+	}
+	if len(duplicates) > 0 {
+		return fmt.Errorf("%w: %s", utils.ErrNearDuplicate, duplicates[0].Key)
+	}
+
 	return nil
 }
+
+// imageMetadata computes the placeholder and perceptual hash metadata stored
+// alongside an image.
+// Images that cannot be decoded (e.g. SVG) are stored without placeholders.
+func imageMetadata(funcName string, fileBytes []byte) map[string]string {
+	metadata := map[string]string{}
+
+	img, _, err := utils.DecodeImage(fileBytes)
+	if err != nil {
+		utils.ErrorLog("usecase", funcName+" DecodeImage", err)
+		return metadata
+	}
+
+	placeholder, err := utils.NewImagePlaceholder(img)
+	if err != nil {
+		utils.ErrorLog("usecase", funcName+" NewImagePlaceholder", err)
+		return metadata
+	}
+
+	metadata[utils.MetaBlurHash] = placeholder.BlurHash
+	metadata[utils.MetaDominantColor] = placeholder.DominantColor
+	metadata[utils.MetaAverageColor] = placeholder.AverageColor
+	metadata[utils.MetaPHash] = utils.FormatPerceptualHash(utils.DifferenceHash(img))
+
+	return metadata
+}
+
+// readContent reads an upload body, computing its SHA-256 as it streams in
+// so that the content is not hashed again from the buffer.
+func readContent(body io.Reader) ([]byte, string, error) {
+	hasher := sha256.New()
+	data, err := io.ReadAll(io.TeeReader(body, hasher))
+	if err != nil {
+		return nil, "", err
+	}
+	return data, hex.EncodeToString(hasher.Sum(nil)), nil
+}
+
+func contentHash(data []byte) string {
+	sum := sha256.Sum256(data)
+	return hex.EncodeToString(sum[:])
+}
+
+// checksumHex converts a base64 S3 checksum to hex.
+func checksumHex(checksum string) string {
+	sum, err := base64.StdEncoding.DecodeString(checksum)
+	if err != nil {
+		return ""
+	}
+	return hex.EncodeToString(sum)
+}
@@ -1,14 +1,19 @@
 package main
 
 import (
+	"context"
 	"fmt"
 	"log"
 	"net/http"
 	"os"
+	"os/signal"
+	"strings"
+	"syscall"
 	"time"
 
 	"github.com/adityaw24/go-aws-garasi/configs"
 	"github.com/adityaw24/go-aws-garasi/internal/handler"
+	"github.com/adityaw24/go-aws-garasi/internal/model"
 	"github.com/adityaw24/go-aws-garasi/internal/repo"
 	"github.com/adityaw24/go-aws-garasi/internal/usecase"
 	"github.com/adityaw24/go-aws-garasi/middleware"
@@ -30,7 +35,25 @@ func main() {
 	fmt.Println(cfg)
 
 	router := gin.Default()
-	router.Use(middleware.CORSMiddleware())
+	err = router.SetTrustedProxies(cfg.TRUSTED_PROXIES)
+	if err != nil {
+		log.Fatalf("Error setting trusted proxies: %v", err)
+	}
+	corsRouteMethods := make(map[string][]string, len(cfg.CORS_ROUTE_METHODS))
+	for prefix, methods := range cfg.CORS_ROUTE_METHODS {
+		corsRouteMethods[cfg.API_GROUP+prefix] = methods
+	}
+	router.Use(middleware.CORSMiddleware(middleware.CORSConfig{
+		AllowedOrigins:   cfg.CORS_ALLOWED_ORIGINS,
+		AllowCredentials: cfg.CORS_ALLOW_CREDENTIALS,
+		Methods:          cfg.CORS_METHODS,
+		RouteMethods:     corsRouteMethods,
+		ExposedHeaders:   cfg.CORS_EXPOSED_HEADERS,
+		MaxAge:           time.Duration(cfg.CORS_MAX_AGE) * time.Second,
+	}))
+	if cfg.RATE_LIMIT_IP.Enabled() {
+		router.Use(middleware.RateLimitByIP(utils.NewRateLimiter(cfg.RATE_LIMIT_IP)))
+	}
 
 	// Initialize AWS clients
 	client, presignClient, err := configs.ConnectAWS(cfg)
@@ -40,22 +63,148 @@ func main() {
 
 	timeout := time.Duration(cfg.TIMEOUT) * time.Second
 
-	repoUpload := repo.NewRepoUpload(client, presignClient, cfg.BUCKET_NAME, timeout)
-	usecasesUpload := usecase.NewUsecaseUpload(repoUpload)
+	var watermark *utils.Watermark
+	if cfg.WATERMARK_PATH != "" {
+		watermark, err = utils.LoadWatermark(cfg.WATERMARK_PATH, cfg.WATERMARK_POSITION, cfg.WATERMARK_OPACITY, cfg.WATERMARK_SCALE)
+		if err != nil {
+			log.Fatalf("Error loading watermark: %v", err)
+		}
+	}
+
+	repoUpload := repo.NewRepoUpload(client, presignClient, cfg.BUCKET_NAME, timeout, cfg.SSE)
+	usecasesUpload := usecase.NewUsecaseUpload(repoUpload, cfg, watermark)
 	handlerUpload := handler.NewHandlerUpload(usecasesUpload)
+	usecasesAlbum := usecase.NewUsecaseAlbum(repoUpload, usecasesUpload)
+	handlerAlbum := handler.NewHandlerAlbum(usecasesAlbum)
+	usecasesReference := usecase.NewUsecaseReference(repoUpload, usecasesUpload, time.Duration(cfg.GC_GRACE_PERIOD)*time.Second)
+	handlerReference := handler.NewHandlerReference(usecasesReference)
+	usecasesAPIKey := usecase.NewUsecaseAPIKey(repoUpload)
+	handlerAPIKey := handler.NewHandlerAPIKey(usecasesAPIKey)
+	usecasesShare := usecase.NewUsecaseShare(repoUpload, usecasesUpload, cfg.SHARE_BASE_URL+cfg.API_GROUP+"/s/",
+		time.Duration(cfg.SHARE_DEFAULT_TTL)*time.Second, time.Duration(cfg.SHARE_MAX_TTL)*time.Second)
+	handlerShare := handler.NewHandlerShare(usecasesShare)
+	usecasesEncryption := usecase.NewUsecaseEncryption(repoUpload, cfg.ENVELOPE_MASTER_KEYS)
+	handlerEncryption := handler.NewHandlerEncryption(usecasesEncryption)
+
+	// "rewrap" re-wraps the data keys of encrypted objects with the current
+	// master key version and exits instead of serving. An interrupted run
+	// resumes where it stopped.
+	if len(os.Args) > 1 && os.Args[1] == "rewrap" {
+		ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+		defer stop()
+
+		job, err := usecasesEncryption.RunRewrap(ctx)
+		if err != nil {
+			log.Fatalf("Error re-wrapping data keys: %v", err)
+		}
+		if job.Failed > 0 {
+			log.Fatalf("%d objects could not be re-wrapped, last error: %s", job.Failed, job.LastError)
+		}
+		if job.RetryLater > 0 {
+			log.Printf("%d objects changed while being re-wrapped, run rewrap again to finish them", job.RetryLater)
+		}
+		return
+	}
+
+	if cfg.GC_ENABLED {
+		go usecasesReference.RunGarbageCollector(context.Background(), time.Duration(cfg.GC_INTERVAL)*time.Second)
+	}
 
 	router.NoRoute(func(c *gin.Context) {
 		utils.ErrorResp(c, http.StatusNotFound, "page not found")
 	})
 
+	idempotency := middleware.Idempotency(middleware.NewIdempotencyStore(time.Duration(cfg.IDEMPOTENCY_TTL)*time.Second, int64(cfg.IDEMPOTENCY_MAX_BODY)))
+
+	// Share links and public images are opened without authentication; the
+	// token or URL signature is the credential.
+	public := router.Group(cfg.API_GROUP)
+	public.GET("/s/:token", handlerShare.OpenShare)
+	public.POST("/s/:token", handlerShare.OpenShare)
+	public.GET("/public/*key", handlerUpload.PublicFile)
+
 	v1 := router.Group(cfg.API_GROUP)
+	if cfg.RATE_LIMIT_API_KEY.Enabled() {
+		v1.Use(middleware.RateLimitByAPIKey(utils.NewRateLimiter(cfg.RATE_LIMIT_API_KEY)))
+	}
+	if cfg.AUTH_ENABLED {
+		jwtKeys, err := utils.LoadJWTKeys(cfg.JWT_SECRET, cfg.JWT_PUBLIC_KEY_FILE, cfg.JWT_JWKS_FILE)
+		if err != nil {
+			log.Fatalf("Error loading JWT keys: %v", err)
+		}
+		v1.Use(middleware.Authenticate(&utils.JWTVerifier{
+			Keys:     jwtKeys,
+			Issuer:   cfg.JWT_ISSUER,
+			Audience: cfg.JWT_AUDIENCE,
+		}, usecasesAPIKey, cfg.TENANT_CLAIM, cfg.TENANT_REQUIRED, cfg.JWT_ADMIN_ROLES))
+	} else {
+		log.Println("AUTH_ENABLED is off: every API route is served without authentication")
+	}
+	if len(cfg.RATE_LIMIT_ROUTES) > 0 {
+		routeLimiters := make(map[string]*utils.RateLimiter, len(cfg.RATE_LIMIT_ROUTES))
+		for route, limit := range cfg.RATE_LIMIT_ROUTES {
+			method, path, _ := strings.Cut(route, " ")
+			routeLimiters[method+" "+cfg.API_GROUP+path] = utils.NewRateLimiter(limit)
+		}
+		v1.Use(middleware.RateLimitByRoute(routeLimiters))
+	}
+
+	// Upload bodies are throttled before the idempotency middleware reads
+	// them.
+	uploadConcurrency := middleware.ConcurrencyLimit(cfg.MAX_CONCURRENT_UPLOADS)
+	uploadBandwidth := middleware.ThrottleBody(cfg.UPLOAD_BANDWIDTH_LIMIT)
+
+	scopeUpload := middleware.RequireScope(model.ScopeUpload)
+	scopePreview := middleware.RequireScope(model.ScopePreview)
+	scopeList := middleware.RequireScope(model.ScopeList)
+	scopeUpdate := middleware.RequireScope(model.ScopeUpdate)
+	scopeDelete := middleware.RequireScope(model.ScopeDelete)
+	scopeRename := middleware.RequireScope(model.ScopeRename)
+	scopeAdmin := middleware.RequireScope(model.ScopeAdmin)
+
+	v1.POST("/upload", scopeUpload, uploadConcurrency, uploadBandwidth, idempotency, handlerUpload.UploadFile)
+	v1.GET("/preview/*key", scopePreview, handlerUpload.PreviewFile)
+	v1.PUT("/update", scopeUpdate, uploadConcurrency, uploadBandwidth, idempotency, handlerUpload.UpdateFile)
+	v1.GET("/list", scopeList, handlerUpload.ListObjects)
+	v1.DELETE("/delete/*key", scopeDelete, idempotency, handlerUpload.DeleteFile)
+	v1.PUT("/update-object", scopeRename, idempotency, handlerUpload.UpdateObject)
+	v1.GET("/duplicates/*key", scopePreview, handlerUpload.FindDuplicates)
+	v1.GET("/details/*key", scopePreview, handlerUpload.DetailFile)
+	v1.GET("/download/*key", scopePreview, handlerUpload.DownloadFile)
+	v1.PATCH("/metadata/*key", scopeUpdate, idempotency, handlerUpload.UpdateMetadata)
+	v1.GET("/tags/*key", scopePreview, handlerUpload.GetTags)
+	v1.PUT("/tags/*key", scopeUpdate, idempotency, handlerUpload.SetTags)
+	v1.DELETE("/tags/*key", scopeUpdate, idempotency, handlerUpload.DeleteTags)
+	v1.GET("/access/*key", scopePreview, handlerUpload.GetAccess)
+	v1.POST("/access/*key", scopeUpdate, idempotency, handlerUpload.GrantAccess)
+	v1.DELETE("/access/*key", scopeUpdate, idempotency, handlerUpload.RevokeAccess)
+
+	v1.GET("/albums", scopeList, handlerAlbum.ListAlbums)
+	v1.POST("/albums", scopeUpdate, idempotency, handlerAlbum.CreateAlbum)
+	v1.GET("/albums/:id", scopePreview, handlerAlbum.AlbumContents)
+	v1.PATCH("/albums/:id", scopeUpdate, idempotency, handlerAlbum.RenameAlbum)
+	v1.DELETE("/albums/:id", scopeUpdate, idempotency, handlerAlbum.DeleteAlbum)
+	v1.POST("/albums/:id/items", scopeUpdate, idempotency, handlerAlbum.AddToAlbum)
+	v1.DELETE("/albums/:id/items", scopeUpdate, idempotency, handlerAlbum.RemoveFromAlbum)
+	v1.PUT("/albums/:id/order", scopeUpdate, idempotency, handlerAlbum.ReorderAlbum)
+	v1.PUT("/albums/:id/cover", scopeUpdate, idempotency, handlerAlbum.SetAlbumCover)
+
+	v1.POST("/references", scopeUpdate, idempotency, handlerReference.RegisterReference)
+	v1.DELETE("/references", scopeUpdate, idempotency, handlerReference.ReleaseReference)
+	v1.GET("/references/*key", scopePreview, handlerReference.GetReferences)
+	v1.POST("/gc", scopeAdmin, handlerReference.CollectGarbage)
+
+	v1.POST("/shares", scopeUpdate, idempotency, handlerShare.CreateShare)
+	v1.GET("/shares", scopePreview, handlerShare.ListShares)
+	v1.GET("/shares/:token", scopePreview, handlerShare.GetShare)
+	v1.DELETE("/shares/:token", scopeUpdate, idempotency, handlerShare.RevokeShare)
+
+	v1.POST("/encryption/rewrap", scopeAdmin, handlerEncryption.StartRewrap)
+	v1.GET("/encryption/rewrap", scopeAdmin, handlerEncryption.GetRewrap)
 
-	v1.POST("/upload", handlerUpload.UploadFile)
-	v1.GET("/preview/:key", handlerUpload.PreviewFile)
-	v1.PUT("/update", handlerUpload.UpdateFile)
-	v1.GET("/list", handlerUpload.ListObjects)
-	v1.DELETE("/delete/:key", handlerUpload.DeleteFile)
-	v1.PUT("/update-object", handlerUpload.UpdateObject)
+	v1.POST("/api-keys", scopeAdmin, idempotency, handlerAPIKey.CreateAPIKey)
+	v1.GET("/api-keys", scopeAdmin, handlerAPIKey.ListAPIKeys)
+	v1.DELETE("/api-keys/:id", scopeAdmin, idempotency, handlerAPIKey.RevokeAPIKey)
 
 	srv := &http.Server{
 		Addr:    fmt.Sprintf("0.0.0.0:%d", cfg.PORT),
@@ -0,0 +1,164 @@
+package middleware
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"slices"
+	"strings"
+
+	"github.com/adityaw24/go-aws-garasi/internal/model"
+	"github.com/adityaw24/go-aws-garasi/utils"
+	"github.com/gin-gonic/gin"
+)
+
+const APIKeyHeader = "X-API-Key"
+
+// APIKeyValidator resolves an API key to the identity it was issued for.
+type APIKeyValidator interface {
+	ValidateAPIKey(ctx context.Context, key string) (*model.Identity, error)
+}
+
+// Authenticate requires a valid API key in the X-API-Key header or a bearer
+// JWT, and attaches the caller identity to the request context, where
+// handlers and usecases read it with model.IdentityFromContext. API keys are
+// limited to the scopes they were created with; a JWT is limited by the
+// scopes of this service in its "scope" or "scp" claim, as a space separated
+// string or an array. Other values, such as the "openid profile" of OIDC
+// access tokens, are ignored, and a token without any scope of this service
+// is not limited. See RequireScope.
+//
+// Admin is never implied by a missing scope claim: a JWT is only admin with
+// the admin scope, or with one of adminRoles in its "roles" or "groups"
+// claim.
+//
+// The tenant of a JWT is read from tenantClaim, and an API key belongs to the
+// tenant it was created in. The tenant is attached with model.WithTenant and
+// scopes every object the request touches. When tenantRequired is set,
+// callers without a tenant are rejected instead of using the bucket root.
+func Authenticate(verifier *utils.JWTVerifier, apiKeys APIKeyValidator, tenantClaim string, tenantRequired bool, adminRoles []string) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		if key := c.GetHeader(APIKeyHeader); key != "" {
+			identity, err := apiKeys.ValidateAPIKey(c.Request.Context(), key)
+			if err != nil {
+				if !errors.Is(err, utils.ErrInvalidAPIKey) {
+					utils.ErrorLog("middleware", "Authenticate ValidateAPIKey", err)
+					utils.ErrorResp(c, http.StatusInternalServerError, "error validating api key")
+					c.Abort()
+					return
+				}
+				unauthorized(c, err)
+				return
+			}
+
+			authenticated(c, identity, tenantRequired)
+			return
+		}
+
+		token, ok := strings.CutPrefix(c.GetHeader("Authorization"), "Bearer ")
+		if !ok || token == "" {
+			unauthorized(c, errors.New("missing bearer token"))
+			return
+		}
+
+		claims, err := verifier.Verify(strings.TrimSpace(token))
+		if err != nil {
+			unauthorized(c, err)
+			return
+		}
+
+		identity := &model.Identity{
+			Method: model.AuthMethodJWT,
+			Groups: utils.JWTStrings(claims["groups"]),
+			Claims: claims,
+		}
+		identity.Subject, _ = claims["sub"].(string)
+		identity.Issuer, _ = claims["iss"].(string)
+		identity.Scopes = jwtScopes(claims)
+		if jwtAdmin(claims, adminRoles) && !identity.IsAdmin() {
+			identity.Scopes = append(identity.Scopes, model.ScopeAdmin)
+		}
+
+		if identity.Subject == "" {
+			unauthorized(c, errors.New("token has no subject"))
+			return
+		}
+
+		if tenant, _ := claims[tenantClaim].(string); tenant != "" {
+			if err := utils.ValidateTenant(tenant); err != nil {
+				unauthorized(c, err)
+				return
+			}
+			identity.Tenant = tenant
+		}
+
+		authenticated(c, identity, tenantRequired)
+	}
+}
+
+// authenticated stores the identity and tenant of the caller and continues.
+func authenticated(c *gin.Context, identity *model.Identity, tenantRequired bool) {
+	if tenantRequired && identity.Tenant == "" {
+		utils.ErrorLog("middleware", "Authenticate", errors.New(identity.Subject+" has no tenant"))
+		utils.ErrorResp(c, http.StatusForbidden, utils.ErrForbidden.Error()+": a tenant is required")
+		c.Abort()
+		return
+	}
+
+	ctx := model.WithTenant(model.WithIdentity(c.Request.Context(), identity), identity.Tenant)
+	c.Request = c.Request.WithContext(ctx)
+	c.Next()
+}
+
+// RequireScope rejects callers whose identity does not grant scope. Requests
+// that were not authenticated pass, so routes keep working with
+// authentication disabled.
+func RequireScope(scope string) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		identity := model.IdentityFromContext(c.Request.Context())
+		if identity != nil && !identity.HasScope(scope) {
+			utils.ErrorLog("middleware", "RequireScope", errors.New(identity.Subject+" lacks scope "+scope))
+			utils.ErrorResp(c, http.StatusForbidden, utils.ErrForbidden.Error()+": the "+scope+" scope is required")
+			c.Abort()
+			return
+		}
+
+		c.Next()
+	}
+}
+
+// jwtScopes returns the scopes of this service in the "scope" and "scp"
+// claims, or nil when they hold none.
+func jwtScopes(claims map[string]any) []string {
+	var scopes []string
+	for _, claim := range []string{"scope", "scp"} {
+		for _, value := range utils.JWTStrings(claims[claim]) {
+			for _, scope := range strings.Fields(value) {
+				if (scope == model.ScopeAdmin || slices.Contains(model.APIKeyScopes, scope)) && !slices.Contains(scopes, scope) {
+					scopes = append(scopes, scope)
+				}
+			}
+		}
+	}
+	return scopes
+}
+
+// jwtAdmin reports whether the "roles" or "groups" claim holds one of
+// adminRoles.
+func jwtAdmin(claims map[string]any, adminRoles []string) bool {
+	for _, claim := range []string{"roles", "groups"} {
+		for _, role := range utils.JWTStrings(claims[claim]) {
+			if slices.Contains(adminRoles, role) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+func unauthorized(c *gin.Context, err error) {
+	utils.ErrorLog("middleware", "Authenticate", err)
+	c.Header("WWW-Authenticate", `Bearer error="invalid_token"`)
+	utils.ErrorResp(c, http.StatusUnauthorized, "unauthorized: "+err.Error())
+	c.Abort()
+}
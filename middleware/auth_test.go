@@ -0,0 +1,102 @@
+package middleware
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/adityaw24/go-aws-garasi/internal/model"
+	"github.com/adityaw24/go-aws-garasi/utils"
+	"github.com/gin-gonic/gin"
+)
+
+func TestJWTScopes(t *testing.T) {
+	tests := []struct {
+		name   string
+		claims map[string]any
+		want   []string
+	}{
+		{"no scope claim", map[string]any{}, nil},
+		{"OIDC scopes only", map[string]any{"scope": "openid profile email"}, nil},
+		{"OIDC and service scopes", map[string]any{"scope": "openid upload list"}, []string{"upload", "list"}},
+		{"scp array", map[string]any{"scp": []any{"profile", "preview"}}, []string{"preview"}},
+		{"both claims", map[string]any{"scope": "upload", "scp": []any{"upload", "delete"}}, []string{"upload", "delete"}},
+		{"admin", map[string]any{"scope": "openid admin"}, []string{"admin"}},
+		{"prefixed scope", map[string]any{"scope": "api:upload"}, nil},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := jwtScopes(tt.claims)
+			if strings.Join(got, " ") != strings.Join(tt.want, " ") || (got == nil) != (tt.want == nil) {
+				t.Errorf("jwtScopes(%v) = %q, want %q", tt.claims, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestAuthenticateOIDCToken(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	secret := []byte("secret-0123456789abcdef0123456789")
+	keys, err := utils.LoadJWTKeys(string(secret), "", "")
+	if err != nil {
+		t.Fatalf("LoadJWTKeys: %v", err)
+	}
+
+	router := gin.New()
+	router.Use(Authenticate(&utils.JWTVerifier{Keys: keys}, nil, "tenant", false, nil))
+	router.GET("/files", RequireScope(model.ScopeList), func(c *gin.Context) {
+		c.Status(http.StatusOK)
+	})
+	router.GET("/admin", RequireScope(model.ScopeAdmin), func(c *gin.Context) {
+		c.Status(http.StatusOK)
+	})
+
+	exp := time.Now().Add(time.Hour).Unix()
+	tests := []struct {
+		name   string
+		claims map[string]any
+		path   string
+		want   int
+	}{
+		{"OIDC token", map[string]any{"sub": "user", "exp": exp, "scope": "openid profile email"}, "/files", http.StatusOK},
+		{"OIDC token on an admin route", map[string]any{"sub": "user", "exp": exp, "scope": "openid profile email"}, "/admin", http.StatusForbidden},
+		{"token limited to another scope", map[string]any{"sub": "user", "exp": exp, "scope": "openid upload"}, "/files", http.StatusForbidden},
+		{"token with the scope", map[string]any{"sub": "user", "exp": exp, "scope": "openid list"}, "/files", http.StatusOK},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := httptest.NewRequest(http.MethodGet, tt.path, nil)
+			req.Header.Set("Authorization", "Bearer "+signToken(t, tt.claims, secret))
+			rec := httptest.NewRecorder()
+			router.ServeHTTP(rec, req)
+
+			if rec.Code != tt.want {
+				t.Errorf("GET %s returned %d, want %d: %s", tt.path, rec.Code, tt.want, rec.Body.String())
+			}
+		})
+	}
+}
+
+func signToken(t *testing.T, claims map[string]any, secret []byte) string {
+	t.Helper()
+	encode := func(v any) string {
+		data, err := json.Marshal(v)
+		if err != nil {
+			t.Fatalf("json.Marshal: %v", err)
+		}
+		return base64.RawURLEncoding.EncodeToString(data)
+	}
+
+	signingInput := encode(map[string]any{"alg": utils.JWTAlgorithmHS256, "typ": "JWT"}) + "." + encode(claims)
+	mac := hmac.New(sha256.New, secret)
+	mac.Write([]byte(signingInput))
+	return signingInput + "." + base64.RawURLEncoding.EncodeToString(mac.Sum(nil))
+}
@@ -1,21 +1,100 @@
 package middleware
 
 import (
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/adityaw24/go-aws-garasi/utils"
 	"github.com/gin-gonic/gin"
 )
 
-func CORSMiddleware() gin.HandlerFunc {
+// corsAllowedHeaders are the request headers the API understands.
+const corsAllowedHeaders = "Content-Type, Content-Length, Accept-Encoding, X-CSRF-Token, Authorization, accept, origin, Cache-Control, X-Requested-With, Idempotency-Key, X-API-Key, X-Share-Password"
+
+// CORSConfig is the cross-origin policy. RouteMethods overrides Methods for
+// the paths starting with a key; the longest matching prefix wins.
+type CORSConfig struct {
+	AllowedOrigins   []utils.OriginPattern
+	AllowCredentials bool
+	Methods          []string
+	RouteMethods     map[string][]string
+	ExposedHeaders   []string
+	MaxAge           time.Duration
+}
+
+// CORSMiddleware answers preflight requests and adds CORS headers for
+// allowed origins. The allowed origin is reflected, so credentials can be
+// allowed for specific origins; a "*" policy never allows credentials.
+// Requests from other origins get no CORS headers, which makes browsers
+// block them.
+func CORSMiddleware(config CORSConfig) gin.HandlerFunc {
+	exposedHeaders := strings.Join(config.ExposedHeaders, ", ")
+	maxAge := strconv.Itoa(int(config.MaxAge.Seconds()))
+
 	return func(c *gin.Context) {
-		c.Writer.Header().Set("Access-Control-Allow-Origin", "*")
-		c.Writer.Header().Set("Access-Control-Allow-Credentials", "true")
-		c.Writer.Header().Set("Access-Control-Allow-Headers", "Content-Type, Content-Length, Accept-Encoding, X-CSRF-Token, Authorization, accept, origin, Cache-Control, X-Requested-With")
-		c.Writer.Header().Set("Access-Control-Allow-Methods", "POST, OPTIONS, GET, PUT, DELETE")
+		origin := c.GetHeader("Origin")
+		if origin == "" {
+			c.Next()
+			return
+		}
+
+		header := c.Writer.Header()
+		header.Add("Vary", "Origin")
+
+		allowed, wildcard := corsOriginAllowed(config.AllowedOrigins, origin)
+		preflight := c.Request.Method == http.MethodOptions && c.GetHeader("Access-Control-Request-Method") != ""
+
+		if allowed {
+			if wildcard && !config.AllowCredentials {
+				header.Set("Access-Control-Allow-Origin", "*")
+			} else {
+				header.Set("Access-Control-Allow-Origin", origin)
+			}
+			if config.AllowCredentials {
+				header.Set("Access-Control-Allow-Credentials", "true")
+			}
+		}
 
-		if c.Request.Method == "OPTIONS" {
-			c.AbortWithStatus(204)
+		if !preflight {
+			if allowed && exposedHeaders != "" {
+				header.Set("Access-Control-Expose-Headers", exposedHeaders)
+			}
+			c.Next()
 			return
 		}
 
-		c.Next()
+		header.Add("Vary", "Access-Control-Request-Method")
+		header.Add("Vary", "Access-Control-Request-Headers")
+		if allowed {
+			header.Set("Access-Control-Allow-Methods", strings.Join(corsMethods(config, c.Request.URL.Path), ", "))
+			header.Set("Access-Control-Allow-Headers", corsAllowedHeaders)
+			header.Set("Access-Control-Max-Age", maxAge)
+		}
+		c.AbortWithStatus(http.StatusNoContent)
+	}
+}
+
+// corsOriginAllowed reports whether origin is allowed, and whether it was
+// allowed by the "*" pattern.
+func corsOriginAllowed(patterns []utils.OriginPattern, origin string) (bool, bool) {
+	for _, pattern := range patterns {
+		if pattern.Matches(origin) {
+			return true, pattern.Any()
+		}
+	}
+	return false, false
+}
+
+func corsMethods(config CORSConfig, path string) []string {
+	methods := config.Methods
+	longest := -1
+	for prefix, routeMethods := range config.RouteMethods {
+		if strings.HasPrefix(path, prefix) && len(prefix) > longest {
+			methods = routeMethods
+			longest = len(prefix)
+		}
 	}
+	return methods
 }
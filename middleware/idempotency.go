@@ -0,0 +1,302 @@
+package middleware
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"hash"
+	"io"
+	"mime"
+	"mime/multipart"
+	"net/http"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/adityaw24/go-aws-garasi/internal/model"
+	"github.com/adityaw24/go-aws-garasi/utils"
+	"github.com/gin-gonic/gin"
+)
+
+const (
+	IdempotencyKeyHeader      = "Idempotency-Key"
+	idempotencyReplayedHeader = "Idempotent-Replayed"
+	maxIdempotencyKeyLength   = 255
+	idempotencySweepInterval  = time.Minute
+)
+
+// IdempotencyStore keeps the responses of requests sent with an
+// Idempotency-Key header for ttl, in memory. Expired entries are swept
+// periodically.
+type IdempotencyStore struct {
+	mu      sync.Mutex
+	ttl     time.Duration
+	maxBody int64
+	entries map[string]*idempotencyEntry
+}
+
+type idempotencyEntry struct {
+	fingerprint string
+	done        bool
+	status      int
+	contentType string
+	body        []byte
+	expiresAt   time.Time
+}
+
+// NewIdempotencyStore creates a store keeping responses for ttl. Requests
+// with an Idempotency-Key may send at most maxBody bytes, or any size when
+// maxBody is 0.
+func NewIdempotencyStore(ttl time.Duration, maxBody int64) *IdempotencyStore {
+	store := &IdempotencyStore{
+		ttl:     ttl,
+		maxBody: maxBody,
+		entries: make(map[string]*idempotencyEntry),
+	}
+	go store.sweep(min(ttl, idempotencySweepInterval))
+	return store
+}
+
+// sweep drops expired entries every interval.
+func (s *IdempotencyStore) sweep(interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for now := range ticker.C {
+		s.mu.Lock()
+		for k, entry := range s.entries {
+			if now.After(entry.expiresAt) {
+				delete(s.entries, k)
+			}
+		}
+		s.mu.Unlock()
+	}
+}
+
+// begin registers a request. It returns the stored entry when the key has
+// been seen before, or nil when the caller should process the request.
+func (s *IdempotencyStore) begin(key string) *idempotencyEntry {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	now := time.Now()
+	if entry, ok := s.entries[key]; ok && !now.After(entry.expiresAt) {
+		copied := *entry
+		return &copied
+	}
+
+	s.entries[key] = &idempotencyEntry{
+		expiresAt: now.Add(s.ttl),
+	}
+	return nil
+}
+
+func (s *IdempotencyStore) complete(key string, fingerprint string, status int, contentType string, body []byte) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	entry, ok := s.entries[key]
+	if !ok {
+		return
+	}
+	entry.fingerprint = fingerprint
+	entry.done = true
+	entry.status = status
+	entry.contentType = contentType
+	entry.body = body
+}
+
+func (s *IdempotencyStore) forget(key string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	delete(s.entries, key)
+}
+
+type capturingWriter struct {
+	gin.ResponseWriter
+	body bytes.Buffer
+}
+
+func (w *capturingWriter) Write(data []byte) (int, error) {
+	w.body.Write(data)
+	return w.ResponseWriter.Write(data)
+}
+
+func (w *capturingWriter) WriteString(s string) (int, error) {
+	w.body.WriteString(s)
+	return w.ResponseWriter.WriteString(s)
+}
+
+// Idempotency replays the original response when a request is retried with
+// the same Idempotency-Key. The key is scoped to the caller and the route,
+// and reusing it for a different request body is rejected. Server errors are
+// not recorded so the request can be retried.
+//
+// The body is fingerprinted as the handler reads it, so it is never held in
+// memory; whatever the handler leaves unread is read afterwards. A retry is
+// only read to compare its fingerprint.
+func Idempotency(store *IdempotencyStore) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		idempotencyKey := c.GetHeader(IdempotencyKeyHeader)
+		if idempotencyKey == "" {
+			c.Next()
+			return
+		}
+
+		if len(idempotencyKey) > maxIdempotencyKeyLength {
+			utils.ErrorResp(c, http.StatusBadRequest, "Idempotency-Key must be at most 255 characters")
+			c.Abort()
+			return
+		}
+
+		if store.maxBody > 0 {
+			if c.Request.ContentLength > store.maxBody {
+				utils.ErrorResp(c, http.StatusRequestEntityTooLarge, fmt.Sprintf("requests with an Idempotency-Key may send at most %d bytes", store.maxBody))
+				c.Abort()
+				return
+			}
+			c.Request.Body = http.MaxBytesReader(c.Writer, c.Request.Body, store.maxBody)
+		}
+
+		fingerprint := newRequestFingerprint(c.Request)
+		body := io.TeeReader(c.Request.Body, fingerprint)
+		c.Request.Body = readCloser{Reader: body, Closer: c.Request.Body}
+
+		key := c.Request.Method + " " + c.FullPath() + " " + idempotencyKey
+		// Callers must not replay each other's responses.
+		if identity := model.IdentityFromContext(c.Request.Context()); identity != nil {
+			key = identity.Tenant + " " + identity.Subject + " " + key
+		}
+
+		entry := store.begin(key)
+		if entry != nil {
+			if !entry.done {
+				fingerprint.Sum()
+				utils.ErrorResp(c, http.StatusConflict, "a request with this Idempotency-Key is still in progress")
+				c.Abort()
+				return
+			}
+
+			_, err := io.Copy(io.Discard, body)
+			sum := fingerprint.Sum()
+			switch {
+			case err != nil:
+				utils.ErrorLog("middleware", "Idempotency read body", err)
+				utils.ErrorResp(c, http.StatusBadRequest, "error reading request body")
+			case sum != entry.fingerprint:
+				utils.ErrorResp(c, http.StatusUnprocessableEntity, "Idempotency-Key was already used for a different request")
+			default:
+				c.Header(idempotencyReplayedHeader, "true")
+				c.Data(entry.status, entry.contentType, entry.body)
+			}
+			c.Abort()
+			return
+		}
+
+		completed := false
+		defer func() {
+			if !completed {
+				store.forget(key)
+			}
+		}()
+
+		writer := &capturingWriter{ResponseWriter: c.Writer}
+		c.Writer = writer
+
+		c.Next()
+
+		_, err := io.Copy(io.Discard, body)
+		sum := fingerprint.Sum()
+		if err != nil {
+			// Without the full body a retry could not be matched.
+			utils.ErrorLog("middleware", "Idempotency read body", err)
+			return
+		}
+
+		status := writer.Status()
+		if status >= http.StatusInternalServerError {
+			return
+		}
+		store.complete(key, sum, status, writer.Header().Get("Content-Type"), writer.body.Bytes())
+		completed = true
+	}
+}
+
+type readCloser struct {
+	io.Reader
+	io.Closer
+}
+
+// requestFingerprint identifies the content of a request from the body
+// written to it. Multipart bodies are fingerprinted by their parts rather
+// than raw bytes, because clients usually pick a new boundary when they
+// rebuild a request for a retry; the parts are parsed from a pipe as the
+// body streams through.
+type requestFingerprint struct {
+	hasher hash.Hash
+	pipe   *io.PipeWriter
+	parts  []string
+	done   chan struct{}
+}
+
+func newRequestFingerprint(req *http.Request) *requestFingerprint {
+	fingerprint := &requestFingerprint{hasher: sha256.New()}
+	fingerprint.hasher.Write([]byte(req.Method + " " + req.URL.Path + "\n"))
+
+	mediaType, params, err := mime.ParseMediaType(req.Header.Get("Content-Type"))
+	if err == nil && strings.HasPrefix(mediaType, "multipart/") {
+		reader, writer := io.Pipe()
+		fingerprint.pipe = writer
+		fingerprint.done = make(chan struct{})
+		go fingerprint.hashParts(reader, params["boundary"])
+	}
+
+	return fingerprint
+}
+
+// Write never fails, so that fingerprinting cannot break the request.
+func (f *requestFingerprint) Write(data []byte) (int, error) {
+	if f.pipe == nil {
+		return f.hasher.Write(data)
+	}
+	f.pipe.Write(data)
+	return len(data), nil
+}
+
+func (f *requestFingerprint) hashParts(pipe *io.PipeReader, boundary string) {
+	defer close(f.done)
+
+	reader := multipart.NewReader(pipe, boundary)
+	for {
+		part, err := reader.NextPart()
+		if err != nil {
+			break
+		}
+
+		partHash := sha256.New()
+		io.Copy(partHash, part)
+		f.parts = append(f.parts, part.FormName()+"\x00"+part.FileName()+"\x00"+hex.EncodeToString(partHash.Sum(nil)))
+	}
+
+	// Keep reading whatever follows so that writers never block.
+	io.Copy(io.Discard, pipe)
+}
+
+// Sum returns the fingerprint of everything written so far. Nothing may be
+// written afterwards.
+func (f *requestFingerprint) Sum() string {
+	if f.pipe != nil {
+		f.pipe.Close()
+		<-f.done
+		f.pipe = nil
+
+		sort.Strings(f.parts)
+		for _, part := range f.parts {
+			f.hasher.Write([]byte(part + "\n"))
+		}
+	}
+	return hex.EncodeToString(f.hasher.Sum(nil))
+}
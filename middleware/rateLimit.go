@@ -0,0 +1,174 @@
+package middleware
+
+import (
+	"context"
+	"io"
+	"math"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/adityaw24/go-aws-garasi/internal/model"
+	"github.com/adityaw24/go-aws-garasi/utils"
+	"github.com/gin-gonic/gin"
+)
+
+// RateLimitByIP limits requests per client IP. It does not depend on
+// authentication, so it can guard every route.
+func RateLimitByIP(limiter *utils.RateLimiter) gin.HandlerFunc {
+	return rateLimit(limiter, func(c *gin.Context) string {
+		return c.ClientIP()
+	})
+}
+
+// RateLimitByAPIKey limits requests per API key, by the key ID in the
+// X-API-Key header. It runs before Authenticate, so that unknown and invalid
+// keys are limited before they are looked up; other requests pass.
+func RateLimitByAPIKey(limiter *utils.RateLimiter) gin.HandlerFunc {
+	return rateLimit(limiter, func(c *gin.Context) string {
+		if keyID, _, ok := utils.ParseAPIKey(c.GetHeader(APIKeyHeader)); ok {
+			return model.AuthMethodAPIKey + ":" + keyID
+		}
+		return ""
+	})
+}
+
+// RateLimitByRoute limits requests per route and client. limiters are keyed
+// by method and full route path, e.g. "POST /api/v1/upload".
+func RateLimitByRoute(limiters map[string]*utils.RateLimiter) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		limiter, ok := limiters[c.Request.Method+" "+c.FullPath()]
+		if !ok {
+			c.Next()
+			return
+		}
+
+		if allowed, retryAfter := limiter.Allow(clientKey(c)); !allowed {
+			tooManyRequests(c, retryAfter, "too many requests to this route")
+			return
+		}
+
+		c.Next()
+	}
+}
+
+func rateLimit(limiter *utils.RateLimiter, key func(c *gin.Context) string) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		k := key(c)
+		if k == "" {
+			c.Next()
+			return
+		}
+
+		if allowed, retryAfter := limiter.Allow(k); !allowed {
+			tooManyRequests(c, retryAfter, "too many requests")
+			return
+		}
+
+		c.Next()
+	}
+}
+
+// ConcurrencyLimit allows each client at most limit requests in flight on
+// the routes it guards. A zero limit disables it.
+func ConcurrencyLimit(limit int) gin.HandlerFunc {
+	var mu sync.Mutex
+	inFlight := map[string]int{}
+
+	return func(c *gin.Context) {
+		if limit <= 0 {
+			c.Next()
+			return
+		}
+
+		key := clientKey(c)
+
+		mu.Lock()
+		if inFlight[key] >= limit {
+			mu.Unlock()
+			tooManyRequests(c, time.Second, "too many concurrent uploads")
+			return
+		}
+		inFlight[key]++
+		mu.Unlock()
+
+		defer func() {
+			mu.Lock()
+			defer mu.Unlock()
+			inFlight[key]--
+			if inFlight[key] == 0 {
+				delete(inFlight, key)
+			}
+		}()
+
+		c.Next()
+	}
+}
+
+// ThrottleBody limits how fast each client can send request bodies, in
+// bytes per second. Concurrent requests of a client share its bandwidth. A
+// zero limit disables it.
+func ThrottleBody(bytesPerSecond int) gin.HandlerFunc {
+	limiter := utils.NewRateLimiter(utils.RateLimit{Rate: float64(bytesPerSecond), Burst: bytesPerSecond})
+
+	return func(c *gin.Context) {
+		if bytesPerSecond <= 0 {
+			c.Next()
+			return
+		}
+
+		c.Request.Body = &throttledBody{
+			ReadCloser: c.Request.Body,
+			ctx:        c.Request.Context(),
+			limiter:    limiter,
+			key:        clientKey(c),
+		}
+		c.Next()
+	}
+}
+
+type throttledBody struct {
+	io.ReadCloser
+	ctx     context.Context
+	limiter *utils.RateLimiter
+	key     string
+}
+
+func (b *throttledBody) Read(p []byte) (int, error) {
+	// Reads are capped at the burst so a single read never waits for more
+	// than a second of bandwidth.
+	if burst := b.limiter.Limit().Burst; len(p) > burst {
+		p = p[:burst]
+	}
+
+	n, err := b.ReadCloser.Read(p)
+	if n > 0 {
+		if delay := b.limiter.Reserve(b.key, n); delay > 0 {
+			timer := time.NewTimer(delay)
+			defer timer.Stop()
+			select {
+			case <-timer.C:
+			case <-b.ctx.Done():
+				return n, b.ctx.Err()
+			}
+		}
+	}
+	return n, err
+}
+
+// clientKey identifies the caller: its API key or JWT subject when
+// authenticated, its IP otherwise.
+func clientKey(c *gin.Context) string {
+	if identity := model.IdentityFromContext(c.Request.Context()); identity != nil {
+		return identity.Tenant + " " + identity.Subject
+	}
+	return c.ClientIP()
+}
+
+func tooManyRequests(c *gin.Context, retryAfter time.Duration, message string) {
+	seconds := int(math.Max(1, math.Ceil(retryAfter.Seconds())))
+	c.Header("Retry-After", strconv.Itoa(seconds))
+	utils.ErrorResp(c, http.StatusTooManyRequests, message)
+	c.Abort()
+}
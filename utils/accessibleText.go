@@ -0,0 +1,111 @@
+package utils
+
+import (
+	"fmt"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+
+	"golang.org/x/text/language"
+	"golang.org/x/text/unicode/norm"
+)
+
+const (
+	MaxAltTextLength = 250
+	MaxCaptionLength = 1000
+)
+
+// NormalizeLanguage validates a BCP 47 language tag and returns it in the
+// lower case form used in metadata keys, e.g. "pt-BR" -> "pt-br".
+func NormalizeLanguage(tag string) (string, error) {
+	parsed, err := language.Parse(tag)
+	if err != nil {
+		return "", fmt.Errorf("%w: invalid language %q", ErrInvalidMetadata, tag)
+	}
+	return strings.ToLower(parsed.String()), nil
+}
+
+// AccessibleText returns the default value of an alt text or caption field
+// (MetaAltText or MetaCaption) and its variants by language from object
+// metadata, where they were stored before they moved to their own document.
+func AccessibleText(metadata map[string]string, field string) (string, map[string]string) {
+	var variants map[string]string
+	for k, v := range metadata {
+		lang, ok := strings.CutPrefix(k, field+"-")
+		if !ok {
+			continue
+		}
+		if variants == nil {
+			variants = map[string]string{}
+		}
+		variants[lang] = DecodeMetadataValue(v)
+	}
+	return DecodeMetadataValue(metadata[field]), variants
+}
+
+// RemoveAccessibleText deletes the alt text and caption fields from object
+// metadata.
+func RemoveAccessibleText(metadata map[string]string) {
+	for k := range metadata {
+		if k == MetaAltText || k == MetaCaption || strings.HasPrefix(k, MetaAltText+"-") || strings.HasPrefix(k, MetaCaption+"-") {
+			delete(metadata, k)
+		}
+	}
+}
+
+// SetAccessibleText updates an alt text or caption field (MetaAltText or
+// MetaCaption) and returns its new default and variants. A nil value leaves
+// the default untouched and updates are merged into variants by language; an
+// empty string removes the default or the variant.
+func SetAccessibleText(field string, current string, variants map[string]string, value *string, updates map[string]string) (string, map[string]string, error) {
+	maxLength := MaxAltTextLength
+	if field == MetaCaption {
+		maxLength = MaxCaptionLength
+	}
+
+	if value != nil {
+		var err error
+		current, err = normalizeAccessibleText(field, *value, maxLength)
+		if err != nil {
+			return "", nil, err
+		}
+	}
+
+	merged := make(map[string]string, len(variants)+len(updates))
+	for lang, v := range variants {
+		merged[lang] = v
+	}
+	for tag, v := range updates {
+		lang, err := NormalizeLanguage(tag)
+		if err != nil {
+			return "", nil, err
+		}
+		v, err = normalizeAccessibleText(field, v, maxLength)
+		if err != nil {
+			return "", nil, err
+		}
+		if v == "" {
+			delete(merged, lang)
+			continue
+		}
+		merged[lang] = v
+	}
+	if len(merged) == 0 {
+		merged = nil
+	}
+
+	return current, merged, nil
+}
+
+func normalizeAccessibleText(field string, value string, maxLength int) (string, error) {
+	value = norm.NFC.String(strings.TrimSpace(value))
+	switch {
+	case !utf8.ValidString(value):
+		return "", fmt.Errorf("%w: %s must be valid UTF-8", ErrInvalidMetadata, field)
+	case utf8.RuneCountInString(value) > maxLength:
+		return "", fmt.Errorf("%w: %s must be at most %d characters", ErrInvalidMetadata, field, maxLength)
+	case strings.IndexFunc(value, func(r rune) bool { return r != '\n' && unicode.IsControl(r) }) != -1:
+		return "", fmt.Errorf("%w: %s must not contain control characters", ErrInvalidMetadata, field)
+	}
+	return value, nil
+}
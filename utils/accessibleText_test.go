@@ -0,0 +1,61 @@
+package utils
+
+import (
+	"errors"
+	"strings"
+	"testing"
+)
+
+func TestSetAccessibleText(t *testing.T) {
+	value := "  A red car  "
+	current, variants, err := SetAccessibleText(MetaAltText, "", map[string]string{"fr": "Une voiture"}, &value, map[string]string{"pt-BR": "Um carro", "fr": ""})
+	if err != nil {
+		t.Fatalf("SetAccessibleText: %v", err)
+	}
+	if current != "A red car" {
+		t.Errorf("default = %q, want %q", current, "A red car")
+	}
+	if len(variants) != 1 || variants["pt-br"] != "Um carro" {
+		t.Errorf("variants = %v, want only pt-br", variants)
+	}
+
+	current, variants, err = SetAccessibleText(MetaAltText, "kept", nil, nil, map[string]string{"de": ""})
+	if err != nil || current != "kept" || variants != nil {
+		t.Errorf("SetAccessibleText without a value = %q, %v, %v, want %q, nil, nil", current, variants, err, "kept")
+	}
+
+	long := strings.Repeat("x", MaxAltTextLength+1)
+	if _, _, err := SetAccessibleText(MetaAltText, "", nil, &long, nil); !errors.Is(err, ErrInvalidMetadata) {
+		t.Errorf("alt text over the limit returned %v, want ErrInvalidMetadata", err)
+	}
+	if _, _, err := SetAccessibleText(MetaCaption, "", nil, &long, nil); err != nil {
+		t.Errorf("caption of %d characters: %v", len(long), err)
+	}
+	if _, _, err := SetAccessibleText(MetaCaption, "", nil, nil, map[string]string{"not a tag!": "x"}); !errors.Is(err, ErrInvalidMetadata) {
+		t.Errorf("invalid language returned %v, want ErrInvalidMetadata", err)
+	}
+	control := "tab\tinside"
+	if _, _, err := SetAccessibleText(MetaCaption, "", nil, &control, nil); !errors.Is(err, ErrInvalidMetadata) {
+		t.Errorf("control character returned %v, want ErrInvalidMetadata", err)
+	}
+}
+
+func TestValidateNewMetadataSize(t *testing.T) {
+	reserved := 0
+	for k, maxSize := range storedMetadataSizes {
+		reserved += len(k) + maxSize
+	}
+
+	fits := map[string]string{"note": strings.Repeat("x", MaxMetadataSize-reserved-len("note"))}
+	if err := ValidateNewMetadataSize(fits); err != nil {
+		t.Errorf("metadata that leaves room for the stored fields: %v", err)
+	}
+
+	fits["note"] += "x"
+	if err := ValidateNewMetadataSize(fits); !errors.Is(err, ErrInvalidMetadata) {
+		t.Errorf("metadata without room for the stored fields returned %v, want ErrInvalidMetadata", err)
+	}
+	if err := ValidateMetadataSize(fits); err != nil {
+		t.Errorf("ValidateMetadataSize: %v", err)
+	}
+}
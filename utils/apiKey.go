@@ -0,0 +1,17 @@
+package utils
+
+import "strings"
+
+// APIKeyPrefix starts every API key, followed by the key ID, "_" and the
+// secret.
+const APIKeyPrefix = "gk_"
+
+// ParseAPIKey splits an API key into its ID and secret. It only checks the
+// format; the key still has to be validated.
+func ParseAPIKey(key string) (string, string, bool) {
+	rest, ok := strings.CutPrefix(key, APIKeyPrefix)
+	if !ok {
+		return "", "", false
+	}
+	return strings.Cut(rest, "_")
+}
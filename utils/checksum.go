@@ -0,0 +1,80 @@
+package utils
+
+import (
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/binary"
+	"fmt"
+	"hash"
+	"hash/crc32"
+	"io"
+)
+
+var crc32cTable = crc32.MakeTable(crc32.Castagnoli)
+
+// Checksums holds base64 encoded digests in the format S3 uses for its
+// checksum fields.
+type Checksums struct {
+	SHA256 string
+	CRC32C string
+}
+
+func ComputeChecksums(data []byte) Checksums {
+	sha := sha256.Sum256(data)
+	crc := make([]byte, 4)
+	binary.BigEndian.PutUint32(crc, crc32.Checksum(data, crc32cTable))
+
+	return Checksums{
+		SHA256: base64.StdEncoding.EncodeToString(sha[:]),
+		CRC32C: base64.StdEncoding.EncodeToString(crc),
+	}
+}
+
+// VerifyingReader computes checksums of everything read through it and
+// returns ErrChecksumMismatch instead of io.EOF when they differ from the
+// expected values. Empty expected values are not checked.
+type VerifyingReader struct {
+	reader   io.Reader
+	expected Checksums
+	sha256   hash.Hash
+	crc32c   hash.Hash32
+}
+
+func NewVerifyingReader(reader io.Reader, expected Checksums) *VerifyingReader {
+	return &VerifyingReader{
+		reader:   reader,
+		expected: expected,
+		sha256:   sha256.New(),
+		crc32c:   crc32.New(crc32cTable),
+	}
+}
+
+func (r *VerifyingReader) Read(p []byte) (int, error) {
+	n, err := r.reader.Read(p)
+	r.sha256.Write(p[:n])
+	r.crc32c.Write(p[:n])
+
+	if err == io.EOF {
+		if verr := r.verify(); verr != nil {
+			return n, verr
+		}
+	}
+
+	return n, err
+}
+
+func (r *VerifyingReader) verify() error {
+	actual := Checksums{
+		SHA256: base64.StdEncoding.EncodeToString(r.sha256.Sum(nil)),
+		CRC32C: base64.StdEncoding.EncodeToString(r.crc32c.Sum(nil)),
+	}
+
+	if r.expected.SHA256 != "" && r.expected.SHA256 != actual.SHA256 {
+		return fmt.Errorf("%w: sha256 expected %s, got %s", ErrChecksumMismatch, r.expected.SHA256, actual.SHA256)
+	}
+	if r.expected.CRC32C != "" && r.expected.CRC32C != actual.CRC32C {
+		return fmt.Errorf("%w: crc32c expected %s, got %s", ErrChecksumMismatch, r.expected.CRC32C, actual.CRC32C)
+	}
+
+	return nil
+}
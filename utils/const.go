@@ -6,3 +6,89 @@ var ValidImageTypes = []string{
 	"image/png",
 	"image/svg+xml",
 }
+
+// Key prefixes used by the service itself. Objects under these prefixes are
+// hidden from listings.
+const (
+	OriginalsPrefix = "_originals/"
+	ContentPrefix   = "_content/"
+	RefsPrefix      = "_refs/"
+	AlbumsPrefix    = "_albums/"
+	// ReferencesPrefix holds the external references of objects, unlike
+	// RefsPrefix which tracks dedup content.
+	ReferencesPrefix = "_references/"
+	APIKeysPrefix    = "_apikeys/"
+	ACLsPrefix       = "_acls/"
+	SharesPrefix     = "_shares/"
+	JobsPrefix       = "_jobs/"
+	// TextsPrefix holds the alt texts and captions of objects.
+	TextsPrefix = "_texts/"
+	// ShareIndexPrefix holds the tokens of the share links to each object.
+	ShareIndexPrefix = "_shareindex/"
+	// PHashPrefix holds the index of the perceptual hashes of a tenant.
+	PHashPrefix = "_phash/"
+	// TenantsPrefix holds the objects of each tenant; see TenantKey.
+	TenantsPrefix = "_tenants/"
+)
+
+var ReservedPrefixes = []string{
+	OriginalsPrefix,
+	ContentPrefix,
+	RefsPrefix,
+	AlbumsPrefix,
+	ReferencesPrefix,
+	APIKeysPrefix,
+	ACLsPrefix,
+	TextsPrefix,
+	SharesPrefix,
+	ShareIndexPrefix,
+	PHashPrefix,
+	JobsPrefix,
+	TenantsPrefix,
+}
+
+// Object metadata keys. S3 returns user metadata keys in lower case, so they
+// are defined that way here.
+const (
+	// MetaCustomPrefix prefixes client supplied metadata fields.
+	MetaCustomPrefix = "custom-"
+
+	MetaTitle         = "title"
+	MetaBlurHash      = "blurhash"
+	MetaDominantColor = "dominant-color"
+	MetaAverageColor  = "average-color"
+	MetaPHash         = "phash"
+	MetaUploadedBy    = "uploaded-by"
+	// MetaOwner is the subject that owns the object: the first uploader. It
+	// survives replacements, unlike MetaUploadedBy.
+	MetaOwner = "owner"
+	// MetaObjectID identifies an object across renames and replacements. An
+	// object deleted and uploaded again under the same key gets a new one.
+	MetaObjectID = "object-id"
+
+	// MetaAltText and MetaCaption held the default value, and variants by
+	// language the key followed by "-" and the language, before alt texts and
+	// captions moved to documents under TextsPrefix. They are still read from
+	// objects that have no such document.
+	MetaAltText = "alt-text"
+	MetaCaption = "caption"
+
+	MetaOriginalSize        = "original-size"
+	MetaOriginalContentType = "original-content-type"
+	MetaOriginalKey         = "original-key"
+
+	MetaContentRef  = "content-ref"
+	MetaContentHash = "content-sha256"
+	MetaContentSize = "content-size"
+
+	MetaChecksumSHA256 = "checksum-sha256"
+	MetaChecksumCRC32C = "checksum-crc32c"
+
+	// Envelope encrypted objects store their wrapped data key, the version
+	// of the master key that wrapped it, the chunk size and the plaintext
+	// size; see EncryptEnvelope.
+	MetaEnvelopeKey        = "envelope-key"
+	MetaEnvelopeKeyVersion = "envelope-key-version"
+	MetaEnvelopeChunkSize  = "envelope-chunk-size"
+	MetaEnvelopeSize       = "envelope-size"
+)
@@ -0,0 +1,298 @@
+package utils
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"encoding/base64"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"regexp"
+	"strings"
+)
+
+// Envelope encryption: each object is encrypted by the service with its own
+// random data key, and only the data key wrapped with a master key is stored
+// next to it, along with the version of the master key. The storage provider
+// never sees the plaintext or the master key. Rotating the master key only
+// re-wraps the data keys; the objects are not re-encrypted.
+//
+// The object is split into chunks of EnvelopeChunkSize bytes, each sealed
+// with AES-256-GCM so it can be decrypted while streaming. The nonce of a
+// chunk is its index, and its last byte marks the final chunk, so chunks
+// cannot be reordered or the object truncated without failing decryption.
+// Nonces never repeat because every data key encrypts a single object.
+
+// EnvelopeChunkSize is the plaintext size of a chunk.
+const EnvelopeChunkSize = 64 * 1024
+
+const dataKeySize = 32
+
+// MasterKey wraps the data keys of envelope encrypted objects.
+type MasterKey struct {
+	aead cipher.AEAD
+}
+
+// parseMasterKey parses a base64 encoded 256-bit key.
+func parseMasterKey(value string) (*MasterKey, error) {
+	key, err := base64.StdEncoding.DecodeString(value)
+	if err != nil || len(key) != dataKeySize {
+		return nil, fmt.Errorf("master key must be a base64 encoded 256-bit key")
+	}
+
+	aead, err := newGCM(key)
+	if err != nil {
+		return nil, err
+	}
+
+	return &MasterKey{aead: aead}, nil
+}
+
+// MasterKeys holds the versions of the master key. Data keys are wrapped
+// with the current version; older versions are kept to unwrap the data keys
+// of objects that have not been re-wrapped yet.
+type MasterKeys struct {
+	keys     map[string]*MasterKey
+	versions []string
+	current  string
+}
+
+var keyVersionPattern = regexp.MustCompile(`^[A-Za-z0-9._-]{1,32}$`)
+
+// ParseMasterKeys parses a comma separated list of "version:key" entries,
+// where each key is a base64 encoded 256-bit key. current selects the version
+// used for new data keys and defaults to the last one listed. An empty list
+// returns nil, which disables envelope encryption.
+func ParseMasterKeys(value string, current string) (*MasterKeys, error) {
+	keys := &MasterKeys{keys: map[string]*MasterKey{}}
+	for _, entry := range strings.Split(value, ",") {
+		entry = strings.TrimSpace(entry)
+		if entry == "" {
+			continue
+		}
+
+		version, keyValue, ok := strings.Cut(entry, ":")
+		if !ok || !keyVersionPattern.MatchString(version) {
+			return nil, fmt.Errorf("invalid master key entry: expected version:key with a version of letters, digits, '.', '_' or '-'")
+		}
+		if _, exists := keys.keys[version]; exists {
+			return nil, fmt.Errorf("duplicate master key version %q", version)
+		}
+
+		key, err := parseMasterKey(keyValue)
+		if err != nil {
+			return nil, fmt.Errorf("master key version %q: %v", version, err)
+		}
+		keys.keys[version] = key
+		keys.versions = append(keys.versions, version)
+	}
+
+	if len(keys.versions) == 0 {
+		if current != "" {
+			return nil, fmt.Errorf("current version %q has no master key", current)
+		}
+		return nil, nil
+	}
+
+	keys.current = current
+	if keys.current == "" {
+		keys.current = keys.versions[len(keys.versions)-1]
+	}
+	if _, ok := keys.keys[keys.current]; !ok {
+		return nil, fmt.Errorf("current version %q has no master key", keys.current)
+	}
+
+	return keys, nil
+}
+
+// Current returns the version new data keys are wrapped with.
+func (k *MasterKeys) Current() string {
+	return k.current
+}
+
+// Wrap wraps dataKey with the current version and returns that version.
+func (k *MasterKeys) Wrap(dataKey []byte) (string, string, error) {
+	wrapped, err := k.keys[k.current].Wrap(dataKey)
+	return k.current, wrapped, err
+}
+
+// Unwrap unwraps a data key wrapped with version. Data keys wrapped before
+// versions were recorded have no version and are tried against every key.
+func (k *MasterKeys) Unwrap(version string, wrapped string) ([]byte, error) {
+	if version != "" {
+		key, ok := k.keys[version]
+		if !ok {
+			return nil, fmt.Errorf("%w: master key version %q is not configured", ErrDecryption, version)
+		}
+		return key.Unwrap(wrapped)
+	}
+
+	err := fmt.Errorf("%w: no master key", ErrDecryption)
+	for _, v := range k.versions {
+		var dataKey []byte
+		dataKey, err = k.keys[v].Unwrap(wrapped)
+		if err == nil {
+			return dataKey, nil
+		}
+	}
+	return nil, err
+}
+
+// String lists the versions without revealing the keys.
+func (k *MasterKeys) String() string {
+	return fmt.Sprintf("versions %v, current %s", k.versions, k.current)
+}
+
+// NewDataKey returns a random data key for a single object.
+func NewDataKey() ([]byte, error) {
+	key := make([]byte, dataKeySize)
+	if _, err := rand.Read(key); err != nil {
+		return nil, err
+	}
+	return key, nil
+}
+
+// Wrap encrypts dataKey with the master key, returning it base64 encoded
+// for object metadata.
+func (k *MasterKey) Wrap(dataKey []byte) (string, error) {
+	nonce := make([]byte, k.aead.NonceSize())
+	if _, err := rand.Read(nonce); err != nil {
+		return "", err
+	}
+
+	return base64.StdEncoding.EncodeToString(k.aead.Seal(nonce, nonce, dataKey, nil)), nil
+}
+
+// Unwrap decrypts a data key returned by Wrap.
+func (k *MasterKey) Unwrap(wrapped string) ([]byte, error) {
+	sealed, err := base64.StdEncoding.DecodeString(wrapped)
+	if err != nil || len(sealed) < k.aead.NonceSize() {
+		return nil, fmt.Errorf("%w: malformed data key", ErrDecryption)
+	}
+
+	nonceSize := k.aead.NonceSize()
+	dataKey, err := k.aead.Open(nil, sealed[:nonceSize], sealed[nonceSize:], nil)
+	if err != nil {
+		return nil, fmt.Errorf("%w: data key does not match the master key", ErrDecryption)
+	}
+
+	return dataKey, nil
+}
+
+// String keeps the key out of logs.
+func (k *MasterKey) String() string {
+	return "[redacted]"
+}
+
+// EncryptEnvelope encrypts plaintext with dataKey in chunks of chunkSize.
+func EncryptEnvelope(dataKey []byte, plaintext []byte, chunkSize int) ([]byte, error) {
+	aead, err := newGCM(dataKey)
+	if err != nil {
+		return nil, err
+	}
+
+	chunks := len(plaintext)/chunkSize + 1
+	ciphertext := make([]byte, 0, len(plaintext)+chunks*aead.Overhead())
+	for index, start := uint64(0), 0; ; index++ {
+		end := min(start+chunkSize, len(plaintext))
+		final := end == len(plaintext)
+		ciphertext = aead.Seal(ciphertext, chunkNonce(index, final), plaintext[start:end], nil)
+		if final {
+			return ciphertext, nil
+		}
+		start = end
+	}
+}
+
+// NewEnvelopeReader decrypts a stream written by EncryptEnvelope. Reads fail
+// with ErrDecryption when a chunk was modified, reordered or cut off.
+func NewEnvelopeReader(src io.Reader, dataKey []byte, chunkSize int) (io.Reader, error) {
+	aead, err := newGCM(dataKey)
+	if err != nil {
+		return nil, err
+	}
+
+	return &envelopeReader{
+		src:  src,
+		aead: aead,
+		// One byte more than a sealed chunk, to see whether another chunk
+		// follows.
+		buf: make([]byte, chunkSize+aead.Overhead()+1),
+	}, nil
+}
+
+type envelopeReader struct {
+	src     io.Reader
+	aead    cipher.AEAD
+	buf     []byte
+	pending int
+	out     []byte
+	plain   []byte
+	index   uint64
+	done    bool
+}
+
+func (r *envelopeReader) Read(p []byte) (int, error) {
+	for len(r.plain) == 0 {
+		if r.done {
+			return 0, io.EOF
+		}
+		if err := r.next(); err != nil {
+			return 0, err
+		}
+	}
+
+	n := copy(p, r.plain)
+	r.plain = r.plain[n:]
+	return n, nil
+}
+
+// next decrypts the following chunk into plain.
+func (r *envelopeReader) next() error {
+	n, err := io.ReadFull(r.src, r.buf[r.pending:])
+	n += r.pending
+
+	final := errors.Is(err, io.EOF) || errors.Is(err, io.ErrUnexpectedEOF)
+	if err != nil && !final {
+		return err
+	}
+
+	sealed := r.buf[:n]
+	if !final {
+		sealed = r.buf[:n-1]
+	}
+
+	r.out, err = r.aead.Open(r.out[:0], chunkNonce(r.index, final), sealed, nil)
+	if err != nil {
+		return fmt.Errorf("%w: chunk %d is corrupt", ErrDecryption, r.index)
+	}
+	r.plain = r.out
+
+	if final {
+		r.done = true
+	} else {
+		r.buf[0] = r.buf[n-1]
+		r.pending = 1
+		r.index++
+	}
+	return nil
+}
+
+func chunkNonce(index uint64, final bool) []byte {
+	nonce := make([]byte, 12)
+	binary.BigEndian.PutUint64(nonce[3:11], index)
+	if final {
+		nonce[11] = 1
+	}
+	return nonce
+}
+
+func newGCM(key []byte) (cipher.AEAD, error) {
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	return cipher.NewGCM(block)
+}
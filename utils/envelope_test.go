@@ -0,0 +1,179 @@
+package utils
+
+import (
+	"bytes"
+	"encoding/base64"
+	"errors"
+	"io"
+	"testing"
+)
+
+const testChunkSize = 16
+
+func testDataKey(t *testing.T) []byte {
+	t.Helper()
+	dataKey, err := NewDataKey()
+	if err != nil {
+		t.Fatalf("NewDataKey: %v", err)
+	}
+	return dataKey
+}
+
+func openEnvelope(t *testing.T, dataKey []byte, ciphertext []byte) ([]byte, error) {
+	t.Helper()
+	reader, err := NewEnvelopeReader(bytes.NewReader(ciphertext), dataKey, testChunkSize)
+	if err != nil {
+		t.Fatalf("NewEnvelopeReader: %v", err)
+	}
+	return io.ReadAll(reader)
+}
+
+func TestEnvelopeRoundTrip(t *testing.T) {
+	tests := []struct {
+		name   string
+		size   int
+		chunks int
+	}{
+		{"empty", 0, 1},
+		{"one byte", 1, 1},
+		{"below chunk size", testChunkSize - 1, 1},
+		{"exactly one chunk", testChunkSize, 1},
+		{"one byte over a chunk", testChunkSize + 1, 2},
+		{"exactly three chunks", 3 * testChunkSize, 3},
+		{"partial last chunk", 3*testChunkSize + 5, 4},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			dataKey := testDataKey(t)
+			plaintext := make([]byte, tt.size)
+			for i := range plaintext {
+				plaintext[i] = byte(i)
+			}
+
+			ciphertext, err := EncryptEnvelope(dataKey, plaintext, testChunkSize)
+			if err != nil {
+				t.Fatalf("EncryptEnvelope: %v", err)
+			}
+			if want := tt.size + tt.chunks*16; len(ciphertext) != want {
+				t.Errorf("ciphertext is %d bytes, want %d", len(ciphertext), want)
+			}
+
+			got, err := openEnvelope(t, dataKey, ciphertext)
+			if err != nil {
+				t.Fatalf("reading envelope: %v", err)
+			}
+			if !bytes.Equal(got, plaintext) {
+				t.Errorf("decrypted %x, want %x", got, plaintext)
+			}
+		})
+	}
+}
+
+func TestEnvelopeSmallReads(t *testing.T) {
+	dataKey := testDataKey(t)
+	plaintext := []byte("the quick brown fox jumps over the lazy dog")
+
+	ciphertext, err := EncryptEnvelope(dataKey, plaintext, testChunkSize)
+	if err != nil {
+		t.Fatalf("EncryptEnvelope: %v", err)
+	}
+	reader, err := NewEnvelopeReader(bytes.NewReader(ciphertext), dataKey, testChunkSize)
+	if err != nil {
+		t.Fatalf("NewEnvelopeReader: %v", err)
+	}
+
+	var got []byte
+	buf := make([]byte, 3)
+	for {
+		n, err := reader.Read(buf)
+		got = append(got, buf[:n]...)
+		if errors.Is(err, io.EOF) {
+			break
+		}
+		if err != nil {
+			t.Fatalf("Read: %v", err)
+		}
+	}
+	if !bytes.Equal(got, plaintext) {
+		t.Errorf("decrypted %q, want %q", got, plaintext)
+	}
+}
+
+func TestEnvelopeTampering(t *testing.T) {
+	dataKey := testDataKey(t)
+	plaintext := bytes.Repeat([]byte("x"), 3*testChunkSize)
+	ciphertext, err := EncryptEnvelope(dataKey, plaintext, testChunkSize)
+	if err != nil {
+		t.Fatalf("EncryptEnvelope: %v", err)
+	}
+	sealedChunk := testChunkSize + 16
+
+	swapped := bytes.Clone(ciphertext)
+	copy(swapped[:sealedChunk], ciphertext[sealedChunk:2*sealedChunk])
+	copy(swapped[sealedChunk:2*sealedChunk], ciphertext[:sealedChunk])
+
+	flipped := bytes.Clone(ciphertext)
+	flipped[sealedChunk+3] ^= 1
+
+	tests := []struct {
+		name       string
+		ciphertext []byte
+		dataKey    []byte
+	}{
+		{"no ciphertext", nil, dataKey},
+		{"truncated at a chunk boundary", ciphertext[:2*sealedChunk], dataKey},
+		{"truncated inside a chunk", ciphertext[:2*sealedChunk+5], dataKey},
+		{"last chunk dropped but one byte", ciphertext[:len(ciphertext)-1], dataKey},
+		{"extra data appended", append(bytes.Clone(ciphertext), 0), dataKey},
+		{"chunks reordered", swapped, dataKey},
+		{"bit flipped", flipped, dataKey},
+		{"wrong data key", ciphertext, testDataKey(t)},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := openEnvelope(t, tt.dataKey, tt.ciphertext)
+			if !errors.Is(err, ErrDecryption) {
+				t.Errorf("got error %v, want ErrDecryption", err)
+			}
+		})
+	}
+}
+
+func TestMasterKeysWrap(t *testing.T) {
+	keys, err := ParseMasterKeys("1:"+testMasterKey(1)+",2:"+testMasterKey(2), "")
+	if err != nil {
+		t.Fatalf("ParseMasterKeys: %v", err)
+	}
+	if keys.Current() != "2" {
+		t.Errorf("current version %q, want 2", keys.Current())
+	}
+
+	dataKey := testDataKey(t)
+	version, wrapped, err := keys.Wrap(dataKey)
+	if err != nil {
+		t.Fatalf("Wrap: %v", err)
+	}
+
+	for _, v := range []string{version, ""} {
+		got, err := keys.Unwrap(v, wrapped)
+		if err != nil {
+			t.Fatalf("Unwrap(%q): %v", v, err)
+		}
+		if !bytes.Equal(got, dataKey) {
+			t.Errorf("Unwrap(%q) returned another key", v)
+		}
+	}
+
+	if _, err := keys.Unwrap("1", wrapped); !errors.Is(err, ErrDecryption) {
+		t.Errorf("Unwrap with the wrong version: got %v, want ErrDecryption", err)
+	}
+	if _, err := keys.Unwrap("3", wrapped); !errors.Is(err, ErrDecryption) {
+		t.Errorf("Unwrap with an unknown version: got %v, want ErrDecryption", err)
+	}
+}
+
+func testMasterKey(fill byte) string {
+	return base64.StdEncoding.EncodeToString(bytes.Repeat([]byte{fill}, dataKeySize))
+}
@@ -0,0 +1,38 @@
+package utils
+
+import "errors"
+
+var (
+	ErrNotFound        = errors.New("not found")
+	ErrInvalidKey      = errors.New("invalid key")
+	ErrInvalidTitle    = errors.New("invalid title")
+	ErrInvalidMetadata = errors.New("invalid metadata")
+	ErrInvalidTags     = errors.New("invalid tags")
+	ErrInvalidAlbum    = errors.New("invalid album")
+	ErrInvalidEntity   = errors.New("invalid entity")
+	ErrInvalidAccess   = errors.New("invalid access request")
+	ErrInvalidShare    = errors.New("invalid share request")
+
+	ErrNearDuplicate     = errors.New("a near-duplicate image already exists")
+	ErrDuplicateContent  = errors.New("identical content is already stored")
+	ErrNoPerceptualHash  = errors.New("file has no perceptual hash")
+	ErrWatermarkDisabled = errors.New("watermarking is not configured")
+	ErrUnsupportedImage  = errors.New("image format is not supported for processing")
+	ErrChecksumMismatch  = errors.New("checksum mismatch")
+	ErrObjectTooLarge    = errors.New("the object is too large")
+	ErrReferenced        = errors.New("the object is still referenced")
+
+	ErrEncryptionDisabled = errors.New("envelope encryption is not configured")
+	ErrEncryptedObject    = errors.New("the object is encrypted and can only be downloaded through the service")
+	ErrDecryption         = errors.New("decryption failed")
+	ErrJobRunning         = errors.New("the job is already running")
+	ErrPreconditionFailed = errors.New("the object was changed concurrently")
+
+	ErrInvalidToken         = errors.New("invalid token")
+	ErrInvalidAPIKey        = errors.New("invalid api key")
+	ErrInvalidAPIKeyRequest = errors.New("invalid api key request")
+	ErrForbidden            = errors.New("forbidden")
+
+	ErrShareUnavailable = errors.New("share link is no longer available")
+	ErrInvalidPassword  = errors.New("invalid password")
+)
@@ -0,0 +1,73 @@
+package utils
+
+import (
+	"container/list"
+	"sync"
+)
+
+// ImageCache keeps encoded images in memory up to a total size in bytes,
+// evicting the least recently used ones first.
+type ImageCache struct {
+	maxBytes int
+	mu       sync.Mutex
+	size     int
+	order    *list.List
+	entries  map[string]*list.Element
+}
+
+type cachedImage struct {
+	key         string
+	data        []byte
+	contentType string
+}
+
+func NewImageCache(maxBytes int) *ImageCache {
+	return &ImageCache{
+		maxBytes: maxBytes,
+		order:    list.New(),
+		entries:  make(map[string]*list.Element),
+	}
+}
+
+// Get returns the image cached under key. The data must not be modified.
+func (c *ImageCache) Get(key string) ([]byte, string, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	element, ok := c.entries[key]
+	if !ok {
+		return nil, "", false
+	}
+	c.order.MoveToFront(element)
+
+	entry := element.Value.(*cachedImage)
+	return entry.data, entry.contentType, true
+}
+
+// Add caches data under key. Images larger than the whole cache are not
+// cached.
+func (c *ImageCache) Add(key string, data []byte, contentType string) {
+	if len(data) > c.maxBytes {
+		return
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if element, ok := c.entries[key]; ok {
+		c.remove(element)
+	}
+
+	c.entries[key] = c.order.PushFront(&cachedImage{key: key, data: data, contentType: contentType})
+	c.size += len(data)
+
+	for c.size > c.maxBytes {
+		c.remove(c.order.Back())
+	}
+}
+
+func (c *ImageCache) remove(element *list.Element) {
+	entry := c.order.Remove(element).(*cachedImage)
+	delete(c.entries, entry.key)
+	c.size -= len(entry.data)
+}
@@ -0,0 +1,39 @@
+package utils
+
+import "testing"
+
+func TestImageCacheEviction(t *testing.T) {
+	cache := NewImageCache(10)
+	cache.Add("a", []byte("aaaa"), "image/png")
+	cache.Add("b", []byte("bbbb"), "image/png")
+
+	// Reading a makes b the least recently used image.
+	if _, _, ok := cache.Get("a"); !ok {
+		t.Fatal("a is not cached")
+	}
+	cache.Add("c", []byte("cccc"), "image/jpeg")
+
+	if _, _, ok := cache.Get("b"); ok {
+		t.Error("b was not evicted")
+	}
+	data, contentType, ok := cache.Get("c")
+	if !ok || string(data) != "cccc" || contentType != "image/jpeg" {
+		t.Errorf("Get(c) = %q, %q, %v", data, contentType, ok)
+	}
+	if _, _, ok := cache.Get("a"); !ok {
+		t.Error("a was evicted")
+	}
+
+	cache.Add("a", []byte("a"), "image/png")
+	if cache.size != 5 {
+		t.Errorf("size after replacing a is %d, want 5", cache.size)
+	}
+
+	cache.Add("big", make([]byte, 11), "image/png")
+	if _, _, ok := cache.Get("big"); ok {
+		t.Error("an image larger than the cache was cached")
+	}
+	if cache.size != 5 {
+		t.Errorf("size after a rejected image is %d, want 5", cache.size)
+	}
+}
@@ -0,0 +1,272 @@
+package utils
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"image"
+	"image/color"
+	_ "image/jpeg"
+	_ "image/png"
+	"math"
+	"strings"
+)
+
+const (
+	blurHashCharacters = "0123456789ABCDEFGHIJKLMNOPQRSTUVWXYZabcdefghijklmnopqrstuvwxyz#$%*+,-.:;=?@[]^_{|}~"
+
+	BlurHashXComponents = 4
+	BlurHashYComponents = 3
+
+	// placeholderMaxSide bounds the image used for placeholder computation.
+	// BlurHash and colors only capture low frequencies, so a small thumbnail
+	// gives the same result as the full-size image at a fraction of the cost.
+	placeholderMaxSide = 64
+)
+
+type ImagePlaceholder struct {
+	BlurHash      string
+	DominantColor string
+	AverageColor  string
+}
+
+// DecodeImage decodes the raster formats registered by this package (JPEG
+// and PNG). Formats such as SVG or AVIF return image.ErrFormat.
+func DecodeImage(fileBytes []byte) (image.Image, string, error) {
+	return image.Decode(bytes.NewReader(fileBytes))
+}
+
+func NewImagePlaceholder(img image.Image) (*ImagePlaceholder, error) {
+	thumb := Thumbnail(img, placeholderMaxSide)
+
+	hash, err := EncodeBlurHash(thumb, BlurHashXComponents, BlurHashYComponents)
+	if err != nil {
+		return nil, err
+	}
+
+	dominant, average := imageColors(thumb)
+
+	return &ImagePlaceholder{
+		BlurHash:      hash,
+		DominantColor: hexColor(dominant),
+		AverageColor:  hexColor(average),
+	}, nil
+}
+
+// Thumbnail downsamples img with a box filter so that its longest side is at
+// most maxSide pixels. Smaller images are only converted to NRGBA.
+func Thumbnail(img image.Image, maxSide int) *image.NRGBA {
+	bounds := img.Bounds()
+	srcW, srcH := bounds.Dx(), bounds.Dy()
+
+	dstW, dstH := srcW, srcH
+	if srcW > maxSide || srcH > maxSide {
+		if srcW >= srcH {
+			dstW = maxSide
+			dstH = int(math.Max(1, math.Round(float64(srcH)*float64(maxSide)/float64(srcW))))
+		} else {
+			dstH = maxSide
+			dstW = int(math.Max(1, math.Round(float64(srcW)*float64(maxSide)/float64(srcH))))
+		}
+	}
+
+	return Resize(img, dstW, dstH)
+}
+
+// Resize scales img to exactly width x height. Each destination pixel is the
+// average of the source pixels it covers, or the nearest source pixel when
+// enlarging.
+func Resize(img image.Image, width, height int) *image.NRGBA {
+	bounds := img.Bounds()
+	srcW, srcH := bounds.Dx(), bounds.Dy()
+	dst := image.NewNRGBA(image.Rect(0, 0, width, height))
+	if srcW == 0 || srcH == 0 || width == 0 || height == 0 {
+		return dst
+	}
+
+	for y := 0; y < height; y++ {
+		y0 := bounds.Min.Y + y*srcH/height
+		y1 := bounds.Min.Y + (y+1)*srcH/height
+		if y1 <= y0 {
+			y1 = y0 + 1
+		}
+		for x := 0; x < width; x++ {
+			x0 := bounds.Min.X + x*srcW/width
+			x1 := bounds.Min.X + (x+1)*srcW/width
+			if x1 <= x0 {
+				x1 = x0 + 1
+			}
+
+			var r, g, b, a, n uint64
+			for sy := y0; sy < y1; sy++ {
+				for sx := x0; sx < x1; sx++ {
+					c := color.NRGBA64Model.Convert(img.At(sx, sy)).(color.NRGBA64)
+					r += uint64(c.R)
+					g += uint64(c.G)
+					b += uint64(c.B)
+					a += uint64(c.A)
+					n++
+				}
+			}
+
+			dst.SetNRGBA(x, y, color.NRGBA{
+				R: uint8(r / n >> 8),
+				G: uint8(g / n >> 8),
+				B: uint8(b / n >> 8),
+				A: uint8(a / n >> 8),
+			})
+		}
+	}
+
+	return dst
+}
+
+// EncodeBlurHash implements the BlurHash algorithm (https://blurha.sh).
+func EncodeBlurHash(img *image.NRGBA, xComponents, yComponents int) (string, error) {
+	if xComponents < 1 || xComponents > 9 || yComponents < 1 || yComponents > 9 {
+		return "", errors.New("blurhash components must be between 1 and 9")
+	}
+
+	width, height := img.Bounds().Dx(), img.Bounds().Dy()
+	if width == 0 || height == 0 {
+		return "", errors.New("blurhash requires a non-empty image")
+	}
+
+	factors := make([][3]float64, 0, xComponents*yComponents)
+	for j := 0; j < yComponents; j++ {
+		for i := 0; i < xComponents; i++ {
+			normalisation := 2.0
+			if i == 0 && j == 0 {
+				normalisation = 1.0
+			}
+
+			var r, g, b float64
+			for y := 0; y < height; y++ {
+				for x := 0; x < width; x++ {
+					basis := math.Cos(math.Pi*float64(i)*float64(x)/float64(width)) *
+						math.Cos(math.Pi*float64(j)*float64(y)/float64(height))
+					c := img.NRGBAAt(x, y)
+					r += basis * sRGBToLinear(c.R)
+					g += basis * sRGBToLinear(c.G)
+					b += basis * sRGBToLinear(c.B)
+				}
+			}
+
+			scale := normalisation / float64(width*height)
+			factors = append(factors, [3]float64{r * scale, g * scale, b * scale})
+		}
+	}
+
+	var hash strings.Builder
+	hash.WriteString(encode83((xComponents-1)+(yComponents-1)*9, 1))
+
+	maximumValue := 1.0
+	if len(factors) > 1 {
+		actualMaximum := 0.0
+		for _, factor := range factors[1:] {
+			for _, v := range factor {
+				actualMaximum = math.Max(actualMaximum, math.Abs(v))
+			}
+		}
+		quantisedMaximum := int(math.Max(0, math.Min(82, math.Floor(actualMaximum*166-0.5))))
+		maximumValue = float64(quantisedMaximum+1) / 166
+		hash.WriteString(encode83(quantisedMaximum, 1))
+	} else {
+		hash.WriteString(encode83(0, 1))
+	}
+
+	dc := factors[0]
+	hash.WriteString(encode83(linearToSRGB(dc[0])<<16+linearToSRGB(dc[1])<<8+linearToSRGB(dc[2]), 4))
+
+	for _, factor := range factors[1:] {
+		quant := func(v float64) int {
+			return int(math.Max(0, math.Min(18, math.Floor(signPow(v/maximumValue, 0.5)*9+9.5))))
+		}
+		hash.WriteString(encode83(quant(factor[0])*19*19+quant(factor[1])*19+quant(factor[2]), 2))
+	}
+
+	return hash.String(), nil
+}
+
+// imageColors returns the dominant color, taken as the mean of the most
+// populated 4-bit-per-channel bucket, and the mean color of all visible
+// pixels.
+func imageColors(img *image.NRGBA) (dominant color.NRGBA, average color.NRGBA) {
+	type bucket struct {
+		r, g, b, n uint64
+	}
+	buckets := make(map[uint16]*bucket)
+
+	var total bucket
+	var best *bucket
+	bounds := img.Bounds()
+	for y := bounds.Min.Y; y < bounds.Max.Y; y++ {
+		for x := bounds.Min.X; x < bounds.Max.X; x++ {
+			c := img.NRGBAAt(x, y)
+			if c.A < 16 {
+				continue
+			}
+
+			id := uint16(c.R>>4)<<8 | uint16(c.G>>4)<<4 | uint16(c.B>>4)
+			bk, ok := buckets[id]
+			if !ok {
+				bk = &bucket{}
+				buckets[id] = bk
+			}
+			bk.r += uint64(c.R)
+			bk.g += uint64(c.G)
+			bk.b += uint64(c.B)
+			bk.n++
+
+			total.r += uint64(c.R)
+			total.g += uint64(c.G)
+			total.b += uint64(c.B)
+			total.n++
+
+			if best == nil || bk.n > best.n {
+				best = bk
+			}
+		}
+	}
+
+	if best == nil {
+		return color.NRGBA{A: 0xff}, color.NRGBA{A: 0xff}
+	}
+
+	dominant = color.NRGBA{R: uint8(best.r / best.n), G: uint8(best.g / best.n), B: uint8(best.b / best.n), A: 0xff}
+	average = color.NRGBA{R: uint8(total.r / total.n), G: uint8(total.g / total.n), B: uint8(total.b / total.n), A: 0xff}
+	return dominant, average
+}
+
+func hexColor(c color.NRGBA) string {
+	return fmt.Sprintf("#%02x%02x%02x", c.R, c.G, c.B)
+}
+
+func encode83(value, length int) string {
+	result := make([]byte, length)
+	for i := 1; i <= length; i++ {
+		digit := (value / int(math.Pow(83, float64(length-i)))) % 83
+		result[i-1] = blurHashCharacters[digit]
+	}
+	return string(result)
+}
+
+func sRGBToLinear(value uint8) float64 {
+	v := float64(value) / 255
+	if v <= 0.04045 {
+		return v / 12.92
+	}
+	return math.Pow((v+0.055)/1.055, 2.4)
+}
+
+func linearToSRGB(value float64) int {
+	v := math.Max(0, math.Min(1, value))
+	if v <= 0.0031308 {
+		return int(v*12.92*255 + 0.5)
+	}
+	return int((1.055*math.Pow(v, 1/2.4)-0.055)*255 + 0.5)
+}
+
+func signPow(value, exp float64) float64 {
+	return math.Copysign(math.Pow(math.Abs(value), exp), value)
+}
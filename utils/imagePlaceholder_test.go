@@ -0,0 +1,59 @@
+package utils
+
+import (
+	"image"
+	"image/color"
+	"testing"
+)
+
+// The expected hashes were computed with the reference TypeScript encoder
+// (https://github.com/woltapp/blurhash, encode.ts) on the same pixels.
+func TestEncodeBlurHash(t *testing.T) {
+	gradient := image.NewNRGBA(image.Rect(0, 0, 8, 6))
+	for y := 0; y < 6; y++ {
+		for x := 0; x < 8; x++ {
+			gradient.SetNRGBA(x, y, color.NRGBA{
+				R: uint8(x * 255 / 7),
+				G: uint8(y * 255 / 5),
+				B: uint8((x + y) * 37 % 256),
+				A: 0xff,
+			})
+		}
+	}
+
+	black := image.NewNRGBA(image.Rect(0, 0, 4, 4))
+	for i := 3; i < len(black.Pix); i += 4 {
+		black.Pix[i] = 0xff
+	}
+
+	tests := []struct {
+		name        string
+		img         *image.NRGBA
+		xComponents int
+		yComponents int
+		want        string
+	}{
+		{"gradient", gradient, 4, 3, "LyI5ey3BfNxtz3NGfKnQeWf3fOfC"},
+		{"gradient DC only", gradient, 1, 1, "00I5ey"},
+		{"black", black, 4, 3, "L00000fQfQfQfQfQfQfQfQfQfQfQ"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := EncodeBlurHash(tt.img, tt.xComponents, tt.yComponents)
+			if err != nil {
+				t.Fatalf("EncodeBlurHash: %v", err)
+			}
+			if got != tt.want {
+				t.Errorf("EncodeBlurHash = %q, want %q", got, tt.want)
+			}
+		})
+	}
+
+	if _, err := EncodeBlurHash(gradient, 0, 3); err == nil {
+		t.Error("0 components accepted")
+	}
+	if _, err := EncodeBlurHash(image.NewNRGBA(image.Rect(0, 0, 0, 0)), 4, 3); err == nil {
+		t.Error("empty image accepted")
+	}
+}
@@ -0,0 +1,303 @@
+package utils
+
+import (
+	"crypto"
+	"crypto/hmac"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/json"
+	"encoding/pem"
+	"fmt"
+	"math/big"
+	"os"
+	"slices"
+	"strings"
+	"time"
+)
+
+const (
+	JWTAlgorithmHS256 = "HS256"
+	JWTAlgorithmRS256 = "RS256"
+
+	// jwtLeeway tolerates clock skew when checking exp and nbf.
+	jwtLeeway = time.Minute
+)
+
+// JWTKeys holds the keys tokens may be signed with, by key ID. Keys loaded
+// without an ID (a static secret or PEM file) use the empty ID.
+type JWTKeys struct {
+	hmac map[string][]byte
+	rsa  map[string]*rsa.PublicKey
+}
+
+// LoadJWTKeys loads an HS256 secret, an RS256 public key from a PEM file
+// and/or the keys of a JWKS file. Empty arguments are skipped, but at least
+// one key is required.
+func LoadJWTKeys(secret string, publicKeyPath string, jwksPath string) (*JWTKeys, error) {
+	keys := &JWTKeys{
+		hmac: map[string][]byte{},
+		rsa:  map[string]*rsa.PublicKey{},
+	}
+
+	if secret != "" {
+		keys.hmac[""] = []byte(secret)
+	}
+
+	if publicKeyPath != "" {
+		publicKey, err := loadRSAPublicKey(publicKeyPath)
+		if err != nil {
+			return nil, err
+		}
+		keys.rsa[""] = publicKey
+	}
+
+	if jwksPath != "" {
+		err := keys.loadJWKS(jwksPath)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	if len(keys.hmac) == 0 && len(keys.rsa) == 0 {
+		return nil, fmt.Errorf("no JWT keys configured")
+	}
+
+	return keys, nil
+}
+
+func loadRSAPublicKey(path string) (*rsa.PublicKey, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("error reading JWT public key: %v", err)
+	}
+
+	block, _ := pem.Decode(data)
+	if block == nil {
+		return nil, fmt.Errorf("JWT public key %s is not PEM encoded", path)
+	}
+
+	var publicKey any
+	switch block.Type {
+	case "CERTIFICATE":
+		var certificate *x509.Certificate
+		certificate, err = x509.ParseCertificate(block.Bytes)
+		if err == nil {
+			publicKey = certificate.PublicKey
+		}
+	case "RSA PUBLIC KEY":
+		publicKey, err = x509.ParsePKCS1PublicKey(block.Bytes)
+	default:
+		publicKey, err = x509.ParsePKIXPublicKey(block.Bytes)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("error parsing JWT public key: %v", err)
+	}
+
+	rsaKey, ok := publicKey.(*rsa.PublicKey)
+	if !ok {
+		return nil, fmt.Errorf("JWT public key %s is not an RSA key", path)
+	}
+	return rsaKey, nil
+}
+
+type jwks struct {
+	Keys []struct {
+		Kty string `json:"kty"`
+		Kid string `json:"kid"`
+		Use string `json:"use"`
+		N   string `json:"n"`
+		E   string `json:"e"`
+		K   string `json:"k"`
+	} `json:"keys"`
+}
+
+// loadJWKS adds the RSA and symmetric ("oct") signing keys of a JWKS file.
+func (k *JWTKeys) loadJWKS(path string) error {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return fmt.Errorf("error reading JWKS: %v", err)
+	}
+
+	var set jwks
+	err = json.Unmarshal(data, &set)
+	if err != nil {
+		return fmt.Errorf("error parsing JWKS: %v", err)
+	}
+
+	for _, key := range set.Keys {
+		if key.Use != "" && key.Use != "sig" {
+			continue
+		}
+
+		switch key.Kty {
+		case "RSA":
+			n, errN := base64.RawURLEncoding.DecodeString(key.N)
+			e, errE := base64.RawURLEncoding.DecodeString(key.E)
+			if errN != nil || errE != nil || len(e) > 4 {
+				return fmt.Errorf("invalid RSA key %q in JWKS", key.Kid)
+			}
+			k.rsa[key.Kid] = &rsa.PublicKey{
+				N: new(big.Int).SetBytes(n),
+				E: int(new(big.Int).SetBytes(e).Int64()),
+			}
+		case "oct":
+			secret, err := base64.RawURLEncoding.DecodeString(key.K)
+			if err != nil {
+				return fmt.Errorf("invalid symmetric key %q in JWKS", key.Kid)
+			}
+			k.hmac[key.Kid] = secret
+		}
+	}
+	return nil
+}
+
+// JWTVerifier checks the signature and registered claims of tokens. Issuer
+// and Audience are only checked when set.
+type JWTVerifier struct {
+	Keys     *JWTKeys
+	Issuer   string
+	Audience string
+}
+
+type jwtHeader struct {
+	Alg string `json:"alg"`
+	Kid string `json:"kid"`
+}
+
+// Verify returns the claims of a valid compact-serialized token.
+func (v *JWTVerifier) Verify(token string) (map[string]any, error) {
+	parts := strings.Split(token, ".")
+	if len(parts) != 3 {
+		return nil, fmt.Errorf("%w: malformed token", ErrInvalidToken)
+	}
+
+	var header jwtHeader
+	err := decodeJWTPart(parts[0], &header)
+	if err != nil {
+		return nil, err
+	}
+
+	signature, err := base64.RawURLEncoding.DecodeString(parts[2])
+	if err != nil {
+		return nil, fmt.Errorf("%w: malformed signature", ErrInvalidToken)
+	}
+
+	err = v.verifySignature(header, parts[0]+"."+parts[1], signature)
+	if err != nil {
+		return nil, err
+	}
+
+	var claims map[string]any
+	err = decodeJWTPart(parts[1], &claims)
+	if err != nil {
+		return nil, err
+	}
+
+	err = v.verifyClaims(claims)
+	if err != nil {
+		return nil, err
+	}
+
+	return claims, nil
+}
+
+func (v *JWTVerifier) verifySignature(header jwtHeader, signingInput string, signature []byte) error {
+	digest := sha256.Sum256([]byte(signingInput))
+
+	switch header.Alg {
+	case JWTAlgorithmHS256:
+		secret := findJWTKey(v.Keys.hmac, header.Kid)
+		if secret == nil {
+			return fmt.Errorf("%w: unknown HS256 key %q", ErrInvalidToken, header.Kid)
+		}
+		mac := hmac.New(sha256.New, secret)
+		mac.Write([]byte(signingInput))
+		if !hmac.Equal(signature, mac.Sum(nil)) {
+			return fmt.Errorf("%w: invalid signature", ErrInvalidToken)
+		}
+	case JWTAlgorithmRS256:
+		publicKey := findJWTKey(v.Keys.rsa, header.Kid)
+		if publicKey == nil {
+			return fmt.Errorf("%w: unknown RS256 key %q", ErrInvalidToken, header.Kid)
+		}
+		if rsa.VerifyPKCS1v15(publicKey, crypto.SHA256, digest[:], signature) != nil {
+			return fmt.Errorf("%w: invalid signature", ErrInvalidToken)
+		}
+	default:
+		return fmt.Errorf("%w: unsupported algorithm %q", ErrInvalidToken, header.Alg)
+	}
+	return nil
+}
+
+// findJWTKey looks a key up by ID. Tokens without a key ID may use the only
+// key of their algorithm.
+func findJWTKey[K any](keys map[string]K, kid string) K {
+	var zero K
+	if key, ok := keys[kid]; ok {
+		return key
+	}
+	if kid == "" && len(keys) == 1 {
+		for _, key := range keys {
+			return key
+		}
+	}
+	return zero
+}
+
+func (v *JWTVerifier) verifyClaims(claims map[string]any) error {
+	now := time.Now()
+
+	exp, ok := claims["exp"].(float64)
+	if !ok {
+		return fmt.Errorf("%w: exp claim is required", ErrInvalidToken)
+	}
+	if now.After(time.Unix(int64(exp), 0).Add(jwtLeeway)) {
+		return fmt.Errorf("%w: token is expired", ErrInvalidToken)
+	}
+
+	if nbf, ok := claims["nbf"].(float64); ok && now.Add(jwtLeeway).Before(time.Unix(int64(nbf), 0)) {
+		return fmt.Errorf("%w: token is not valid yet", ErrInvalidToken)
+	}
+
+	if v.Issuer != "" && claims["iss"] != v.Issuer {
+		return fmt.Errorf("%w: unexpected issuer", ErrInvalidToken)
+	}
+
+	if v.Audience != "" && !slices.Contains(JWTStrings(claims["aud"]), v.Audience) {
+		return fmt.Errorf("%w: unexpected audience", ErrInvalidToken)
+	}
+
+	return nil
+}
+
+// JWTStrings reads a claim that holds a string or an array of strings.
+func JWTStrings(claim any) []string {
+	switch value := claim.(type) {
+	case string:
+		return []string{value}
+	case []any:
+		values := make([]string, 0, len(value))
+		for _, item := range value {
+			if s, ok := item.(string); ok {
+				values = append(values, s)
+			}
+		}
+		return values
+	}
+	return nil
+}
+
+func decodeJWTPart(part string, v any) error {
+	data, err := base64.RawURLEncoding.DecodeString(part)
+	if err != nil {
+		return fmt.Errorf("%w: malformed token", ErrInvalidToken)
+	}
+
+	err = json.Unmarshal(data, v)
+	if err != nil {
+		return fmt.Errorf("%w: malformed token", ErrInvalidToken)
+	}
+	return nil
+}
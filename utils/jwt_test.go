@@ -0,0 +1,228 @@
+package utils
+
+import (
+	"crypto"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"math/big"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func encodeJWTPart(t *testing.T, v any) string {
+	t.Helper()
+	data, err := json.Marshal(v)
+	if err != nil {
+		t.Fatalf("json.Marshal: %v", err)
+	}
+	return base64.RawURLEncoding.EncodeToString(data)
+}
+
+func signHS256(t *testing.T, header map[string]any, claims map[string]any, secret []byte) string {
+	t.Helper()
+	signingInput := encodeJWTPart(t, header) + "." + encodeJWTPart(t, claims)
+	mac := hmac.New(sha256.New, secret)
+	mac.Write([]byte(signingInput))
+	return signingInput + "." + base64.RawURLEncoding.EncodeToString(mac.Sum(nil))
+}
+
+func signRS256(t *testing.T, header map[string]any, claims map[string]any, key *rsa.PrivateKey) string {
+	t.Helper()
+	signingInput := encodeJWTPart(t, header) + "." + encodeJWTPart(t, claims)
+	digest := sha256.Sum256([]byte(signingInput))
+	signature, err := rsa.SignPKCS1v15(rand.Reader, key, crypto.SHA256, digest[:])
+	if err != nil {
+		t.Fatalf("rsa.SignPKCS1v15: %v", err)
+	}
+	return signingInput + "." + base64.RawURLEncoding.EncodeToString(signature)
+}
+
+// writeJWKS writes a JWKS with two symmetric keys and one RSA key.
+func writeJWKS(t *testing.T, secretA []byte, secretB []byte, rsaKey *rsa.PublicKey) string {
+	t.Helper()
+	set := map[string]any{"keys": []map[string]any{
+		{"kty": "oct", "kid": "a", "use": "sig", "k": base64.RawURLEncoding.EncodeToString(secretA)},
+		{"kty": "oct", "kid": "b", "k": base64.RawURLEncoding.EncodeToString(secretB)},
+		{"kty": "oct", "kid": "enc", "use": "enc", "k": base64.RawURLEncoding.EncodeToString(secretA)},
+		{
+			"kty": "RSA", "kid": "r",
+			"n": base64.RawURLEncoding.EncodeToString(rsaKey.N.Bytes()),
+			"e": base64.RawURLEncoding.EncodeToString(big.NewInt(int64(rsaKey.E)).Bytes()),
+		},
+	}}
+	data, err := json.Marshal(set)
+	if err != nil {
+		t.Fatalf("json.Marshal: %v", err)
+	}
+
+	path := filepath.Join(t.TempDir(), "jwks.json")
+	if err := os.WriteFile(path, data, 0o600); err != nil {
+		t.Fatalf("os.WriteFile: %v", err)
+	}
+	return path
+}
+
+func TestJWTVerifierKeys(t *testing.T) {
+	secretA := []byte("secret-a-0123456789abcdef0123456")
+	secretB := []byte("secret-b-0123456789abcdef0123456")
+	rsaKey, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatalf("rsa.GenerateKey: %v", err)
+	}
+	otherRSAKey, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatalf("rsa.GenerateKey: %v", err)
+	}
+
+	keys, err := LoadJWTKeys("", "", writeJWKS(t, secretA, secretB, &rsaKey.PublicKey))
+	if err != nil {
+		t.Fatalf("LoadJWTKeys: %v", err)
+	}
+	verifier := &JWTVerifier{Keys: keys}
+	claims := map[string]any{"sub": "user", "exp": time.Now().Add(time.Hour).Unix()}
+
+	hs256 := func(kid string) map[string]any {
+		header := map[string]any{"alg": JWTAlgorithmHS256, "typ": "JWT"}
+		if kid != "" {
+			header["kid"] = kid
+		}
+		return header
+	}
+
+	tests := []struct {
+		name  string
+		token string
+		valid bool
+	}{
+		{"HS256 with kid a", signHS256(t, hs256("a"), claims, secretA), true},
+		{"HS256 with kid b", signHS256(t, hs256("b"), claims, secretB), true},
+		{"HS256 signed with the key of another kid", signHS256(t, hs256("a"), claims, secretB), false},
+		{"HS256 with an unknown kid", signHS256(t, hs256("c"), claims, secretA), false},
+		{"HS256 without kid among several keys", signHS256(t, hs256(""), claims, secretA), false},
+		{"HS256 with an encryption key", signHS256(t, hs256("enc"), claims, secretA), false},
+		{"RS256 with kid r", signRS256(t, map[string]any{"alg": JWTAlgorithmRS256, "kid": "r"}, claims, rsaKey), true},
+		{"RS256 without kid uses the only RSA key", signRS256(t, map[string]any{"alg": JWTAlgorithmRS256}, claims, rsaKey), true},
+		{"RS256 signed with another key", signRS256(t, map[string]any{"alg": JWTAlgorithmRS256, "kid": "r"}, claims, otherRSAKey), false},
+		{"HS256 with the kid of an RSA key", signHS256(t, hs256("r"), claims, secretA), false},
+		{"RS256 with the kid of a symmetric key", signRS256(t, map[string]any{"alg": JWTAlgorithmRS256, "kid": "a"}, claims, rsaKey), false},
+		{"alg none", encodeJWTPart(t, map[string]any{"alg": "none"}) + "." + encodeJWTPart(t, claims) + ".", false},
+		{"unsupported alg", signHS256(t, map[string]any{"alg": "HS512", "kid": "a"}, claims, secretA), false},
+		{"two parts", encodeJWTPart(t, hs256("a")) + "." + encodeJWTPart(t, claims), false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := verifier.Verify(tt.token)
+			if tt.valid && err != nil {
+				t.Errorf("Verify: %v", err)
+			}
+			if !tt.valid && !errors.Is(err, ErrInvalidToken) {
+				t.Errorf("Verify returned %v, want ErrInvalidToken", err)
+			}
+		})
+	}
+}
+
+func TestJWTVerifierTamperedPayload(t *testing.T) {
+	secret := []byte("secret-0123456789abcdef0123456789")
+	keys, err := LoadJWTKeys(string(secret), "", "")
+	if err != nil {
+		t.Fatalf("LoadJWTKeys: %v", err)
+	}
+	verifier := &JWTVerifier{Keys: keys}
+
+	header := map[string]any{"alg": JWTAlgorithmHS256}
+	token := signHS256(t, header, map[string]any{"sub": "user", "exp": time.Now().Add(time.Hour).Unix()}, secret)
+	if _, err := verifier.Verify(token); err != nil {
+		t.Fatalf("Verify: %v", err)
+	}
+
+	parts := strings.Split(token, ".")
+	parts[1] = encodeJWTPart(t, map[string]any{"sub": "admin", "exp": time.Now().Add(time.Hour).Unix()})
+	if _, err := verifier.Verify(strings.Join(parts, ".")); !errors.Is(err, ErrInvalidToken) {
+		t.Errorf("Verify of a tampered payload returned %v, want ErrInvalidToken", err)
+	}
+}
+
+func TestJWTVerifierClaims(t *testing.T) {
+	secret := []byte("secret-0123456789abcdef0123456789")
+	keys, err := LoadJWTKeys(string(secret), "", "")
+	if err != nil {
+		t.Fatalf("LoadJWTKeys: %v", err)
+	}
+
+	now := time.Now()
+	valid := now.Add(time.Hour).Unix()
+
+	tests := []struct {
+		name     string
+		claims   map[string]any
+		issuer   string
+		audience string
+		valid    bool
+	}{
+		{"valid", map[string]any{"exp": valid}, "", "", true},
+		{"no exp", map[string]any{}, "", "", false},
+		{"exp as a string", map[string]any{"exp": "9999999999"}, "", "", false},
+		{"expired beyond the leeway", map[string]any{"exp": now.Add(-jwtLeeway - 10*time.Second).Unix()}, "", "", false},
+		{"expired within the leeway", map[string]any{"exp": now.Add(-jwtLeeway + 10*time.Second).Unix()}, "", "", true},
+		{"nbf beyond the leeway", map[string]any{"exp": valid, "nbf": now.Add(jwtLeeway + 10*time.Second).Unix()}, "", "", false},
+		{"nbf within the leeway", map[string]any{"exp": valid, "nbf": now.Add(jwtLeeway - 10*time.Second).Unix()}, "", "", true},
+		{"nbf in the past", map[string]any{"exp": valid, "nbf": now.Add(-time.Hour).Unix()}, "", "", true},
+		{"expected issuer", map[string]any{"exp": valid, "iss": "https://issuer"}, "https://issuer", "", true},
+		{"unexpected issuer", map[string]any{"exp": valid, "iss": "https://other"}, "https://issuer", "", false},
+		{"missing issuer", map[string]any{"exp": valid}, "https://issuer", "", false},
+		{"aud string", map[string]any{"exp": valid, "aud": "api"}, "", "api", true},
+		{"aud array", map[string]any{"exp": valid, "aud": []string{"web", "api"}}, "", "api", true},
+		{"aud array without the audience", map[string]any{"exp": valid, "aud": []string{"web", "mobile"}}, "", "api", false},
+		{"aud string of another audience", map[string]any{"exp": valid, "aud": "web"}, "", "api", false},
+		{"missing aud", map[string]any{"exp": valid}, "", "api", false},
+		{"aud ignored when not configured", map[string]any{"exp": valid, "aud": "web"}, "", "", true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			verifier := &JWTVerifier{Keys: keys, Issuer: tt.issuer, Audience: tt.audience}
+			token := signHS256(t, map[string]any{"alg": JWTAlgorithmHS256}, tt.claims, secret)
+
+			_, err := verifier.Verify(token)
+			if tt.valid && err != nil {
+				t.Errorf("Verify: %v", err)
+			}
+			if !tt.valid && !errors.Is(err, ErrInvalidToken) {
+				t.Errorf("Verify returned %v, want ErrInvalidToken", err)
+			}
+		})
+	}
+}
+
+func TestJWTStrings(t *testing.T) {
+	tests := []struct {
+		name  string
+		claim any
+		want  []string
+	}{
+		{"string", "a", []string{"a"}},
+		{"array", []any{"a", "b"}, []string{"a", "b"}},
+		{"array with other types", []any{"a", 1.0, true, "b"}, []string{"a", "b"}},
+		{"number", 1.0, nil},
+		{"missing", nil, nil},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := JWTStrings(tt.claim)
+			if strings.Join(got, ",") != strings.Join(tt.want, ",") || (got == nil) != (tt.want == nil) {
+				t.Errorf("JWTStrings(%v) = %q, want %q", tt.claim, got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,252 @@
+package utils
+
+import (
+	"fmt"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"time"
+	"unicode"
+	"unicode/utf8"
+
+	"github.com/google/uuid"
+	"golang.org/x/text/unicode/norm"
+)
+
+// Object key naming strategies. Each one is a shorthand for a key template.
+const (
+	KeyStrategyLegacy   = "legacy"
+	KeyStrategyUUID     = "uuid"
+	KeyStrategyDate     = "date"
+	KeyStrategySlug     = "slug"
+	KeyStrategyHash     = "hash"
+	KeyStrategyTemplate = "template"
+)
+
+var keyStrategyTemplates = map[string]string{
+	KeyStrategyLegacy: "{slug}_{uuid}{ext}",
+	KeyStrategyUUID:   "{uuid}{ext}",
+	KeyStrategyDate:   "{yyyy}/{mm}/{dd}/{uuid}{ext}",
+	KeyStrategySlug:   "{slug}{ext}",
+	KeyStrategyHash:   "{hash}{ext}",
+}
+
+const (
+	MaxTitleLength = 100
+	MaxSlugLength  = 80
+	// MaxKeyLength is the S3 limit on object key length, in bytes.
+	MaxKeyLength = 1024
+
+	reservedTitleCharacters = `/\`
+)
+
+var keyPlaceholder = regexp.MustCompile(`\{[a-z]+\}`)
+
+var keyPlaceholders = map[string]bool{
+	"{title}": true,
+	"{slug}":  true,
+	"{uuid}":  true,
+	"{hash}":  true,
+	"{yyyy}":  true,
+	"{mm}":    true,
+	"{dd}":    true,
+	"{ext}":   true,
+}
+
+// KeyTemplate renders object keys from placeholders:
+//
+//	{slug}  slugified title
+//	{title} same as {slug}; titles never reach a key unslugified
+//	{uuid}  random UUID    {hash} SHA-256 of the content (hex)
+//	{yyyy} {mm} {dd}       upload date (UTC)
+//	{ext}   file extension including the dot
+type KeyTemplate string
+
+type KeyParams struct {
+	Title string
+	Ext   string
+	Hash  string
+	Time  time.Time
+}
+
+// ParseKeyTemplate returns the template for a naming strategy. template is
+// only used, and required, for KeyStrategyTemplate.
+func ParseKeyTemplate(strategy string, template string) (KeyTemplate, error) {
+	if strategy != KeyStrategyTemplate {
+		predefined, ok := keyStrategyTemplates[strategy]
+		if !ok {
+			return "", fmt.Errorf("invalid key strategy %q", strategy)
+		}
+		return KeyTemplate(predefined), nil
+	}
+
+	if template == "" {
+		return "", fmt.Errorf("key template is required for the %q strategy", KeyStrategyTemplate)
+	}
+	for _, placeholder := range keyPlaceholder.FindAllString(template, -1) {
+		if !keyPlaceholders[placeholder] {
+			return "", fmt.Errorf("unknown placeholder %s in key template", placeholder)
+		}
+	}
+
+	return KeyTemplate(template), nil
+}
+
+// Unique reports whether every rendered key is unique by construction. Keys
+// from other templates must be checked for collisions.
+func (t KeyTemplate) Unique() bool {
+	return strings.Contains(string(t), "{uuid}")
+}
+
+// ContentAddressed reports whether keys are derived from the content hash,
+// so that an existing key holds identical content and must not be given a
+// collision suffix.
+func (t KeyTemplate) ContentAddressed() bool {
+	return !t.Unique() && strings.Contains(string(t), "{hash}")
+}
+
+// Render builds a key. attempt starts at 1; later attempts add a "-N"
+// collision suffix before the extension.
+func (t KeyTemplate) Render(params KeyParams, attempt int) string {
+	suffix := ""
+	if attempt > 1 {
+		suffix = fmt.Sprintf("-%d", attempt)
+	}
+
+	template := string(t)
+	if !strings.Contains(template, "{ext}") {
+		template += suffix
+	}
+
+	slug := Slugify(params.Title)
+	if slug == "" {
+		slug = "untitled"
+	}
+
+	date := params.Time.UTC()
+	replacer := strings.NewReplacer(
+		"{title}", slug,
+		"{slug}", slug,
+		"{uuid}", uuid.New().String(),
+		"{hash}", params.Hash,
+		"{yyyy}", date.Format("2006"),
+		"{mm}", date.Format("01"),
+		"{dd}", date.Format("02"),
+		"{ext}", suffix+params.Ext,
+	)
+
+	return replacer.Replace(template)
+}
+
+// extPattern is the form of the extensions NormalizeExt keeps.
+var extPattern = regexp.MustCompile(`^\.[a-z0-9]{1,10}$`)
+
+// contentTypeExts are the extensions of the content types uploads are
+// detected as, used when the client file name has no usable extension.
+var contentTypeExts = map[string]string{
+	"image/avif":    ".avif",
+	"image/gif":     ".gif",
+	"image/jpeg":    ".jpg",
+	"image/png":     ".png",
+	"image/svg+xml": ".svg",
+	"image/webp":    ".webp",
+}
+
+// NormalizeExt returns the extension of a key for an upload: the extension
+// of the client file name, lower cased, when it is a dot followed by 1 to 10
+// letters and digits, and otherwise the extension of contentType, or "" when
+// it has none.
+func NormalizeExt(filename string, contentType string) string {
+	ext := strings.ToLower(filepath.Ext(filename))
+	if extPattern.MatchString(ext) {
+		return ext
+	}
+
+	contentType, _, _ = strings.Cut(contentType, ";")
+	return contentTypeExts[strings.TrimSpace(contentType)]
+}
+
+// Slugify lowercases s, strips diacritics ("Café" -> "cafe") and replaces
+// every run of characters other than letters and digits with a single dash.
+// Letters from non-Latin scripts are kept. The result is cut to
+// MaxSlugLength runes.
+func Slugify(s string) string {
+	var b strings.Builder
+	dash := false
+	length := 0
+	for _, r := range norm.NFKD.String(strings.ToLower(s)) {
+		if unicode.Is(unicode.Mn, r) {
+			continue
+		}
+		if length >= MaxSlugLength {
+			break
+		}
+		if unicode.IsLetter(r) || unicode.IsDigit(r) {
+			b.WriteRune(r)
+			dash = false
+			length++
+			continue
+		}
+		if !dash && b.Len() > 0 {
+			b.WriteByte('-')
+			dash = true
+			length++
+		}
+	}
+
+	return norm.NFC.String(strings.TrimSuffix(b.String(), "-"))
+}
+
+// NormalizeTitle trims and NFC-normalizes a title and rejects titles that
+// would produce surprising keys.
+func NormalizeTitle(title string) (string, error) {
+	title = norm.NFC.String(strings.TrimSpace(title))
+
+	switch {
+	case title == "":
+		return "", fmt.Errorf("%w: title is required", ErrInvalidTitle)
+	case !utf8.ValidString(title):
+		return "", fmt.Errorf("%w: title must be valid UTF-8", ErrInvalidTitle)
+	case utf8.RuneCountInString(title) > MaxTitleLength:
+		return "", fmt.Errorf("%w: title must be at most %d characters", ErrInvalidTitle, MaxTitleLength)
+	case title == "." || title == "..":
+		return "", fmt.Errorf("%w: title must not be %q", ErrInvalidTitle, title)
+	case strings.ContainsAny(title, reservedTitleCharacters):
+		return "", fmt.Errorf("%w: title must not contain any of %s", ErrInvalidTitle, reservedTitleCharacters)
+	case strings.IndexFunc(title, unicode.IsControl) != -1:
+		return "", fmt.Errorf("%w: title must not contain control characters", ErrInvalidTitle)
+	}
+
+	return title, nil
+}
+
+// ValidateKey checks an object key received from a client.
+func ValidateKey(key string) error {
+	switch {
+	case key == "":
+		return fmt.Errorf("%w: key is required", ErrInvalidKey)
+	case !utf8.ValidString(key):
+		return fmt.Errorf("%w: key must be valid UTF-8", ErrInvalidKey)
+	case len(key) > MaxKeyLength:
+		return fmt.Errorf("%w: key must be at most %d bytes", ErrInvalidKey, MaxKeyLength)
+	case strings.HasPrefix(key, "/"):
+		return fmt.Errorf("%w: key must not start with a slash", ErrInvalidKey)
+	case strings.Contains(key, "\\"):
+		return fmt.Errorf("%w: key must not contain backslashes", ErrInvalidKey)
+	case strings.IndexFunc(key, unicode.IsControl) != -1:
+		return fmt.Errorf("%w: key must not contain control characters", ErrInvalidKey)
+	case IsReservedKey(key):
+		return fmt.Errorf("%w: key uses a reserved prefix", ErrInvalidKey)
+	}
+
+	for _, segment := range strings.Split(key, "/") {
+		switch segment {
+		case "":
+			return fmt.Errorf("%w: key must not contain empty path segments", ErrInvalidKey)
+		case ".", "..":
+			return fmt.Errorf("%w: key must not contain %q segments", ErrInvalidKey, segment)
+		}
+	}
+
+	return nil
+}
@@ -0,0 +1,258 @@
+package utils
+
+import (
+	"errors"
+	"strings"
+	"testing"
+	"time"
+	"unicode/utf8"
+)
+
+func TestSlugify(t *testing.T) {
+	tests := []struct {
+		title string
+		want  string
+	}{
+		{"Hello World", "hello-world"},
+		{"  Leading and trailing  ", "leading-and-trailing"},
+		{"Café crème", "cafe-creme"},
+		{"Ünïcödé", "unicode"},
+		{"../../etc/passwd", "etc-passwd"},
+		{"a/b\\c", "a-b-c"},
+		{"multiple   spaces---and___dashes", "multiple-spaces-and-dashes"},
+		{"東京 タワー", "東京-タワー"},
+		{"Москва 2024", "москва-2024"},
+		{"ﬁle", "file"},
+		{"!!!", ""},
+		{"", ""},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.title, func(t *testing.T) {
+			if got := Slugify(tt.title); got != tt.want {
+				t.Errorf("Slugify(%q) = %q, want %q", tt.title, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestSlugifyLength(t *testing.T) {
+	got := Slugify(strings.Repeat("ab ", 100))
+	if n := utf8.RuneCountInString(got); n > MaxSlugLength {
+		t.Errorf("slug is %d runes, want at most %d", n, MaxSlugLength)
+	}
+	if strings.HasSuffix(got, "-") {
+		t.Errorf("slug %q ends with a dash", got)
+	}
+
+	got = Slugify(strings.Repeat("é", 2*MaxSlugLength))
+	if want := strings.Repeat("e", MaxSlugLength); got != want {
+		t.Errorf("Slugify of accented runes = %q, want %q", got, want)
+	}
+}
+
+func TestValidateKey(t *testing.T) {
+	tests := []struct {
+		name  string
+		key   string
+		valid bool
+	}{
+		{"simple", "photo_0b4d.jpg", true},
+		{"nested", "2024/05/01/photo.jpg", true},
+		{"unicode", "東京-タワー.png", true},
+		{"spaces", "my photo.jpg", true},
+		{"empty", "", false},
+		{"invalid UTF-8", "photo\xff.jpg", false},
+		{"too long", strings.Repeat("a", MaxKeyLength+1), false},
+		{"maximum length", strings.Repeat("a", MaxKeyLength), true},
+		{"leading slash", "/photo.jpg", false},
+		{"backslash", "a\\b.jpg", false},
+		{"control character", "photo\n.jpg", false},
+		{"empty segment", "a//b.jpg", false},
+		{"trailing slash", "a/", false},
+		{"dot segment", "a/./b.jpg", false},
+		{"dot dot segment", "../b.jpg", false},
+		{"dot dot in a name", "a..b.jpg", true},
+		{"content prefix", ContentPrefix + "abc", false},
+		{"originals prefix", OriginalsPrefix + "photo.jpg", false},
+		{"shares prefix", SharesPrefix + "token", false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := ValidateKey(tt.key)
+			if tt.valid && err != nil {
+				t.Errorf("ValidateKey(%q): %v", tt.key, err)
+			}
+			if !tt.valid && !errors.Is(err, ErrInvalidKey) {
+				t.Errorf("ValidateKey(%q) returned %v, want ErrInvalidKey", tt.key, err)
+			}
+		})
+	}
+}
+
+func TestNormalizeExt(t *testing.T) {
+	tests := []struct {
+		name        string
+		filename    string
+		contentType string
+		want        string
+	}{
+		{"plain", "photo.jpg", "image/jpeg", ".jpg"},
+		{"upper case", "PHOTO.JPEG", "image/jpeg", ".jpeg"},
+		{"no extension", "photo", "image/png", ".png"},
+		{"content type with parameters", "photo", "image/svg+xml; charset=utf-8", ".svg"},
+		{"unknown content type", "photo", "application/octet-stream", ""},
+		{"backslash", "photo.jp\\..\\g", "image/jpeg", ".jpg"},
+		{"percent", "photo.%2e%2e", "image/png", ".png"},
+		{"control character", "photo.jp\ng", "image/jpeg", ".jpg"},
+		{"slash", "photo./etc", "image/png", ".png"},
+		{"too long", "photo." + strings.Repeat("a", 11), "image/png", ".png"},
+		{"maximum length", "photo." + strings.Repeat("a", 10), "image/png", "." + strings.Repeat("a", 10)},
+		{"non-ASCII", "photo.jpé", "image/jpeg", ".jpg"},
+		{"dot only", "photo.", "image/jpeg", ".jpg"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := NormalizeExt(tt.filename, tt.contentType); got != tt.want {
+				t.Errorf("NormalizeExt(%q, %q) = %q, want %q", tt.filename, tt.contentType, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestHostileFilenameKey(t *testing.T) {
+	filename := "..\\..\\evil.%00/\x7f" + strings.Repeat("x", 2*MaxKeyLength)
+	params := KeyParams{Title: "Holiday", Ext: NormalizeExt(filename, "image/png"), Hash: "abc123", Time: time.Now()}
+
+	for _, strategy := range []string{KeyStrategyLegacy, KeyStrategyUUID, KeyStrategyDate, KeyStrategySlug, KeyStrategyHash} {
+		template, err := ParseKeyTemplate(strategy, "")
+		if err != nil {
+			t.Fatalf("ParseKeyTemplate(%q): %v", strategy, err)
+		}
+		key := template.Render(params, 1)
+		if err := ValidateKey(key); err != nil {
+			t.Errorf("%s key %q is invalid: %v", strategy, key, err)
+		}
+		if !strings.HasSuffix(key, ".png") {
+			t.Errorf("%s key %q does not end with the extension of the content type", strategy, key)
+		}
+	}
+}
+
+func TestNormalizeTitle(t *testing.T) {
+	tests := []struct {
+		title string
+		want  string
+		valid bool
+	}{
+		{"  Holiday  ", "Holiday", true},
+		{"Café", "Café", true},
+		{"", "", false},
+		{"   ", "", false},
+		{"..", "", false},
+		{"a/b", "", false},
+		{"a\\b", "", false},
+		{"tab\there", "", false},
+		{strings.Repeat("x", MaxTitleLength), strings.Repeat("x", MaxTitleLength), true},
+		{strings.Repeat("x", MaxTitleLength+1), "", false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.title, func(t *testing.T) {
+			got, err := NormalizeTitle(tt.title)
+			if tt.valid && (err != nil || got != tt.want) {
+				t.Errorf("NormalizeTitle(%q) = %q, %v, want %q", tt.title, got, err, tt.want)
+			}
+			if !tt.valid && !errors.Is(err, ErrInvalidTitle) {
+				t.Errorf("NormalizeTitle(%q) returned %v, want ErrInvalidTitle", tt.title, err)
+			}
+		})
+	}
+}
+
+func TestKeyTemplateRender(t *testing.T) {
+	params := KeyParams{
+		Title: "Mon Café / Paris",
+		Ext:   ".jpg",
+		Hash:  "abc123",
+		Time:  time.Date(2024, 5, 1, 23, 0, 0, 0, time.FixedZone("UTC+2", 2*60*60)),
+	}
+
+	tests := []struct {
+		strategy string
+		template string
+		attempt  int
+		want     string
+	}{
+		{KeyStrategySlug, "", 1, "mon-cafe-paris.jpg"},
+		{KeyStrategySlug, "", 3, "mon-cafe-paris-3.jpg"},
+		{KeyStrategyHash, "", 1, "abc123.jpg"},
+		{KeyStrategyTemplate, "{yyyy}/{mm}/{dd}/{slug}{ext}", 1, "2024/05/01/mon-cafe-paris.jpg"},
+		{KeyStrategyTemplate, "{title}-{hash}{ext}", 1, "mon-cafe-paris-abc123.jpg"},
+		{KeyStrategyTemplate, "{slug}", 2, "mon-cafe-paris-2"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.strategy+" "+tt.template, func(t *testing.T) {
+			template, err := ParseKeyTemplate(tt.strategy, tt.template)
+			if err != nil {
+				t.Fatalf("ParseKeyTemplate: %v", err)
+			}
+			if got := template.Render(params, tt.attempt); got != tt.want {
+				t.Errorf("Render = %q, want %q", got, tt.want)
+			}
+		})
+	}
+
+	legacy, err := ParseKeyTemplate(KeyStrategyLegacy, "")
+	if err != nil {
+		t.Fatalf("ParseKeyTemplate: %v", err)
+	}
+	key := legacy.Render(params, 1)
+	if !strings.HasPrefix(key, "mon-cafe-paris_") || !strings.HasSuffix(key, ".jpg") {
+		t.Errorf("legacy key %q does not embed the slug", key)
+	}
+	if err := ValidateKey(key); err != nil {
+		t.Errorf("legacy key %q is invalid: %v", key, err)
+	}
+
+	untitled := legacy.Render(KeyParams{Title: "???", Ext: ".png"}, 1)
+	if !strings.HasPrefix(untitled, "untitled_") {
+		t.Errorf("key %q of a title without letters does not start with untitled_", untitled)
+	}
+}
+
+func TestParseKeyTemplate(t *testing.T) {
+	if _, err := ParseKeyTemplate("random", ""); err == nil {
+		t.Error("unknown strategy accepted")
+	}
+	if _, err := ParseKeyTemplate(KeyStrategyTemplate, ""); err == nil {
+		t.Error("empty template accepted")
+	}
+	if _, err := ParseKeyTemplate(KeyStrategyTemplate, "{name}{ext}"); err == nil {
+		t.Error("unknown placeholder accepted")
+	}
+
+	tests := []struct {
+		template         string
+		unique           bool
+		contentAddressed bool
+	}{
+		{"{uuid}{ext}", true, false},
+		{"{hash}{ext}", false, true},
+		{"{hash}-{uuid}{ext}", true, false},
+		{"{slug}{ext}", false, false},
+	}
+	for _, tt := range tests {
+		template, err := ParseKeyTemplate(KeyStrategyTemplate, tt.template)
+		if err != nil {
+			t.Fatalf("ParseKeyTemplate(%q): %v", tt.template, err)
+		}
+		if template.Unique() != tt.unique || template.ContentAddressed() != tt.contentAddressed {
+			t.Errorf("%q: Unique() = %v, ContentAddressed() = %v, want %v, %v",
+				tt.template, template.Unique(), template.ContentAddressed(), tt.unique, tt.contentAddressed)
+		}
+	}
+}
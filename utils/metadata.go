@@ -0,0 +1,244 @@
+package utils
+
+import (
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+	"unicode/utf8"
+)
+
+const (
+	// MaxMetadataSize is the S3 limit for all user metadata of an object,
+	// counted as the bytes of every key and value.
+	MaxMetadataSize = 2048
+	maxMetadataKey  = 64
+
+	MaxTags        = 10
+	maxTagKeyLen   = 128
+	maxTagValueLen = 256
+)
+
+var (
+	metadataKeyPattern = regexp.MustCompile(`^[a-z0-9][a-z0-9-]*$`)
+	tagPattern         = regexp.MustCompile(`^[\p{L}\p{Z}\p{N}_.:/=+\-@]*$`)
+)
+
+// CustomMetadata extracts the client supplied fields from object metadata,
+// without their MetaCustomPrefix.
+func CustomMetadata(metadata map[string]string) map[string]string {
+	var custom map[string]string
+	for k, v := range metadata {
+		name, ok := strings.CutPrefix(k, MetaCustomPrefix)
+		if !ok {
+			continue
+		}
+		if custom == nil {
+			custom = map[string]string{}
+		}
+		custom[name] = DecodeMetadataValue(v)
+	}
+	return custom
+}
+
+// SetCustomMetadata replaces the client supplied fields of metadata.
+func SetCustomMetadata(metadata map[string]string, custom map[string]string) {
+	for k := range metadata {
+		if strings.HasPrefix(k, MetaCustomPrefix) {
+			delete(metadata, k)
+		}
+	}
+	for k, v := range custom {
+		metadata[MetaCustomPrefix+k] = EncodeMetadataValue(v)
+	}
+}
+
+func ValidateCustomMetadata(custom map[string]string) error {
+	for k := range custom {
+		if len(k) > maxMetadataKey || !metadataKeyPattern.MatchString(k) {
+			return fmt.Errorf("%w: field %q must be at most %d lowercase letters, digits or dashes", ErrInvalidMetadata, k, maxMetadataKey)
+		}
+	}
+	return nil
+}
+
+// storedMetadataSizes are the largest values of the metadata fields the
+// service adds while storing an object, after its metadata was validated.
+var storedMetadataSizes = map[string]int{
+	MetaChecksumSHA256:      44,
+	MetaChecksumCRC32C:      8,
+	MetaContentRef:          len(ContentPrefix) + 64,
+	MetaContentHash:         64,
+	MetaContentSize:         20,
+	MetaEnvelopeKey:         80,
+	MetaEnvelopeKeyVersion:  32,
+	MetaEnvelopeChunkSize:   20,
+	MetaEnvelopeSize:        20,
+	MetaOriginalSize:        20,
+	MetaOriginalContentType: 32,
+	// Keys rendered from the templates stay well below this.
+	MetaOriginalKey: len(OriginalsPrefix) + 256,
+}
+
+// ValidateMetadataSize checks the S3 size limit of the complete metadata.
+func ValidateMetadataSize(metadata map[string]string) error {
+	return validateMetadataSize(metadata, 0)
+}
+
+// ValidateNewMetadataSize checks the metadata of an object about to be
+// stored, keeping room for the fields the service adds while storing it.
+func ValidateNewMetadataSize(metadata map[string]string) error {
+	reserved := 0
+	for k, maxSize := range storedMetadataSizes {
+		if _, ok := metadata[k]; !ok {
+			reserved += len(k) + maxSize
+		}
+	}
+	return validateMetadataSize(metadata, reserved)
+}
+
+func validateMetadataSize(metadata map[string]string, reserved int) error {
+	size := 0
+	for k, v := range metadata {
+		size += len(k) + len(v)
+	}
+	if size+reserved > MaxMetadataSize {
+		return fmt.Errorf("%w: metadata is %d bytes, the limit is %d", ErrInvalidMetadata, size, MaxMetadataSize-reserved)
+	}
+	return nil
+}
+
+// ValidateTags checks the S3 limits on object tags.
+func ValidateTags(tags map[string]string) error {
+	if len(tags) > MaxTags {
+		return fmt.Errorf("%w: at most %d tags are allowed", ErrInvalidTags, MaxTags)
+	}
+	for k, v := range tags {
+		switch {
+		case k == "" || utf8.RuneCountInString(k) > maxTagKeyLen:
+			return fmt.Errorf("%w: tag key must be 1 to %d characters", ErrInvalidTags, maxTagKeyLen)
+		case utf8.RuneCountInString(v) > maxTagValueLen:
+			return fmt.Errorf("%w: value of tag %q must be at most %d characters", ErrInvalidTags, k, maxTagValueLen)
+		case !tagPattern.MatchString(k) || !tagPattern.MatchString(v):
+			return fmt.Errorf("%w: tag %q contains characters S3 does not allow", ErrInvalidTags, k)
+		}
+	}
+	return nil
+}
+
+// Types of custom metadata fields in a MetadataSchema.
+const (
+	MetadataTypeString = "string"
+	MetadataTypeInt    = "int"
+	MetadataTypeFloat  = "float"
+	MetadataTypeBool   = "bool"
+	MetadataTypeDate   = "date"
+)
+
+type MetadataField struct {
+	Type      string
+	Required  bool
+	MaxLength int
+}
+
+// MetadataSchema describes the custom metadata fields clients may send.
+// Fields missing from the schema are accepted unless Strict is set.
+type MetadataSchema struct {
+	Fields map[string]MetadataField
+	Strict bool
+}
+
+// ParseMetadataSchema parses a comma separated list of field definitions of
+// the form name:type[:required][:max=N], e.g.
+// "plate:string:required:max=12,mileage:int".
+func ParseMetadataSchema(value string, strict bool) (MetadataSchema, error) {
+	schema := MetadataSchema{Fields: map[string]MetadataField{}, Strict: strict}
+	for _, definition := range strings.Split(value, ",") {
+		definition = strings.TrimSpace(definition)
+		if definition == "" {
+			continue
+		}
+
+		parts := strings.Split(definition, ":")
+		if len(parts) < 2 {
+			return schema, fmt.Errorf("metadata field %q must have the form name:type", definition)
+		}
+
+		name := parts[0]
+		if len(name) > maxMetadataKey || !metadataKeyPattern.MatchString(name) {
+			return schema, fmt.Errorf("invalid metadata field name %q", name)
+		}
+
+		field := MetadataField{Type: parts[1]}
+		switch field.Type {
+		case MetadataTypeString, MetadataTypeInt, MetadataTypeFloat, MetadataTypeBool, MetadataTypeDate:
+		default:
+			return schema, fmt.Errorf("unknown type %q of metadata field %q", field.Type, name)
+		}
+
+		for _, option := range parts[2:] {
+			if option == "required" {
+				field.Required = true
+				continue
+			}
+			limit, ok := strings.CutPrefix(option, "max=")
+			length, err := strconv.Atoi(limit)
+			if !ok || err != nil || length < 1 {
+				return schema, fmt.Errorf("invalid option %q of metadata field %q", option, name)
+			}
+			field.MaxLength = length
+		}
+
+		schema.Fields[name] = field
+	}
+	return schema, nil
+}
+
+// Validate checks custom metadata against the field names accepted by S3 and
+// the schema.
+func (s MetadataSchema) Validate(custom map[string]string) error {
+	err := ValidateCustomMetadata(custom)
+	if err != nil {
+		return err
+	}
+
+	for name, field := range s.Fields {
+		if _, ok := custom[name]; field.Required && !ok {
+			return fmt.Errorf("%w: field %q is required", ErrInvalidMetadata, name)
+		}
+	}
+
+	for name, value := range custom {
+		field, ok := s.Fields[name]
+		if !ok {
+			if s.Strict {
+				return fmt.Errorf("%w: unknown field %q", ErrInvalidMetadata, name)
+			}
+			continue
+		}
+
+		if field.MaxLength > 0 && utf8.RuneCountInString(value) > field.MaxLength {
+			return fmt.Errorf("%w: field %q must be at most %d characters", ErrInvalidMetadata, name, field.MaxLength)
+		}
+		if !validMetadataValue(field.Type, value) {
+			return fmt.Errorf("%w: field %q must be of type %s", ErrInvalidMetadata, name, field.Type)
+		}
+	}
+	return nil
+}
+
+func validMetadataValue(fieldType string, value string) bool {
+	var err error
+	switch fieldType {
+	case MetadataTypeInt:
+		_, err = strconv.ParseInt(value, 10, 64)
+	case MetadataTypeFloat:
+		_, err = strconv.ParseFloat(value, 64)
+	case MetadataTypeBool:
+		_, err = strconv.ParseBool(value)
+	case MetadataTypeDate:
+		_, err = time.Parse(time.DateOnly, value)
+	}
+	return err == nil
+}
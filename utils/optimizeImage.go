@@ -0,0 +1,77 @@
+package utils
+
+import (
+	"bytes"
+	"image"
+	"image/jpeg"
+	"image/png"
+)
+
+var imageFormats = map[string]struct {
+	ContentType string
+	Ext         string
+}{
+	"jpeg": {ContentType: "image/jpeg", Ext: ".jpg"},
+	"png":  {ContentType: "image/png", Ext: ".png"},
+}
+
+type OptimizePolicy struct {
+	JPEGQuality int
+	// Convert maps a source format to the format it is re-encoded as, e.g.
+	// "png" -> "jpeg". Images with transparency are never converted to JPEG.
+	Convert map[string]string
+}
+
+type OptimizedImage struct {
+	Data        []byte
+	Format      string
+	ContentType string
+	Ext         string
+}
+
+func IsImageFormat(format string) bool {
+	_, ok := imageFormats[format]
+	return ok
+}
+
+// OptimizeImage re-encodes img according to policy. PNGs are written with
+// maximum compression and JPEGs with the policy quality. The caller decides
+// whether the result is worth keeping by comparing sizes.
+func OptimizeImage(img image.Image, format string, policy OptimizePolicy) (*OptimizedImage, error) {
+	target := format
+	if to, ok := policy.Convert[format]; ok {
+		target = to
+	}
+	if target == "jpeg" && format != "jpeg" && !isOpaque(img) {
+		target = format
+	}
+
+	var buf bytes.Buffer
+	var err error
+	switch target {
+	case "jpeg":
+		err = jpeg.Encode(&buf, img, &jpeg.Options{Quality: policy.JPEGQuality})
+	case "png":
+		encoder := png.Encoder{CompressionLevel: png.BestCompression}
+		err = encoder.Encode(&buf, img)
+	default:
+		return nil, ErrUnsupportedImage
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	return &OptimizedImage{
+		Data:        buf.Bytes(),
+		Format:      target,
+		ContentType: imageFormats[target].ContentType,
+		Ext:         imageFormats[target].Ext,
+	}, nil
+}
+
+func isOpaque(img image.Image) bool {
+	if o, ok := img.(interface{ Opaque() bool }); ok {
+		return o.Opaque()
+	}
+	return false
+}
@@ -0,0 +1,67 @@
+package utils
+
+import (
+	"fmt"
+	"net/url"
+	"regexp"
+	"strings"
+)
+
+// OriginPattern matches the Origin header of cross-origin requests. It is an
+// exact origin such as "https://app.example.com", a pattern where "*" stands
+// for one or more subdomain labels such as "https://*.example.com", or "*"
+// for any origin.
+type OriginPattern struct {
+	origin  string
+	pattern *regexp.Regexp
+}
+
+// ParseOriginPatterns parses a comma separated list of origin patterns.
+func ParseOriginPatterns(value string) ([]OriginPattern, error) {
+	var patterns []OriginPattern
+	for _, origin := range strings.Split(value, ",") {
+		origin = strings.TrimSpace(origin)
+		if origin == "" {
+			continue
+		}
+		if origin == "*" {
+			patterns = append(patterns, OriginPattern{origin: origin})
+			continue
+		}
+
+		parsed, err := url.Parse(strings.ReplaceAll(origin, "*", "wildcard"))
+		if err != nil || (parsed.Scheme != "http" && parsed.Scheme != "https") || parsed.Host == "" ||
+			parsed.Path != "" || parsed.RawQuery != "" || parsed.Fragment != "" || parsed.User != nil {
+			return nil, fmt.Errorf("invalid origin %q: expected scheme://host[:port]", origin)
+		}
+
+		pattern := OriginPattern{origin: strings.ToLower(origin)}
+		if strings.Contains(origin, "*") {
+			expr := strings.ReplaceAll(regexp.QuoteMeta(pattern.origin), `\*`, `[a-z0-9-]+(\.[a-z0-9-]+)*`)
+			pattern.pattern = regexp.MustCompile("^" + expr + "$")
+		}
+		patterns = append(patterns, pattern)
+	}
+	return patterns, nil
+}
+
+// Any reports whether the pattern allows every origin.
+func (p OriginPattern) Any() bool {
+	return p.origin == "*"
+}
+
+func (p OriginPattern) Matches(origin string) bool {
+	origin = strings.ToLower(origin)
+	switch {
+	case p.Any():
+		return true
+	case p.pattern != nil:
+		return p.pattern.MatchString(origin)
+	default:
+		return p.origin == origin
+	}
+}
+
+func (p OriginPattern) String() string {
+	return p.origin
+}
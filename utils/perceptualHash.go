@@ -0,0 +1,45 @@
+package utils
+
+import (
+	"fmt"
+	"image"
+	"math/bits"
+	"strconv"
+)
+
+// DifferenceHash computes the 64-bit dHash of img: the image is reduced to a
+// 9x8 grayscale grid and each bit records whether a cell is brighter than its
+// right neighbour. Resized or recompressed copies of the same picture produce
+// hashes within a small Hamming distance of each other.
+func DifferenceHash(img image.Image) uint64 {
+	grid := Resize(img, 9, 8)
+
+	var hash uint64
+	for y := 0; y < 8; y++ {
+		for x := 0; x < 8; x++ {
+			hash <<= 1
+			if luminance(grid, x, y) > luminance(grid, x+1, y) {
+				hash |= 1
+			}
+		}
+	}
+
+	return hash
+}
+
+func FormatPerceptualHash(hash uint64) string {
+	return fmt.Sprintf("%016x", hash)
+}
+
+func ParsePerceptualHash(value string) (uint64, error) {
+	return strconv.ParseUint(value, 16, 64)
+}
+
+func HammingDistance(a, b uint64) int {
+	return bits.OnesCount64(a ^ b)
+}
+
+func luminance(img *image.NRGBA, x, y int) float64 {
+	c := img.NRGBAAt(x, y)
+	return 0.299*float64(c.R) + 0.587*float64(c.G) + 0.114*float64(c.B)
+}
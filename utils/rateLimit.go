@@ -0,0 +1,157 @@
+package utils
+
+import (
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// RateLimit is a token bucket: Rate tokens are added per second, up to
+// Burst. A zero Rate disables the limit.
+type RateLimit struct {
+	Rate  float64
+	Burst int
+}
+
+func (l RateLimit) Enabled() bool {
+	return l.Rate > 0
+}
+
+// ParseRateLimit parses "rate" or "rate:burst". The burst defaults to the
+// rate, rounded up.
+func ParseRateLimit(value string) (RateLimit, error) {
+	rateValue, burstValue, hasBurst := strings.Cut(strings.TrimSpace(value), ":")
+
+	rate, err := strconv.ParseFloat(rateValue, 64)
+	if err != nil || rate < 0 || math.IsInf(rate, 0) || math.IsNaN(rate) {
+		return RateLimit{}, fmt.Errorf("invalid rate %q", rateValue)
+	}
+
+	burst := int(math.Max(1, math.Ceil(rate)))
+	if hasBurst {
+		burst, err = strconv.Atoi(burstValue)
+		if err != nil || burst < 1 {
+			return RateLimit{}, fmt.Errorf("invalid burst %q", burstValue)
+		}
+	}
+
+	return RateLimit{Rate: rate, Burst: burst}, nil
+}
+
+// ParseRouteRateLimits parses a comma separated list of route limits such as
+// "POST /upload=1:5,GET /list=10", keyed by method and path.
+func ParseRouteRateLimits(value string) (map[string]RateLimit, error) {
+	limits := map[string]RateLimit{}
+	for _, rule := range strings.Split(value, ",") {
+		rule = strings.TrimSpace(rule)
+		if rule == "" {
+			continue
+		}
+
+		route, limitValue, ok := strings.Cut(rule, "=")
+		method, path, hasPath := strings.Cut(strings.TrimSpace(route), " ")
+		if !ok || !hasPath || !strings.HasPrefix(path, "/") {
+			return nil, fmt.Errorf("invalid route rate limit %q: expected METHOD /path=rate[:burst]", rule)
+		}
+
+		limit, err := ParseRateLimit(limitValue)
+		if err != nil {
+			return nil, fmt.Errorf("invalid route rate limit %q: %v", rule, err)
+		}
+		limits[strings.ToUpper(method)+" "+path] = limit
+	}
+	return limits, nil
+}
+
+// sweepInterval is how often idle buckets are dropped.
+const sweepInterval = time.Minute
+
+// RateLimiter keeps a token bucket per key, in memory.
+type RateLimiter struct {
+	limit     RateLimit
+	mu        sync.Mutex
+	buckets   map[string]*tokenBucket
+	lastSweep time.Time
+}
+
+type tokenBucket struct {
+	tokens  float64
+	updated time.Time
+}
+
+func NewRateLimiter(limit RateLimit) *RateLimiter {
+	return &RateLimiter{
+		limit:     limit,
+		buckets:   map[string]*tokenBucket{},
+		lastSweep: time.Now(),
+	}
+}
+
+func (l *RateLimiter) Limit() RateLimit {
+	return l.limit
+}
+
+// Allow takes a token for key. When none is left it returns false and how
+// long until one is available.
+func (l *RateLimiter) Allow(key string) (bool, time.Duration) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	bucket := l.bucket(key, time.Now())
+	if bucket.tokens >= 1 {
+		bucket.tokens--
+		return true, 0
+	}
+
+	return false, l.delay(1 - bucket.tokens)
+}
+
+// Reserve takes n tokens for key, going into debt if needed, and returns how
+// long the caller must wait before using them. It is meant for throttling
+// byte streams, where n is at most the burst.
+func (l *RateLimiter) Reserve(key string, n int) time.Duration {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	bucket := l.bucket(key, time.Now())
+	bucket.tokens -= float64(n)
+	if bucket.tokens >= 0 {
+		return 0
+	}
+
+	return l.delay(-bucket.tokens)
+}
+
+// bucket returns the refilled bucket of key. Must be called with mu held.
+func (l *RateLimiter) bucket(key string, now time.Time) *tokenBucket {
+	if now.Sub(l.lastSweep) >= sweepInterval {
+		for k, bucket := range l.buckets {
+			if l.refilled(bucket, now) >= float64(l.limit.Burst) {
+				delete(l.buckets, k)
+			}
+		}
+		l.lastSweep = now
+	}
+
+	bucket, ok := l.buckets[key]
+	if !ok {
+		bucket = &tokenBucket{tokens: float64(l.limit.Burst), updated: now}
+		l.buckets[key] = bucket
+		return bucket
+	}
+
+	bucket.tokens = math.Min(l.refilled(bucket, now), float64(l.limit.Burst))
+	bucket.updated = now
+	return bucket
+}
+
+func (l *RateLimiter) refilled(bucket *tokenBucket, now time.Time) float64 {
+	return bucket.tokens + now.Sub(bucket.updated).Seconds()*l.limit.Rate
+}
+
+func (l *RateLimiter) delay(tokens float64) time.Duration {
+	return time.Duration(tokens / l.limit.Rate * float64(time.Second))
+}
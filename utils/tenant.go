@@ -0,0 +1,28 @@
+package utils
+
+import (
+	"fmt"
+	"regexp"
+)
+
+// tenantPattern keeps tenant IDs usable as a single key segment.
+var tenantPattern = regexp.MustCompile(`^[a-z0-9][a-z0-9-]{0,62}$`)
+
+// ValidateTenant checks that tenant is a lower case ID of letters, digits
+// and dashes.
+func ValidateTenant(tenant string) error {
+	if !tenantPattern.MatchString(tenant) {
+		return fmt.Errorf("invalid tenant %q: expected up to 63 lower case letters, digits and dashes", tenant)
+	}
+	return nil
+}
+
+// TenantKey returns the bucket key of objectKey for tenant. Objects of a
+// tenant live under TenantsPrefix followed by the tenant; the empty tenant
+// uses the bucket root.
+func TenantKey(tenant string, objectKey string) string {
+	if tenant == "" {
+		return objectKey
+	}
+	return TenantsPrefix + tenant + "/" + objectKey
+}
@@ -2,13 +2,16 @@ package utils
 
 import (
 	"errors"
+	"net/url"
+	"strings"
 )
 
 type Upload struct {
 	Length      int64
 	ContentType string
-	Prefix      string
 	Ext         string
+	Metadata    map[string]string
+	Tags        map[string]string
 }
 
 func ValidateContentType(contentType string, validMimeTypes []string) (err error) {
@@ -26,3 +29,26 @@ func ValidateContentType(contentType string, validMimeTypes []string) (err error
 
 	return nil
 }
+
+func IsReservedKey(key string) bool {
+	for _, prefix := range ReservedPrefixes {
+		if strings.HasPrefix(key, prefix) {
+			return true
+		}
+	}
+	return false
+}
+
+// EncodeMetadataValue makes free text safe to store as S3 user metadata,
+// which only allows ASCII header values.
+func EncodeMetadataValue(value string) string {
+	return url.PathEscape(value)
+}
+
+func DecodeMetadataValue(value string) string {
+	decoded, err := url.PathUnescape(value)
+	if err != nil {
+		return value
+	}
+	return decoded
+}
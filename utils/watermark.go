@@ -0,0 +1,133 @@
+package utils
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"image"
+	"image/color"
+	"image/draw"
+	"image/jpeg"
+	"image/png"
+	"io"
+	"math"
+	"os"
+)
+
+const (
+	WatermarkTopLeft     = "top-left"
+	WatermarkTopRight    = "top-right"
+	WatermarkBottomLeft  = "bottom-left"
+	WatermarkBottomRight = "bottom-right"
+	WatermarkCenter      = "center"
+
+	// watermarkMargin is the gap between the watermark and the image edge,
+	// relative to the shorter side of the image.
+	watermarkMargin = 0.02
+)
+
+type Watermark struct {
+	Image    image.Image
+	Position string
+	Opacity  float64
+	Scale    float64
+}
+
+func LoadWatermark(path string, position string, opacity float64, scale float64) (*Watermark, error) {
+	switch position {
+	case WatermarkTopLeft, WatermarkTopRight, WatermarkBottomLeft, WatermarkBottomRight, WatermarkCenter:
+	default:
+		return nil, fmt.Errorf("invalid watermark position %q", position)
+	}
+	if opacity <= 0 || opacity > 1 {
+		return nil, errors.New("watermark opacity must be in (0, 1]")
+	}
+	if scale <= 0 || scale > 1 {
+		return nil, errors.New("watermark scale must be in (0, 1]")
+	}
+
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	img, _, err := image.Decode(file)
+	if err != nil {
+		return nil, fmt.Errorf("error decoding watermark %s: %v", path, err)
+	}
+
+	return &Watermark{
+		Image:    img,
+		Position: position,
+		Opacity:  opacity,
+		Scale:    scale,
+	}, nil
+}
+
+// Apply returns a copy of img with the watermark drawn over it. The
+// watermark width is Scale times the image width, keeping its aspect ratio.
+func (w *Watermark) Apply(img image.Image) *image.NRGBA {
+	bounds := img.Bounds()
+	dst := image.NewNRGBA(image.Rect(0, 0, bounds.Dx(), bounds.Dy()))
+	draw.Draw(dst, dst.Bounds(), img, bounds.Min, draw.Src)
+
+	markBounds := w.Image.Bounds()
+	if markBounds.Dx() == 0 || markBounds.Dy() == 0 {
+		return dst
+	}
+
+	markW := int(math.Max(1, math.Round(float64(dst.Bounds().Dx())*w.Scale)))
+	markH := int(math.Max(1, math.Round(float64(markW)*float64(markBounds.Dy())/float64(markBounds.Dx()))))
+	mark := Resize(w.Image, markW, markH)
+
+	width, height := dst.Bounds().Dx(), dst.Bounds().Dy()
+	margin := int(math.Round(float64(min(width, height)) * watermarkMargin))
+
+	var origin image.Point
+	switch w.Position {
+	case WatermarkTopLeft:
+		origin = image.Pt(margin, margin)
+	case WatermarkTopRight:
+		origin = image.Pt(width-markW-margin, margin)
+	case WatermarkBottomLeft:
+		origin = image.Pt(margin, height-markH-margin)
+	case WatermarkCenter:
+		origin = image.Pt((width-markW)/2, (height-markH)/2)
+	default:
+		origin = image.Pt(width-markW-margin, height-markH-margin)
+	}
+
+	opacity := image.NewUniform(color.Alpha{A: uint8(math.Round(w.Opacity * 255))})
+	draw.DrawMask(dst, image.Rectangle{Min: origin, Max: origin.Add(mark.Bounds().Size())},
+		mark, image.Point{}, opacity, image.Point{}, draw.Over)
+
+	return dst
+}
+
+// DecodeImageLimited decodes data like DecodeImage, but first reads the
+// image header and refuses images with more than maxPixels pixels, whose
+// decoded form would not fit in a reasonable amount of memory.
+func DecodeImageLimited(data []byte, maxPixels int) (image.Image, string, error) {
+	config, _, err := image.DecodeConfig(bytes.NewReader(data))
+	if err != nil {
+		return nil, "", err
+	}
+	if int64(config.Width)*int64(config.Height) > int64(maxPixels) {
+		return nil, "", fmt.Errorf("%w: image is %dx%d, more than %d pixels", ErrUnsupportedImage, config.Width, config.Height, maxPixels)
+	}
+
+	return DecodeImage(data)
+}
+
+// EncodeImage writes img in the given format as returned by image.Decode.
+func EncodeImage(w io.Writer, img image.Image, format string) (contentType string, err error) {
+	switch format {
+	case "jpeg":
+		return "image/jpeg", jpeg.Encode(w, img, &jpeg.Options{Quality: 90})
+	case "png":
+		return "image/png", png.Encode(w, img)
+	default:
+		return "", fmt.Errorf("unsupported image format %q", format)
+	}
+}